@@ -1,25 +1,374 @@
 package bitswap
 
 import (
+	"context"
+	"sync"
 	"time"
-	mh "github.com/multiformats/go-multihash"
-	"../blocks"
+
+	proto "github.com/golang/protobuf/proto"
+	ds "github.com/ipfs/go-datastore"
+
+	blocks "../blocks"
+	msgproto "../msgproto"
+	peer "../peer"
+	swarm "../swarm"
 	u "../util"
 )
 
-// aliases
+// PartnerWantListMax is the bound for the number of keys we'll store per
+// partner. These are usually taken from the top of the Partner's WantList
+// advertisements.
+const PartnerWantListMax = 10
 
-type Ledger struct {
-	Owner     mh.Multihash
-	Partner   mh.Multihash
-	BytesSent uint64
-	BytesRecv uint64
-	Timestamp *time.Time
-}
+// ProtocolID is the protocol bitswap messages are sent with.
+const ProtocolID = msgproto.ProtocolID("/ipfs/bitswap")
+
+// RetryInterval is how often the wants the strategy refused are put to it
+// again, as the partners' standing may have changed since.
+var RetryInterval = time.Second * 30
+
+// KeySet is just a convenient alias for maps of keys, where we only care
+// about access/lookups.
+type KeySet map[u.Key]struct{}
 
+// BitSwap instances implement the bitswap protocol.
 type BitSwap struct {
-	Ledgers  map[u.Key]*Ledger       // key is peer.ID
-	HaveList map[u.Key]*blocks.Block // key is multihash
-	WantList []*mh.Multihash
-	// todo
+	// peer is the identity of this (local) node.
+	peer *peer.Peer
+
+	// net is the network messages are exchanged over.
+	net swarm.Network
+
+	// service carries the bitswap messages, separate from other protocols.
+	service *msgproto.Service
+
+	// datastore keeps partner ledgers across reconnects and restarts.
+	// Messages are handled concurrently, so access goes through dsLock.
+	datastore ds.Datastore
+	dsLock    sync.Mutex
+
+	// blocks is the local block service, used to answer partners' wants.
+	blocks *blocks.BlockService
+
+	// strategy decides which partners get the blocks they ask for.
+	strategy Strategy
+
+	// partners is a map of currently active bitswap relationships.
+	// The Ledger has the peer, and the connection works through net.
+	partners     LedgerMap
+	partnersLock sync.RWMutex
+
+	// wantList is the set of keys we want values for.
+	wantList KeySet
+
+	// listeners are waiting for the blocks in wantList, keyed by block key.
+	listeners map[u.Key][]chan *blocks.Block
+	wantLock  sync.Mutex
+
+	haltChan chan struct{}
+}
+
+// NewBitSwap creates a new BitSwap instance for the local peer p, exchanging
+// blocks over net and serving them out of bs. Ledgers are persisted in d.
+// It uses DebtRatioStrategy until SetStrategy is called.
+func NewBitSwap(p *peer.Peer, net swarm.Network, d ds.Datastore, bs *blocks.BlockService) *BitSwap {
+	bsw := &BitSwap{
+		peer:      p,
+		net:       net,
+		datastore: d,
+		blocks:    bs,
+		strategy:  DebtRatioStrategy,
+		partners:  LedgerMap{},
+		wantList:  KeySet{},
+		listeners: map[u.Key][]chan *blocks.Block{},
+		haltChan:  make(chan struct{}),
+	}
+	bsw.service = msgproto.NewService(ProtocolID, net.GetChannel(swarm.PBWrapper_BITSWAP), bsw)
+	return bsw
+}
+
+// Start up background goroutines needed by bitswap
+func (bs *BitSwap) Start() {
+	bs.service.Start()
+	go bs.retryLoop(RetryInterval)
+}
+
+// GetBlock attempts to retrieve a particular block from peers, until ctx is
+// done. The want is broadcast to every connected peer.
+func (bs *BitSwap) GetBlock(ctx context.Context, k u.Key) (*blocks.Block, error) {
+	u.DOut("Bitswap GetBlock: '%s'", k.Pretty())
+	resp := bs.listenFor(k)
+
+	mes := newMessage()
+	mes.AppendWanted(k)
+	for _, p := range bs.net.GetPeerList() {
+		if err := bs.send(ctx, p, mes); err != nil {
+			u.DOut("bitswap: want for '%s' not sent to '%s': %s", k.Pretty(), p.Key().Pretty(), err)
+		}
+	}
+
+	select {
+	case blk := <-resp:
+		return blk, nil
+	case <-ctx.Done():
+		bs.unlisten(k, resp)
+		return nil, ctx.Err()
+	}
+}
+
+// HaveBlock announces the existence of a block to bitswap, sending it to
+// every partner that has asked for it.
+func (bs *BitSwap) HaveBlock(blk *blocks.Block) error {
+	k := blk.Key()
+
+	bs.partnersLock.RLock()
+	var wanting []*Ledger
+	for _, l := range bs.partners {
+		if l.WantListContains(k) {
+			wanting = append(wanting, l)
+		}
+	}
+	bs.partnersLock.RUnlock()
+
+	for _, l := range wanting {
+		bs.sendBlock(l, blk)
+	}
+	return nil
+}
+
+// SetStrategy replaces the strategy used to decide whether to send blocks.
+func (bs *BitSwap) SetStrategy(s Strategy) {
+	bs.partnersLock.Lock()
+	bs.strategy = s
+	bs.partnersLock.Unlock()
+}
+
+// Halt stops the message handling routine. It must be called only once.
+func (bs *BitSwap) Halt() {
+	close(bs.haltChan)
+	bs.service.Halt()
+}
+
+// listenFor registers a channel on which the block named by k will be sent
+// once a partner delivers it.
+func (bs *BitSwap) listenFor(k u.Key) chan *blocks.Block {
+	resp := make(chan *blocks.Block, 1)
+
+	bs.wantLock.Lock()
+	bs.wantList[k] = struct{}{}
+	bs.listeners[k] = append(bs.listeners[k], resp)
+	bs.wantLock.Unlock()
+	return resp
+}
+
+// unlisten removes the given listener for k, and drops k from the want list
+// if nobody else is waiting for it.
+func (bs *BitSwap) unlisten(k u.Key, resp chan *blocks.Block) {
+	bs.wantLock.Lock()
+	defer bs.wantLock.Unlock()
+
+	ls := bs.listeners[k]
+	for i, l := range ls {
+		if l == resp {
+			ls = append(ls[:i], ls[i+1:]...)
+			break
+		}
+	}
+
+	if len(ls) == 0 {
+		delete(bs.listeners, k)
+		delete(bs.wantList, k)
+		return
+	}
+	bs.listeners[k] = ls
+}
+
+// HandleMessage decodes a message from p and handles it. It implements
+// msgproto.Handler; bitswap messages expect no response.
+func (bs *BitSwap) HandleMessage(p *peer.Peer, data []byte) ([]byte, error) {
+	pmes := new(PBMessage)
+	if err := proto.Unmarshal(data, pmes); err != nil {
+		u.PErr("Failed to decode bitswap message: %s", err)
+		return nil, msgproto.ErrBadRequest
+	}
+
+	bs.handleMessage(p, pmes)
+	return nil, nil
 }
+
+// retryLoop retries the refused wants every retry, until Halt.
+func (bs *BitSwap) retryLoop(retry time.Duration) {
+	tick := time.NewTicker(retry)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			bs.retryAllWants()
+		case <-bs.haltChan:
+			return
+		}
+	}
+}
+
+func (bs *BitSwap) handleMessage(p *peer.Peer, pmes *PBMessage) {
+	ledger := bs.getLedger(p)
+
+	for _, d := range pmes.GetBlocks() {
+		ledger.ReceivedBytes(len(d))
+
+		// hashing the data ensures we only deliver what was really asked for.
+		blk, err := blocks.NewBlock(d)
+		if err != nil {
+			u.PErr("bitswap: failed to construct received block: %s", err)
+			continue
+		}
+		bs.blockReceived(blk)
+	}
+
+	// the partner gave us something, so may now deserve what it was refused.
+	if len(pmes.GetBlocks()) > 0 {
+		bs.retryWants(ledger)
+	}
+
+	for _, k := range pmes.GetWantlist() {
+		bs.peerWantsBlock(ledger, u.Key(k))
+	}
+
+	bs.saveLedger(ledger)
+}
+
+// blockReceived delivers blk to everyone waiting for it.
+func (bs *BitSwap) blockReceived(blk *blocks.Block) {
+	k := blk.Key()
+
+	bs.wantLock.Lock()
+	ls, wanted := bs.listeners[k]
+	delete(bs.listeners, k)
+	delete(bs.wantList, k)
+	bs.wantLock.Unlock()
+
+	if !wanted {
+		u.DOut("bitswap: received unwanted block '%s'", k.Pretty())
+		return
+	}
+
+	for _, l := range ls {
+		l <- blk
+	}
+}
+
+// peerWantsBlock sends the block named by k to the ledger's partner if we
+// have it, otherwise remembers the want for when we do.
+func (bs *BitSwap) peerWantsBlock(ledger *Ledger, k u.Key) {
+	if !ledger.Wants(k) {
+		u.DOut("bitswap: want list of '%s' is full", ledger.Partner.Key().Pretty())
+		return
+	}
+
+	blk, err := bs.blocks.GetLocalBlock(k)
+	if err != nil {
+		// we don't have it (yet). HaveBlock will send it later.
+		return
+	}
+
+	bs.sendBlock(ledger, blk)
+}
+
+// retryWants sends the ledger's partner the blocks it wants that we have,
+// if the strategy now agrees.
+func (bs *BitSwap) retryWants(ledger *Ledger) {
+	for _, k := range ledger.WantList() {
+		blk, err := bs.blocks.GetLocalBlock(k)
+		if err != nil {
+			continue
+		}
+		bs.sendBlock(ledger, blk)
+	}
+}
+
+// retryAllWants calls retryWants for every partner.
+func (bs *BitSwap) retryAllWants() {
+	bs.partnersLock.RLock()
+	ledgers := make([]*Ledger, 0, len(bs.partners))
+	for _, l := range bs.partners {
+		ledgers = append(ledgers, l)
+	}
+	bs.partnersLock.RUnlock()
+
+	for _, l := range ledgers {
+		bs.retryWants(l)
+	}
+}
+
+// sendBlock sends blk to the ledger's partner, if the strategy agrees.
+// Refused wants are kept, and retried when the partner sends us blocks and
+// every RetryInterval, so the partner may get the block once it has
+// reciprocated.
+func (bs *BitSwap) sendBlock(ledger *Ledger, blk *blocks.Block) {
+	bs.partnersLock.RLock()
+	strategy := bs.strategy
+	bs.partnersLock.RUnlock()
+
+	if !strategy.ShouldSendBlock(ledger, blk) {
+		u.DOut("bitswap: strategy refused to send '%s' to '%s'",
+			blk.Key().Pretty(), ledger.Partner.Key().Pretty())
+		return
+	}
+
+	// accounted before sending, so the ledger is up to date by the time
+	// the partner has the block.
+	ledger.SentBytes(len(blk.Data))
+	ledger.NoLongerWants(blk.Key())
+	bs.saveLedger(ledger)
+
+	mes := newMessage()
+	mes.AppendBlock(blk)
+	if err := bs.send(context.Background(), ledger.Partner, mes); err != nil {
+		u.PErr("bitswap: failed to send '%s' to '%s': %s",
+			blk.Key().Pretty(), ledger.Partner.Key().Pretty(), err)
+	}
+}
+
+// send sends mes to p.
+func (bs *BitSwap) send(ctx context.Context, p *peer.Peer, mes *message) error {
+	data, err := mes.Marshal()
+	if err != nil {
+		return err
+	}
+	return bs.service.SendMessage(ctx, p, data)
+}
+
+// getLedger returns the ledger for p, loading it from the datastore (or
+// creating it) if needed. Loading means partners cannot wipe their debt by
+// reconnecting.
+func (bs *BitSwap) getLedger(p *peer.Peer) *Ledger {
+	bs.partnersLock.Lock()
+	defer bs.partnersLock.Unlock()
+
+	l, found := bs.partners[p.Key()]
+	if found {
+		return l
+	}
+
+	bs.dsLock.Lock()
+	l, err := loadLedger(bs.datastore, p)
+	bs.dsLock.Unlock()
+	if err != nil {
+		u.PErr("bitswap: failed to load ledger for '%s': %s", p.Key().Pretty(), err)
+		l = newLedger(p)
+	}
+	bs.partners[p.Key()] = l
+	return l
+}
+
+func (bs *BitSwap) saveLedger(l *Ledger) {
+	bs.dsLock.Lock()
+	defer bs.dsLock.Unlock()
+
+	if err := l.save(bs.datastore); err != nil {
+		u.PErr("bitswap: failed to save ledger for '%s': %s", l.Partner.Key().Pretty(), err)
+	}
+}
+
+var _ blocks.Exchange = &BitSwap{}
@@ -0,0 +1,242 @@
+package bitswap
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+
+	blocks "../blocks"
+	peer "../peer"
+	swarm "../swarm"
+	u "../util"
+)
+
+// pipeNet is a swarm.Network stand-in that delivers every sent message
+// straight to the other end of the pipe.
+type pipeNet struct {
+	swarm.Network
+
+	local *peer.Peer
+	other *pipeNet
+	Chan  *swarm.Chan
+}
+
+func newPipeNets(a, b *peer.Peer) (*pipeNet, *pipeNet) {
+	na := &pipeNet{local: a, Chan: swarm.NewChan(10)}
+	nb := &pipeNet{local: b, Chan: swarm.NewChan(10)}
+	na.other, nb.other = nb, na
+
+	go na.forward()
+	go nb.forward()
+	return na, nb
+}
+
+func (n *pipeNet) forward() {
+	for mes := range n.Chan.Outgoing {
+		n.other.Chan.Incoming <- &swarm.Message{Peer: n.local, Data: mes.Data}
+	}
+}
+
+func (n *pipeNet) GetChannel(swarm.PBWrapper_MessageType) *swarm.Chan {
+	return n.Chan
+}
+
+func (n *pipeNet) GetPeerList() []*peer.Peer {
+	return []*peer.Peer{n.other.local}
+}
+
+func newTestBitSwap(t *testing.T, p *peer.Peer, net swarm.Network) *BitSwap {
+	bsrv, err := blocks.NewBlockService(ds.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// map datastores are not safe to share between goroutines.
+	bs := NewBitSwap(p, net, ds.NewMapDatastore(), bsrv)
+	bs.SetStrategy(YesManStrategy)
+	bs.Start()
+	return bs
+}
+
+func TestGetBlockFromPartner(t *testing.T) {
+	pa := &peer.Peer{ID: peer.ID("peer_a")}
+	pb := &peer.Peer{ID: peer.ID("peer_b")}
+	neta, netb := newPipeNets(pa, pb)
+
+	bsa := newTestBitSwap(t, pa, neta)
+	bsb := newTestBitSwap(t, pb, netb)
+
+	blk, err := blocks.NewBlock([]byte("beep boop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bsb.blocks.AddBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, err := bsa.GetBlock(ctx, blk.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Data, blk.Data) {
+		t.Fatal("received block data is not equal.")
+	}
+
+	if sent, _ := bsb.getLedger(pa).Accounting(); sent != uint64(len(blk.Data)) {
+		t.Error("sender ledger did not record sent bytes")
+	}
+
+	bsa.Halt()
+	bsb.Halt()
+}
+
+func TestHaltAfterClose(t *testing.T) {
+	pa := &peer.Peer{ID: peer.ID("peer_a")}
+	pb := &peer.Peer{ID: peer.ID("peer_b")}
+	neta, _ := newPipeNets(pa, pb)
+
+	bsa := newTestBitSwap(t, pa, neta)
+
+	// the message handler returns once the network closes.
+	close(neta.Chan.Incoming)
+	time.Sleep(time.Millisecond * 10)
+
+	done := make(chan struct{})
+	go func() {
+		bsa.Halt()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Halt blocked after the message handler returned")
+	}
+}
+
+func TestGetBlockTimeout(t *testing.T) {
+	pa := &peer.Peer{ID: peer.ID("peer_a")}
+	pb := &peer.Peer{ID: peer.ID("peer_b")}
+	neta, netb := newPipeNets(pa, pb)
+
+	bsa := newTestBitSwap(t, pa, neta)
+	bsb := newTestBitSwap(t, pb, netb)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := bsa.GetBlock(ctx, u.Key("missing"))
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected timeout, got", err)
+	}
+
+	if len(bsa.wantList) != 0 {
+		t.Error("want list was not cleaned up after timeout")
+	}
+
+	bsa.Halt()
+	bsb.Halt()
+}
+
+// reciprocalStrategy only sends blocks to partners that sent us some.
+var reciprocalStrategy = StrategyFunc(func(l *Ledger, blk *blocks.Block) bool {
+	_, recv := l.Accounting()
+	return recv > 0
+})
+
+func TestRetryRefusedWant(t *testing.T) {
+	pa := &peer.Peer{ID: peer.ID("peer_a")}
+	pb := &peer.Peer{ID: peer.ID("peer_b")}
+	neta, netb := newPipeNets(pa, pb)
+
+	bsa := newTestBitSwap(t, pa, neta)
+	bsb := newTestBitSwap(t, pb, netb)
+	bsb.SetStrategy(reciprocalStrategy)
+
+	blk, err := blocks.NewBlock([]byte("for those who give"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gift, err := blocks.NewBlock([]byte("a gift"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bsb.blocks.AddBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bsa.blocks.AddBlock(gift); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := bsa.GetBlock(ctx, blk.Key())
+		got <- err
+	}()
+
+	// b refuses a, which has given it nothing yet.
+	time.Sleep(time.Millisecond * 20)
+	select {
+	case err := <-got:
+		t.Fatal("block sent to a partner that gave nothing", err)
+	default:
+	}
+
+	// once a gives b a block, b reconsiders the refused want.
+	if _, err := bsb.GetBlock(ctx, gift.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-got; err != nil {
+		t.Fatal("refused want was not retried", err)
+	}
+
+	bsa.Halt()
+	bsb.Halt()
+}
+
+func TestRetryWantsOnTick(t *testing.T) {
+	old := RetryInterval
+	RetryInterval = time.Millisecond * 20
+	defer func() { RetryInterval = old }()
+
+	pa := &peer.Peer{ID: peer.ID("peer_a")}
+	pb := &peer.Peer{ID: peer.ID("peer_b")}
+	neta, netb := newPipeNets(pa, pb)
+
+	bsa := newTestBitSwap(t, pa, neta)
+	bsb := newTestBitSwap(t, pb, netb)
+	bsb.SetStrategy(reciprocalStrategy)
+
+	blk, err := blocks.NewBlock([]byte("patience"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bsb.blocks.AddBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// b has a change of heart after refusing the want.
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		bsb.SetStrategy(YesManStrategy)
+	}()
+
+	if _, err := bsa.GetBlock(ctx, blk.Key()); err != nil {
+		t.Fatal("refused want was not retried", err)
+	}
+
+	bsa.Halt()
+	bsb.Halt()
+}
@@ -0,0 +1,199 @@
+package bitswap
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+
+	peer "../peer"
+	u "../util"
+)
+
+// Ledger stores the data exchange relationship between two peers.
+type Ledger struct {
+	lock sync.RWMutex
+
+	// Partner is the remote Peer.
+	Partner *peer.Peer
+
+	// BytesSent counts the bytes of block data sent to Partner.
+	BytesSent uint64
+
+	// BytesRecv counts the bytes of block data received from Partner.
+	BytesRecv uint64
+
+	// Timestamp is the time of the last data exchange.
+	Timestamp *time.Time
+
+	// exchangeCount is the number of exchanges with this peer
+	exchangeCount uint64
+
+	// wantList is a (bounded, small) set of keys that Partner desires.
+	wantList KeySet
+}
+
+// LedgerMap lists Ledgers by their Partner key.
+type LedgerMap map[u.Key]*Ledger
+
+func newLedger(p *peer.Peer) *Ledger {
+	return &Ledger{
+		Partner:  p,
+		wantList: KeySet{},
+	}
+}
+
+// SentBytes records n bytes of block data sent to Partner.
+func (l *Ledger) SentBytes(n int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.exchanged()
+	l.BytesSent += uint64(n)
+}
+
+// ReceivedBytes records n bytes of block data received from Partner.
+func (l *Ledger) ReceivedBytes(n int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.exchanged()
+	l.BytesRecv += uint64(n)
+}
+
+// DebtRatio returns the ratio of bytes sent to Partner over bytes received
+// from it. The +1 avoids dividing by zero for new partners.
+func (l *Ledger) DebtRatio() float64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return float64(l.BytesSent) / float64(l.BytesRecv+1)
+}
+
+// exchanged updates the exchange stats. callers must hold the lock.
+func (l *Ledger) exchanged() {
+	now := time.Now()
+	l.Timestamp = &now
+	l.exchangeCount++
+}
+
+// Wants records that Partner wants the block named by k. Returns false if
+// Partner's want list is already full.
+func (l *Ledger) Wants(k u.Key) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, found := l.wantList[k]; found {
+		return true
+	}
+	if len(l.wantList) >= PartnerWantListMax {
+		return false
+	}
+	l.wantList[k] = struct{}{}
+	return true
+}
+
+// NoLongerWants removes k from Partner's want list.
+func (l *Ledger) NoLongerWants(k u.Key) {
+	l.lock.Lock()
+	delete(l.wantList, k)
+	l.lock.Unlock()
+}
+
+// WantListContains returns whether Partner wants the block named by k.
+func (l *Ledger) WantListContains(k u.Key) bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	_, found := l.wantList[k]
+	return found
+}
+
+// WantList returns the keys Partner wants.
+func (l *Ledger) WantList() []u.Key {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	out := make([]u.Key, 0, len(l.wantList))
+	for k := range l.wantList {
+		out = append(out, k)
+	}
+	return out
+}
+
+// Accounting returns the bytes of block data sent to and received from
+// Partner.
+func (l *Ledger) Accounting() (sent, recv uint64) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.BytesSent, l.BytesRecv
+}
+
+// ExchangeCount returns the number of data exchanges with Partner.
+func (l *Ledger) ExchangeCount() uint64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.exchangeCount
+}
+
+// ledgerRecord is the persisted form of a Ledger.
+type ledgerRecord struct {
+	BytesSent     uint64
+	BytesRecv     uint64
+	Timestamp     *time.Time
+	ExchangeCount uint64
+}
+
+// ledgerKey returns the datastore key under which p's ledger is kept.
+func ledgerKey(p *peer.Peer) ds.Key {
+	return ds.NewKey("/bitswap/ledgers/" + p.ID.Pretty())
+}
+
+// loadLedger reads p's ledger from d. A partner we never exchanged with
+// gets a fresh ledger.
+func loadLedger(d ds.Datastore, p *peer.Peer) (*Ledger, error) {
+	l := newLedger(p)
+
+	v, err := d.Get(ledgerKey(p))
+	if err == ds.ErrNotFound {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("ledger for %s is not a []byte", p.ID.Pretty())
+	}
+
+	var rec ledgerRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+
+	l.BytesSent = rec.BytesSent
+	l.BytesRecv = rec.BytesRecv
+	l.Timestamp = rec.Timestamp
+	l.exchangeCount = rec.ExchangeCount
+	return l, nil
+}
+
+// save writes the ledger's accounting into d.
+func (l *Ledger) save(d ds.Datastore) error {
+	l.lock.RLock()
+	rec := ledgerRecord{
+		BytesSent:     l.BytesSent,
+		BytesRecv:     l.BytesRecv,
+		Timestamp:     l.Timestamp,
+		ExchangeCount: l.exchangeCount,
+	}
+	l.lock.RUnlock()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return d.Put(ledgerKey(l.Partner), data)
+}
@@ -0,0 +1,32 @@
+package bitswap
+
+import (
+	proto "github.com/golang/protobuf/proto"
+
+	blocks "../blocks"
+	u "../util"
+)
+
+// message is a helper to build up a PBMessage before sending it to a peer.
+type message struct {
+	pb PBMessage
+}
+
+func newMessage() *message {
+	return new(message)
+}
+
+// AppendWanted adds k to the message's want list.
+func (m *message) AppendWanted(k u.Key) {
+	m.pb.Wantlist = append(m.pb.Wantlist, string(k))
+}
+
+// AppendBlock adds the data of b to the message.
+func (m *message) AppendBlock(b *blocks.Block) {
+	m.pb.Blocks = append(m.pb.Blocks, b.Data)
+}
+
+// Marshal encodes the message for sending.
+func (m *message) Marshal() ([]byte, error) {
+	return proto.Marshal(&m.pb)
+}
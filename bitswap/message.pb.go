@@ -0,0 +1,39 @@
+// Code generated by protoc-gen-go.
+// source: message.proto
+// DO NOT EDIT!
+
+package bitswap
+
+import proto "github.com/golang/protobuf/proto"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+type PBMessage struct {
+	Wantlist         []string `protobuf:"bytes,1,rep,name=wantlist" json:"wantlist,omitempty"`
+	Blocks           [][]byte `protobuf:"bytes,2,rep,name=blocks" json:"blocks,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *PBMessage) Reset()         { *m = PBMessage{} }
+func (m *PBMessage) String() string { return proto.CompactTextString(m) }
+func (*PBMessage) ProtoMessage()    {}
+
+func (m *PBMessage) GetWantlist() []string {
+	if m != nil {
+		return m.Wantlist
+	}
+	return nil
+}
+
+func (m *PBMessage) GetBlocks() [][]byte {
+	if m != nil {
+		return m.Blocks
+	}
+	return nil
+}
+
+func init() {
+}
@@ -0,0 +1,75 @@
+package bitswap
+
+import (
+	"math"
+	"math/rand"
+
+	blocks "../blocks"
+	u "../util"
+)
+
+// Strategy decides whether a block should be sent to a partner, given the
+// state of our Ledger with it.
+type Strategy interface {
+	ShouldSendBlock(l *Ledger, blk *blocks.Block) bool
+}
+
+// StrategyFunc adapts an ordinary function to the Strategy interface.
+type StrategyFunc func(l *Ledger, blk *blocks.Block) bool
+
+// ShouldSendBlock calls f(l, blk).
+func (f StrategyFunc) ShouldSendBlock(l *Ledger, blk *blocks.Block) bool {
+	return f(l, blk)
+}
+
+// YesManStrategy sends every block that is asked for.
+var YesManStrategy = StrategyFunc(func(*Ledger, *blocks.Block) bool {
+	return true
+})
+
+// DebtRatioStrategy sends blocks with a probability that falls as the
+// partner's debt ratio (bytes we sent / bytes we received) grows. Partners
+// that reciprocate keep getting blocks; freeloaders are slowly cut off.
+var DebtRatioStrategy = StrategyFunc(func(l *Ledger, blk *blocks.Block) bool {
+	return rand.Float64() <= probabilitySend(l.DebtRatio())
+})
+
+// probabilitySend is a sigmoid over the debt ratio: ~1 for balanced
+// exchanges, dropping sharply once a partner owes us about twice what
+// it gave.
+func probabilitySend(ratio float64) float64 {
+	x := 1 + math.Exp(6-3*ratio)
+	y := 1 / x
+	return 1 - y
+}
+
+// WhitelistStrategy always sends blocks to whitelisted partners, and asks
+// Fallback about everyone else.
+type WhitelistStrategy struct {
+	// Whitelist is the set of peer keys we always send to.
+	Whitelist KeySet
+
+	// Fallback decides for peers not in Whitelist.
+	Fallback Strategy
+}
+
+// NewWhitelistStrategy constructs a WhitelistStrategy for the given peer
+// keys, falling back to DebtRatioStrategy.
+func NewWhitelistStrategy(keys ...u.Key) *WhitelistStrategy {
+	ws := &WhitelistStrategy{
+		Whitelist: KeySet{},
+		Fallback:  DebtRatioStrategy,
+	}
+	for _, k := range keys {
+		ws.Whitelist[k] = struct{}{}
+	}
+	return ws
+}
+
+// ShouldSendBlock implements Strategy.
+func (ws *WhitelistStrategy) ShouldSendBlock(l *Ledger, blk *blocks.Block) bool {
+	if _, found := ws.Whitelist[l.Partner.Key()]; found {
+		return true
+	}
+	return ws.Fallback.ShouldSendBlock(l, blk)
+}
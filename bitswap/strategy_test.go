@@ -0,0 +1,74 @@
+package bitswap
+
+import (
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+
+	blocks "../blocks"
+	peer "../peer"
+)
+
+func TestProbabilitySend(t *testing.T) {
+	if probabilitySend(0) < 0.99 {
+		t.Error("new partners should almost always get blocks")
+	}
+
+	if probabilitySend(10) > 0.01 {
+		t.Error("heavy debtors should almost never get blocks")
+	}
+
+	last := 1.0
+	for r := 0.0; r < 5; r += 0.25 {
+		p := probabilitySend(r)
+		if p > last {
+			t.Fatalf("probabilitySend not monotonic at ratio %f", r)
+		}
+		last = p
+	}
+}
+
+func TestWhitelistStrategy(t *testing.T) {
+	friend := newLedger(&peer.Peer{ID: peer.ID("friend")})
+	stranger := newLedger(&peer.Peer{ID: peer.ID("stranger")})
+
+	// both owe us a lot.
+	friend.BytesSent = 1 << 30
+	stranger.BytesSent = 1 << 30
+
+	ws := NewWhitelistStrategy(friend.Partner.Key())
+	ws.Fallback = StrategyFunc(func(*Ledger, *blocks.Block) bool { return false })
+
+	if !ws.ShouldSendBlock(friend, nil) {
+		t.Error("whitelisted partner was refused")
+	}
+
+	if ws.ShouldSendBlock(stranger, nil) {
+		t.Error("non-whitelisted partner skipped the fallback")
+	}
+}
+
+func TestLedgerPersistence(t *testing.T) {
+	d := ds.NewMapDatastore()
+	p := &peer.Peer{ID: peer.ID("freeloader")}
+
+	l := newLedger(p)
+	l.SentBytes(1000)
+	l.ReceivedBytes(10)
+	if err := l.save(d); err != nil {
+		t.Fatal(err)
+	}
+
+	l2, err := loadLedger(d, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l2.BytesSent != 1000 || l2.BytesRecv != 10 {
+		t.Error("ledger accounting was not persisted", l2.BytesSent, l2.BytesRecv)
+	}
+
+	if l2.ExchangeCount() != 2 {
+		t.Error("exchange count was not persisted", l2.ExchangeCount())
+	}
+}
@@ -1,12 +1,24 @@
 package blocks
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	ds "github.com/ipfs/go-datastore"
+	b58 "github.com/jbenet/go-base58"
 	u "../util"
 	mh "github.com/multiformats/go-multihash"
+	"strings"
+	"sync"
 )
 
+// blockPrefix namespaces blocks in the datastore, apart from the other
+// values the node keeps there.
+const blockPrefix = "/blocks/"
+
+// sizeKey is where the number of bytes of block data held is kept.
+var sizeKey = ds.NewKey("/local/blocks/size")
+
 // Block is the ipfs blocks service. It is the way
 // to retrieve blocks by the higher level ipfs modules
 type Block struct {
@@ -28,33 +40,104 @@ func (b *Block) Key() u.Key {
 	return u.Key(b.Multihash)
 }
 
+// Exchange is the interface the BlockService uses to retrieve blocks it
+// does not hold locally from other peers (e.g. bitswap).
+type Exchange interface {
+	// GetBlock retrieves the block named by k from the network. It gives
+	// up, returning ctx.Err(), when ctx is done.
+	GetBlock(ctx context.Context, k u.Key) (*Block, error)
+
+	// HaveBlock announces that a block is now available locally.
+	HaveBlock(b *Block) error
+}
+
+// Datastore is a datastore that can enumerate its keys, as the
+// BlockService needs to list the blocks it holds.
+type Datastore interface {
+	ds.Datastore
+	KeyList() ([]ds.Key, error)
+}
+
 // BlockService is a block datastore.
 // It uses an internal `datastore.Datastore` instance to store values.
 type BlockService struct {
-	Datastore ds.Datastore
-	// Remote *bitswap.BitSwap // eventually.
+	Datastore Datastore
+	Remote    Exchange
+
+	// size is the number of bytes of block data held in Datastore. It is
+	// kept there too, so that it need not be counted on every start.
+	size     uint64
+	sizeLock sync.Mutex
 }
 
 // NewBlockService creates a BlockService with given datastore instance.
-func NewBlockService(d ds.Datastore) (*BlockService, error) {
+// rem may be nil, in which case only local blocks are served. Blocks kept
+// in d by older versions are moved under the current keys first.
+func NewBlockService(d Datastore, rem Exchange) (*BlockService, error) {
 	if d == nil {
 		return nil, fmt.Errorf("BlockService requires valid datastore")
 	}
-	return &BlockService{Datastore: d}, nil
+	if rem == nil {
+		u.DErr("BlockService running in local (offline) mode.")
+	}
+
+	if err := migrate(d); err != nil {
+		return nil, err
+	}
+
+	s := &BlockService{Datastore: d, Remote: rem}
+	if err := s.loadSize(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // AddBlock adds a particular block to the service, Putting it into the datastore.
 func (s *BlockService) AddBlock(b *Block) (u.Key, error) {
 	k := b.Key()
-	dsk := ds.NewKey(string(k))
-	return k, s.Datastore.Put(dsk, b.Data)
+	err := s.putBlock(b)
+	if err != nil {
+		return k, err
+	}
+
+	if s.Remote != nil {
+		err = s.Remote.HaveBlock(b)
+	}
+	return k, err
 }
 
 // GetBlock retrieves a particular block from the service,
-// Getting it from the datastore using the key (hash).
-func (s *BlockService) GetBlock(k u.Key) (*Block, error) {
-	dsk := ds.NewKey(string(k))
-	datai, err := s.Datastore.Get(dsk)
+// Getting it from the datastore using the key (hash). If the block is not
+// held locally, it is fetched through the Remote exchange within ctx,
+// stored, and announced as available.
+func (s *BlockService) GetBlock(ctx context.Context, k u.Key) (*Block, error) {
+	b, err := s.GetLocalBlock(k)
+	if err != ds.ErrNotFound || s.Remote == nil {
+		return b, err
+	}
+
+	u.DOut("BlockService: fetching '%s' from the network", k.Pretty())
+	b, err = s.Remote.GetBlock(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep what we fetched, so we need not ask again.
+	if err := s.putBlock(b); err != nil {
+		return nil, err
+	}
+
+	// we can serve it now. failing to say so is no reason to fail the get.
+	if err := s.Remote.HaveBlock(b); err != nil {
+		u.PErr("BlockService: failed to announce '%s': %s", k.Pretty(), err)
+	}
+	return b, nil
+}
+
+// GetLocalBlock retrieves a particular block from the datastore only,
+// never reaching out to the network.
+func (s *BlockService) GetLocalBlock(k u.Key) (*Block, error) {
+	datai, err := s.Datastore.Get(blockKey(k))
 	if err != nil {
 		return nil, err
 	}
@@ -69,3 +152,118 @@ func (s *BlockService) GetBlock(k u.Key) (*Block, error) {
 		Data:      data,
 	}, nil
 }
+
+// DeleteBlock removes a particular block from the datastore. It is not
+// announced to the Remote exchange.
+func (s *BlockService) DeleteBlock(k u.Key) error {
+	s.sizeLock.Lock()
+	defer s.sizeLock.Unlock()
+
+	b, err := s.GetLocalBlock(k)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Datastore.Delete(blockKey(k)); err != nil {
+		return err
+	}
+	s.size -= uint64(len(b.Data))
+	return s.storeSize()
+}
+
+// Size returns the number of bytes of block data held in the datastore.
+func (s *BlockService) Size() uint64 {
+	s.sizeLock.Lock()
+	defer s.sizeLock.Unlock()
+	return s.size
+}
+
+// putBlock stores b, counting its size unless it was already held.
+func (s *BlockService) putBlock(b *Block) error {
+	s.sizeLock.Lock()
+	defer s.sizeLock.Unlock()
+
+	dsk := blockKey(b.Key())
+	has, err := s.Datastore.Has(dsk)
+	if err != nil {
+		return err
+	}
+	if has {
+		return nil
+	}
+
+	if err := s.Datastore.Put(dsk, b.Data); err != nil {
+		return err
+	}
+	s.size += uint64(len(b.Data))
+	return s.storeSize()
+}
+
+// loadSize reads the size kept in the datastore, counting it from the
+// blocks if it is not there yet.
+func (s *BlockService) loadSize() error {
+	val, err := s.Datastore.Get(sizeKey)
+	if err == ds.ErrNotFound {
+		return s.countSize()
+	}
+	if err != nil {
+		return err
+	}
+
+	data, ok := val.([]byte)
+	if !ok {
+		return fmt.Errorf("block storage size is not a []byte")
+	}
+	return json.Unmarshal(data, &s.size)
+}
+
+// countSize sets the size by reading every block, and keeps it.
+func (s *BlockService) countSize() error {
+	keys, err := s.LocalKeys()
+	if err != nil {
+		return err
+	}
+
+	s.size = 0
+	for _, k := range keys {
+		b, err := s.GetLocalBlock(k)
+		if err != nil {
+			return err
+		}
+		s.size += uint64(len(b.Data))
+	}
+	return s.storeSize()
+}
+
+// storeSize writes the size into the datastore.
+func (s *BlockService) storeSize() error {
+	data, err := json.Marshal(s.size)
+	if err != nil {
+		return err
+	}
+	return s.Datastore.Put(sizeKey, data)
+}
+
+// LocalKeys returns the keys of all the blocks held in the datastore.
+func (s *BlockService) LocalKeys() ([]u.Key, error) {
+	dskeys, err := s.Datastore.KeyList()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []u.Key
+	for _, dsk := range dskeys {
+		if !strings.HasPrefix(dsk.String(), blockPrefix) {
+			continue
+		}
+
+		keys = append(keys, u.Key(b58.Decode(dsk.String()[len(blockPrefix):])))
+	}
+	return keys, nil
+}
+
+// blockKey returns the datastore key under which the block named by k is
+// kept.
+func blockKey(k u.Key) ds.Key {
+	return ds.NewKey(blockPrefix + k.Pretty())
+}
@@ -2,6 +2,7 @@ package blocks
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	ds "github.com/jbenet/datastore.go"
 	u "../util"
@@ -11,7 +12,7 @@ import (
 func TestBlocks(t *testing.T) {
 
 	d := ds.NewMapDatastore()
-	bs, err := NewBlockService(d)
+	bs, err := NewBlockService(d, nil)
 	if err != nil {
 		t.Error("failed to construct block service", err)
 		return
@@ -47,7 +48,7 @@ func TestBlocks(t *testing.T) {
 		t.Error("returned key is not equal to block key", err)
 	}
 
-	b2, err := bs.GetBlock(b.Key())
+	b2, err := bs.GetBlock(context.Background(), b.Key())
 	if err != nil {
 		t.Error("failed to retrieve block from BlockService", err)
 		return
@@ -64,3 +65,138 @@ func TestBlocks(t *testing.T) {
 	fmt.Printf("key: %s\n", b.Key())
 	fmt.Printf("data: %v\n", b.Data)
 }
+
+// fakeExchange serves blocks out of a map, standing in for bitswap. It
+// remembers the blocks announced to it.
+type fakeExchange struct {
+	blocks    map[u.Key]*Block
+	announced []u.Key
+}
+
+func (e *fakeExchange) GetBlock(ctx context.Context, k u.Key) (*Block, error) {
+	b, found := e.blocks[k]
+	if !found {
+		return nil, u.ErrNotFound
+	}
+	return b, nil
+}
+
+func (e *fakeExchange) HaveBlock(b *Block) error {
+	e.announced = append(e.announced, b.Key())
+	return nil
+}
+
+func TestRemoteFallback(t *testing.T) {
+
+	b, err := NewBlock([]byte("beep boop"))
+	if err != nil {
+		t.Fatal("failed to construct block", err)
+	}
+
+	rem := &fakeExchange{blocks: map[u.Key]*Block{b.Key(): b}}
+	bs, err := NewBlockService(ds.NewMapDatastore(), rem)
+	if err != nil {
+		t.Fatal("failed to construct block service", err)
+	}
+
+	b2, err := bs.GetBlock(context.Background(), b.Key())
+	if err != nil {
+		t.Fatal("failed to fetch block through remote", err)
+	}
+
+	if !bytes.Equal(b.Data, b2.Data) {
+		t.Error("Block data is not equal.")
+	}
+
+	// should now be held locally, and announced.
+	delete(rem.blocks, b.Key())
+	if _, err := bs.GetLocalBlock(b.Key()); err != nil {
+		t.Error("fetched block was not stored locally", err)
+	}
+	if len(rem.announced) != 1 || rem.announced[0] != b.Key() {
+		t.Error("fetched block was not announced", rem.announced)
+	}
+
+	if _, err := bs.GetBlock(context.Background(), u.Key("missing")); err != u.ErrNotFound {
+		t.Error("expected ErrNotFound for missing block, got", err)
+	}
+}
+
+func TestSize(t *testing.T) {
+	d := ds.NewMapDatastore()
+	bs, err := NewBlockService(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBlock([]byte("twelve bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// adding a block twice counts it once.
+	for i := 0; i < 2; i++ {
+		if _, err := bs.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bs.Size() != 12 {
+		t.Error("expected 12 bytes, got", bs.Size())
+	}
+
+	// the size survives a restart.
+	bs2, err := NewBlockService(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs2.Size() != 12 {
+		t.Error("size was not kept, got", bs2.Size())
+	}
+
+	if err := bs2.DeleteBlock(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if bs2.Size() != 0 {
+		t.Error("expected 0 bytes after delete, got", bs2.Size())
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	d := ds.NewMapDatastore()
+
+	// as stored by older versions, next to a value that is not a block.
+	b, err := NewBlock([]byte("old block"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ds.NewKey(string(b.Key())), b.Data); err != nil {
+		t.Fatal(err)
+	}
+	other := ds.NewKey("/local/other")
+	if err := d.Put(other, []byte("not a block")); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := NewBlockService(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := bs.LocalKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != b.Key() {
+		t.Fatal("old block was not moved", keys)
+	}
+
+	if has, _ := d.Has(ds.NewKey(string(b.Key()))); has {
+		t.Error("old block key was kept")
+	}
+	if has, _ := d.Has(other); !has {
+		t.Error("value that is not a block was moved")
+	}
+	if bs.Size() != uint64(len(b.Data)) {
+		t.Error("migrated block was not counted, got", bs.Size())
+	}
+}
@@ -0,0 +1,67 @@
+package blocks
+
+import (
+	ds "github.com/ipfs/go-datastore"
+	u "../util"
+	"strings"
+)
+
+// layoutKey marks a datastore whose blocks are all kept under blockPrefix.
+var layoutKey = ds.NewKey("/local/blocks/layout")
+
+// migrate moves blocks stored by older versions, keyed by their raw
+// multihash, under blockPrefix. Such blocks are told apart from the other
+// values in the datastore by hashing: only a block's data hashes to its
+// key. It runs once per datastore.
+func migrate(d Datastore) error {
+	_, err := d.Get(layoutKey)
+	if err == nil {
+		return nil
+	}
+	if err != ds.ErrNotFound {
+		return err
+	}
+
+	dskeys, err := d.KeyList()
+	if err != nil {
+		return err
+	}
+
+	moved := 0
+	for _, dsk := range dskeys {
+		if strings.HasPrefix(dsk.String(), blockPrefix) {
+			continue
+		}
+
+		val, err := d.Get(dsk)
+		if err != nil {
+			return err
+		}
+
+		data, ok := val.([]byte)
+		if !ok {
+			continue
+		}
+
+		h, err := u.Hash(data)
+		if err != nil {
+			return err
+		}
+		if ds.NewKey(string(h)).String() != dsk.String() {
+			continue
+		}
+
+		if err := d.Put(blockKey(u.Key(h)), data); err != nil {
+			return err
+		}
+		if err := d.Delete(dsk); err != nil {
+			return err
+		}
+		moved++
+	}
+
+	if moved > 0 {
+		u.DOut("blocks: moved %d blocks under %s\n", moved, blockPrefix)
+	}
+	return d.Put(layoutKey, []byte("1"))
+}
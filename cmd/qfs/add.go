@@ -1,12 +1,15 @@
 package qfs
 
 import (
+	"context"
 	"fmt"
 	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
 	"../../core"
+	"../../gc"
 	"../../importer"
 	dag "../../merkledag"
+	ft "../../unixfs"
 	u "../../util"
 	mh "github.com/multiformats/go-multihash"
 	"io/ioutil"
@@ -26,6 +29,13 @@ var cmdIpfsAdd = &commander.Command{
     Note that directories are added recursively, to form the ipfs
     MerkleDAG. A smarter partial add with a staging area (like git)
     remains to be implemented.
+
+    Added objects are pinned recursively, so they are kept in local
+    storage. See 'ipfs pin'.
+
+    Files are arranged in balanced trees, good for random access.
+    Use -t to arrange them in trickle trees instead, good for
+    streaming playback.
 `,
 	Run:  addCmd,
 	Flag: *flag.NewFlagSet("ipfs-add", flag.ExitOnError),
@@ -33,6 +43,7 @@ var cmdIpfsAdd = &commander.Command{
 
 func init() {
 	cmdIpfsAdd.Flag.Bool("r", false, "add objects recursively")
+	cmdIpfsAdd.Flag.Bool("t", false, "use the trickle layout for files")
 }
 
 func addCmd(c *commander.Command, inp []string) error {
@@ -41,12 +52,40 @@ func addCmd(c *commander.Command, inp []string) error {
 		return nil
 	}
 
-	n, err := localNode()
+	n, err := localNode(false)
 	if err != nil {
 		return err
 	}
 
 	recursive := c.Flag.Lookup("r").Value.Get().(bool)
+
+	var layout importer.Layout
+	if c.Flag.Lookup("t").Value.Get().(bool) {
+		layout = &importer.TrickleLayout{
+			MaxLinks:    importer.DefaultLinksPerBlock,
+			LayerRepeat: importer.DefaultLayerRepeat,
+		}
+	} else {
+		layout = &importer.BalancedLayout{MaxLinks: importer.DefaultLinksPerBlock}
+	}
+
+	if err := addPins(n, inp, recursive, layout); err != nil {
+		return err
+	}
+
+	if err := n.Pinning.Flush(); err != nil {
+		return err
+	}
+
+	_, err = gc.MaybeGC(n.Blocks, n.Pinning, n.Config.Datastore)
+	return err
+}
+
+// addPins adds and recursively pins each path of inp. Collection is held
+// off until they are pinned.
+func addPins(n *core.IpfsNode, inp []string, recursive bool, layout importer.Layout) error {
+	defer n.Pinning.PinLock()()
+
 	var depth int
 	if recursive {
 		depth = -1
@@ -55,19 +94,25 @@ func addCmd(c *commander.Command, inp []string) error {
 	}
 
 	for _, fpath := range inp {
-		_, err := addPath(n, fpath, depth)
+		root, err := addPath(n, fpath, depth, layout)
 		if err != nil {
 			if !recursive {
 				return fmt.Errorf("%s is a directory. Use -r to add recursively", fpath)
 			}
 
 			u.PErr("error adding %s: %v\n", fpath, err)
+			continue
+		}
+
+		// ensure we keep what was added.
+		if err := n.Pinning.Pin(context.Background(), root, true); err != nil {
+			return err
 		}
 	}
-	return err
+	return nil
 }
 
-func addPath(n *core.IpfsNode, fpath string, depth int) (*dag.Node, error) {
+func addPath(n *core.IpfsNode, fpath string, depth int, layout importer.Layout) (*dag.Node, error) {
 	if depth == 0 {
 		return nil, ErrDepthLimitExceeded
 	}
@@ -78,15 +123,20 @@ func addPath(n *core.IpfsNode, fpath string, depth int) (*dag.Node, error) {
 	}
 
 	if fi.IsDir() {
-		return addDir(n, fpath, depth)
+		return addDir(n, fpath, depth, layout)
 	}
 
-	return addFile(n, fpath, depth)
+	return addFile(n, fpath, depth, layout)
 }
 
-func addDir(n *core.IpfsNode, fpath string, depth int) (*dag.Node, error) {
+func addDir(n *core.IpfsNode, fpath string, depth int, layout importer.Layout) (*dag.Node, error) {
 	tree := &dag.Node{}
 
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+
 	files, err := ioutil.ReadDir(fpath)
 	if err != nil {
 		return nil, err
@@ -95,7 +145,7 @@ func addDir(n *core.IpfsNode, fpath string, depth int) (*dag.Node, error) {
 	// construct nodes for containing files.
 	for _, f := range files {
 		fp := filepath.Join(fpath, f.Name())
-		nd, err := addPath(n, fp, depth-1)
+		nd, err := addPath(n, fp, depth-1, layout)
 		if err != nil {
 			return nil, err
 		}
@@ -105,29 +155,47 @@ func addDir(n *core.IpfsNode, fpath string, depth int) (*dag.Node, error) {
 		}
 	}
 
+	fsn := &ft.FSNode{
+		Type:  ft.TDirectory,
+		Mode:  fi.Mode().Perm(),
+		Mtime: fi.ModTime(),
+	}
+	tree.Data, err = fsn.GetBytes()
+	if err != nil {
+		return nil, err
+	}
+
 	return tree, addNode(n, tree, fpath)
 }
 
-func addFile(n *core.IpfsNode, fpath string, depth int) (*dag.Node, error) {
-	root, err := importer.NewDagFromFile(fpath)
+func addFile(n *core.IpfsNode, fpath string, depth int, layout importer.Layout) (*dag.Node, error) {
+	// blocks are written to the graph + local storage as they are read.
+	root, err := importer.BuildDagFromFile(fpath, n.DAG, importer.DefaultSplitter, layout)
 	if err != nil {
 		return nil, err
 	}
 
-	return root, addNode(n, root, fpath)
+	return root, reportAdded(root, fpath)
 }
 
 // addNode adds the node to the graph + local storage
 func addNode(n *core.IpfsNode, nd *dag.Node, fpath string) error {
 	// add the file to the graph + local storage
-	k, err := n.DAG.Put(nd)
+	_, err := n.DAG.Put(nd)
+	if err != nil {
+		return err
+	}
+
+	return reportAdded(nd, fpath)
+}
+
+// reportAdded prints the hash of the node added for fpath.
+func reportAdded(nd *dag.Node, fpath string) error {
+	k, err := nd.Key()
 	if err != nil {
 		return err
 	}
 
 	u.POut("added %s %s\n", fpath, mh.Multihash(k).B58String())
 	return nil
-
-	// ensure we keep it. atm no-op
-	// return n.PinDagNode(root)
 }
@@ -1,9 +1,16 @@
 package qfs
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
+	"../../importer"
+	ft "../../unixfs"
 	u "../../util"
+	"io"
+	"os"
 	)
 
 var cmdIpfsCat = &commander.Command{
@@ -24,18 +31,29 @@ func catCmd(c *commander.Command, inp []string) error {
 		return nil
 	}
 
-	n, err := localNode()
+	n, err := readNode()
 	if err != nil {
 		return err
 	}
 
 	for _, fn := range inp {
-		nd, err := n.Resolver.ResolvePath(fn)
+		nd, err := n.Resolver.ResolvePath(context.Background(), fn)
 		if err != nil {
 			return err
 		}
 
-		u.POut("%s", nd.Data)
+		// objects that are not unixfs files have their raw data shown.
+		var read io.Reader = bytes.NewReader(nd.Data)
+		if _, err := ft.FSNodeFromBytes(nd.Data); err == nil {
+			read, err = importer.NewDagReader(context.Background(), nd, n.DAG)
+			if err != nil {
+				return fmt.Errorf("cannot cat %s: %s", fn, err)
+			}
+		}
+
+		if _, err = io.Copy(os.Stdout, read); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,109 @@
+package qfs
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gonuts/flag"
+	"github.com/jbenet/commander"
+	config "../../config"
+	ci "../../crypto"
+	peer "../../peer"
+	u "../../util"
+	"os"
+	"path/filepath"
+)
+
+var cmdIpfsInit = &commander.Command{
+	UsageLine: "init",
+	Short:     "Initialize ipfs local configuration.",
+	Long: `ipfs init - Initialize ipfs local configuration.
+
+    Generates the keypair of the local node identity, and writes
+    the initial config file. The node's peer ID is the hash of its
+    public key.
+
+    Options:
+
+    -t <type>    key type: rsa (default) or ed25519
+    -b <bits>    number of bits for rsa keys (default 2048)
+    -f           overwrite an existing config, and its identity
+`,
+	Run:  initCmd,
+	Flag: *flag.NewFlagSet("ipfs-init", flag.ExitOnError),
+}
+
+func init() {
+	cmdIpfsInit.Flag.String("t", "rsa", "key type: rsa or ed25519")
+	cmdIpfsInit.Flag.Int("b", 2048, "number of bits for rsa keys")
+	cmdIpfsInit.Flag.Bool("f", false, "force overwrite of existing config")
+}
+
+func initCmd(c *commander.Command, inp []string) error {
+	filename, err := config.Filename("")
+	if err != nil {
+		return err
+	}
+
+	force := c.Flag.Lookup("f").Value.Get().(bool)
+	if _, err := os.Stat(filename); err == nil && !force {
+		return errors.New("ipfs configuration file already exists!\n" +
+			"Reinitializing would overwrite your keys.\n" +
+			"(use -f to force overwrite)")
+	}
+
+	var typ ci.KeyType
+	switch t := c.Flag.Lookup("t").Value.Get().(string); t {
+	case "rsa":
+		typ = ci.KeyType_RSA
+	case "ed25519":
+		typ = ci.KeyType_Ed25519
+	default:
+		return fmt.Errorf("unknown key type: %s", t)
+	}
+
+	bits := c.Flag.Lookup("b").Value.Get().(int)
+	if typ == ci.KeyType_RSA && bits < 1024 {
+		return errors.New("bitsize less than 1024 is considered unsafe")
+	}
+
+	u.POut("generating key pair...\n")
+	sk, pk, err := ci.GenerateKeyPair(typ, bits)
+	if err != nil {
+		return err
+	}
+
+	skbytes, err := sk.Bytes()
+	if err != nil {
+		return err
+	}
+
+	id, err := peer.IDFromPubKey(pk)
+	if err != nil {
+		return err
+	}
+
+	cfg := &config.Config{
+		Identity: &config.Identity{
+			PeerID:  id.Pretty(),
+			PrivKey: base64.StdEncoding.EncodeToString(skbytes),
+			Address: config.DefaultAddress,
+		},
+		Datastore: &config.Datastore{
+			Type: "leveldb",
+			Path: config.DefaultDatastorePath,
+		},
+		Bootstrap: config.DefaultBootstrapPeers,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	if err := config.WriteConfigFile(filename, cfg); err != nil {
+		return err
+	}
+
+	u.POut("initialized ipfs node with peer ID: %s\n", id.Pretty())
+	return nil
+}
@@ -1,8 +1,10 @@
 package qfs
 
 import (
+	"context"
 	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
+	ft "../../unixfs"
 	u "../../util"
 	)
 
@@ -16,6 +18,8 @@ var cmdIpfsLs = &commander.Command{
 
     <link base58 hash> <link size in bytes> <link name>
 
+    Files are listed as themselves, with their file size.
+
 `,
 	Run:  lsCmd,
 	Flag: *flag.NewFlagSet("ipfs-ls", flag.ExitOnError),
@@ -27,17 +31,29 @@ func lsCmd(c *commander.Command, inp []string) error {
 		return nil
 	}
 
-	n, err := localNode()
+	n, err := readNode()
 	if err != nil {
 		return err
 	}
 
 	for _, fn := range inp {
-		nd, err := n.Resolver.ResolvePath(fn)
+		nd, err := n.Resolver.ResolvePath(context.Background(), fn)
 		if err != nil {
 			return err
 		}
 
+		// files' links are their blocks, not entries worth listing.
+		fsn, err := ft.FSNodeFromBytes(nd.Data)
+		if err == nil && fsn.Type != ft.TDirectory {
+			h, err := nd.Multihash()
+			if err != nil {
+				return err
+			}
+
+			u.POut("%s %d %s\n", h.B58String(), fsn.FileSize(), fn)
+			continue
+		}
+
 		for _, link := range nd.Links {
 			u.POut("%s %d %s\n", link.Hash.B58String(), link.Size, link.Name)
 		}
@@ -30,7 +30,7 @@ func mountCmd(c *commander.Command, inp []string) error {
 		return nil
 	}
 
-	n, err := localNode()
+	n, err := localNode(true)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,164 @@
+package qfs
+
+import (
+	"context"
+	"github.com/gonuts/flag"
+	"github.com/jbenet/commander"
+	"../../pin"
+	u "../../util"
+)
+
+var cmdIpfsPin = &commander.Command{
+	UsageLine: "pin",
+	Short:     "Keep objects in local storage.",
+	Long: `ipfs pin <cmd> - Keep objects in local storage.
+
+    Pinned objects are never removed from local storage. Objects are
+    pinned directly (only the object), or recursively (the object
+    and all the objects it links to).
+
+Commands:
+
+    add <ipfs-path>    Pin an object.
+    rm <ipfs-path>     Unpin an object.
+    ls                 List pinned objects.
+`,
+	Run: pinCmd,
+	Subcommands: []*commander.Command{
+		cmdIpfsPinAdd,
+		cmdIpfsPinRm,
+		cmdIpfsPinLs,
+	},
+}
+
+func pinCmd(c *commander.Command, inp []string) error {
+	u.POut(c.Long)
+	return nil
+}
+
+var cmdIpfsPinAdd = &commander.Command{
+	UsageLine: "add",
+	Short:     "Pin an object.",
+	Long: `ipfs pin add <ipfs-path> - Pin an object.
+
+    Pins the object named by <ipfs-path>, fetching it if needed.
+    Use -r to pin everything it links to as well.
+`,
+	Run:  pinAddCmd,
+	Flag: *flag.NewFlagSet("ipfs-pin-add", flag.ExitOnError),
+}
+
+var cmdIpfsPinRm = &commander.Command{
+	UsageLine: "rm",
+	Short:     "Unpin an object.",
+	Long: `ipfs pin rm <ipfs-path> - Unpin an object.
+
+    Removes the pin on the object named by <ipfs-path>. Use -r to
+    remove a recursive pin.
+`,
+	Run:  pinRmCmd,
+	Flag: *flag.NewFlagSet("ipfs-pin-rm", flag.ExitOnError),
+}
+
+var cmdIpfsPinLs = &commander.Command{
+	UsageLine: "ls",
+	Short:     "List pinned objects.",
+	Long: `ipfs pin ls - List pinned objects.
+
+    Lists the pinned objects, with the following format:
+
+    <base58 hash> <pin mode>
+
+    Note: list indirectly pinned objects too with -a.
+`,
+	Run:  pinLsCmd,
+	Flag: *flag.NewFlagSet("ipfs-pin-ls", flag.ExitOnError),
+}
+
+func init() {
+	cmdIpfsPinAdd.Flag.Bool("r", false, "pin objects recursively")
+	cmdIpfsPinRm.Flag.Bool("r", false, "unpin objects recursively")
+	cmdIpfsPinLs.Flag.Bool("a", false, "list indirect pins too")
+}
+
+func pinAddCmd(c *commander.Command, inp []string) error {
+	if len(inp) < 1 {
+		u.POut(c.Long)
+		return nil
+	}
+
+	n, err := readNode()
+	if err != nil {
+		return err
+	}
+
+	recursive := c.Flag.Lookup("r").Value.Get().(bool)
+
+	// the objects fetched are only kept by collection once pinned.
+	unlock := n.Pinning.PinLock()
+	defer unlock()
+
+	for _, fn := range inp {
+		nd, err := n.Resolver.ResolvePath(context.Background(), fn)
+		if err != nil {
+			return err
+		}
+
+		if err := n.Pinning.Pin(context.Background(), nd, recursive); err != nil {
+			return err
+		}
+	}
+	return n.Pinning.Flush()
+}
+
+func pinRmCmd(c *commander.Command, inp []string) error {
+	if len(inp) < 1 {
+		u.POut(c.Long)
+		return nil
+	}
+
+	n, err := localNode(false)
+	if err != nil {
+		return err
+	}
+
+	recursive := c.Flag.Lookup("r").Value.Get().(bool)
+	for _, fn := range inp {
+		nd, err := n.Resolver.ResolvePath(context.Background(), fn)
+		if err != nil {
+			return err
+		}
+
+		k, err := nd.Key()
+		if err != nil {
+			return err
+		}
+
+		if err := n.Pinning.Unpin(context.Background(), k, recursive); err != nil {
+			return err
+		}
+	}
+	return n.Pinning.Flush()
+}
+
+func pinLsCmd(c *commander.Command, inp []string) error {
+	n, err := localNode(false)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range n.Pinning.RecursiveKeys() {
+		u.POut("%s %s\n", k.Pretty(), pin.Recursive)
+	}
+
+	for _, k := range n.Pinning.DirectKeys() {
+		u.POut("%s %s\n", k.Pretty(), pin.Direct)
+	}
+
+	if c.Flag.Lookup("a").Value.Get().(bool) {
+		for k := range n.Pinning.IndirectKeys() {
+			u.POut("%s %s\n", k.Pretty(), pin.Indirect)
+		}
+	}
+	return nil
+}
@@ -18,14 +18,17 @@ var CmdIpfs = &commander.Command{
 
 Basic commands:
 
+    init          Initialize ipfs local configuration.
     add <path>    Add an object to ipfs.
     cat <ref>     Show ipfs object data.
     ls <ref>      List links from an object.
     refs <ref>    List link hashes from an object.
+    pin <ref>     Keep objects in local storage.
 
 Tool commands:
 
     config        Manage configuration.
+    repo          Manage the local repository.
     version       Show ipfs version information.
     commands      List all available commands.
 
@@ -37,11 +40,14 @@ Use "ipfs help <command>" for more information about a command.
 `,
 	Run: ipfsCmd,
 	Subcommands: []*commander.Command{
+		cmdIpfsInit,
 		cmdIpfsAdd,
 		cmdIpfsCat,
 		cmdIpfsLs,
 		cmdIpfsRefs,
+		cmdIpfsPin,
 		cmdIpfsConfig,
+		cmdIpfsRepo,
 		cmdIpfsVersion,
 		cmdIpfsCommands,
 		cmdIpfsMount,
@@ -65,12 +71,25 @@ func main() {
 	return
 }
 
-func localNode() (*core.IpfsNode, error) {
+func localNode(online bool) (*core.IpfsNode, error) {
 	//todo implement config file flag
 	cfg, err := config.Load("")
 	if err != nil {
 		return nil, err
 	}
 
-	return core.NewIpfsNode(cfg)
+	return core.NewIpfsNode(cfg, online)
+}
+
+// readNode returns an offline node that goes online only if what is read
+// is not held locally, so local content can be read while a daemon holds
+// the network address, or without an identity.
+func readNode() (*core.IpfsNode, error) {
+	n, err := localNode(false)
+	if err != nil {
+		return nil, err
+	}
+
+	n.GoOnlineOnDemand()
+	return n, nil
 }
@@ -1,6 +1,7 @@
 package qfs
 
 import (
+	"context"
 	"github.com/gonuts/flag"
 	"github.com/jbenet/commander"
 	mdag "../../merkledag"
@@ -36,7 +37,7 @@ func refCmd(c *commander.Command, inp []string) error {
 		return nil
 	}
 
-	n, err := localNode()
+	n, err := readNode()
 	if err != nil {
 		return err
 	}
@@ -62,7 +63,7 @@ func refCmd(c *commander.Command, inp []string) error {
 		for _, link := range nd.Links {
 			printRef(link.Hash)
 			if recursive {
-				nd, err := n.DAG.Get(u.Key(link.Hash))
+				nd, err := n.DAG.Get(context.Background(), u.Key(link.Hash))
 				if err != nil {
 					u.PErr("error: cannot retrieve %s (%s)\n", link.Hash.B58String(), err)
 					return
@@ -75,7 +76,7 @@ func refCmd(c *commander.Command, inp []string) error {
 
 	for _, fn := range inp {
 		// for now only hashes, no path resolution
-		nd, err := n.Resolver.ResolvePath(fn)
+		nd, err := n.Resolver.ResolvePath(context.Background(), fn)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,59 @@
+package qfs
+
+import (
+	"github.com/gonuts/flag"
+	"github.com/jbenet/commander"
+	"../../gc"
+	u "../../util"
+)
+
+var cmdIpfsRepo = &commander.Command{
+	UsageLine: "repo",
+	Short:     "Manage the local repository.",
+	Long: `ipfs repo <cmd> - Manage the local repository.
+
+Commands:
+
+    gc    Remove unpinned objects from local storage.
+`,
+	Run: repoCmd,
+	Subcommands: []*commander.Command{
+		cmdIpfsRepoGC,
+	},
+}
+
+func repoCmd(c *commander.Command, inp []string) error {
+	u.POut(c.Long)
+	return nil
+}
+
+var cmdIpfsRepoGC = &commander.Command{
+	UsageLine: "gc",
+	Short:     "Remove unpinned objects from local storage.",
+	Long: `ipfs repo gc - Remove unpinned objects from local storage.
+
+    Removes every block that is not reachable from a pin, and shows
+    how much space was freed. See 'ipfs pin'.
+
+    Collection also runs on its own when the datastore.storagemax
+    config value is set, and storage goes past
+    datastore.storagegcwatermark percent of it.
+`,
+	Run:  repoGCCmd,
+	Flag: *flag.NewFlagSet("ipfs-repo-gc", flag.ExitOnError),
+}
+
+func repoGCCmd(c *commander.Command, inp []string) error {
+	n, err := localNode(false)
+	if err != nil {
+		return err
+	}
+
+	res, err := gc.GC(n.Blocks, n.Pinning)
+	if err != nil {
+		return err
+	}
+
+	u.POut("removed %d blocks, freed %d bytes\n", res.Removed, res.Freed)
+	return nil
+}
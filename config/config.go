@@ -1,36 +1,82 @@
 package config
 
 import (
+	"errors"
 	u "../util"
 	"os"
 )
 
+// ErrNotInitialized signals a missing config file.
+var ErrNotInitialized = errors.New("ipfs not initialized, please run 'ipfs init'")
+
 // Identity tracks the configuration of the local node's identity.
 type Identity struct {
-	PeerID string
+	// PeerID is the base58 multihash of the public key.
+	PeerID string `json:"peerid"`
+
+	// PrivKey is the base64 encoded, serialized private key.
+	PrivKey string `json:"privkey"`
+
+	Address string `json:"address"`
 }
 
 // Datastore tracks the configuration of the datastore.
 type Datastore struct {
-	Type string
-	Path string
+	Type string `json:"type"`
+	Path string `json:"path"`
+
+	// StorageMax is the number of bytes of blocks to keep before collecting
+	// garbage automatically. Zero disables automatic collection.
+	StorageMax uint64 `json:"storagemax,omitempty"`
+
+	// StorageGCWatermark is the percentage of StorageMax at which automatic
+	// collection starts. Zero means the default.
+	StorageGCWatermark uint64 `json:"storagegcwatermark,omitempty"`
+}
+
+// Routing tracks the configuration of the DHT routing table.
+type Routing struct {
+	// MaxPeersPerIP is the number of peers of a bucket that may share an
+	// IP address. Zero means no limit.
+	MaxPeersPerIP int `json:"maxpeersperip,omitempty"`
+
+	// MaxPeersPerSubnet is the number of peers of a bucket that may share
+	// an IPv4 /24 or IPv6 /48 subnet. Zero means no limit.
+	MaxPeersPerSubnet int `json:"maxpeerspersubnet,omitempty"`
+}
+
+// BootstrapPeer is a peer an online node connects to, to join the network.
+type BootstrapPeer struct {
+	Address string `json:"address"`
+
+	// PeerID is the base58 multihash of the peer's public key.
+	PeerID string `json:"peerid"`
 }
 
 // Config is used to load IPFS config files.
 type Config struct {
-	Identity  *Identity
-	Datastore *Datastore
+	Identity  *Identity        `json:"identity"`
+	Datastore *Datastore       `json:"datastore"`
+	Bootstrap []*BootstrapPeer `json:"bootstrap,omitempty"`
+	Routing   *Routing         `json:"routing,omitempty"`
 }
 
 var defaultConfigFilePath = "~/.go-ipfs/config"
-var defaultConfigFile = `{
-  "identity": {},
-  "datastore": {
-    "type": "leveldb",
-    "path": "~/.go-ipfs/datastore"
-  }
+
+// DefaultDatastorePath is where `ipfs init` puts the datastore.
+var DefaultDatastorePath = "~/.go-ipfs/datastore"
+
+// DefaultAddress is the address `ipfs init` makes the node listen on.
+var DefaultAddress = "/ip4/0.0.0.0/tcp/4001"
+
+// DefaultBootstrapPeers are the peers `ipfs init` makes the node join the
+// network through.
+var DefaultBootstrapPeers = []*BootstrapPeer{
+	&BootstrapPeer{
+		Address: "/ip4/104.131.131.82/tcp/4001",
+		PeerID:  "QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	},
 }
-`
 
 // Filename returns the proper tilde expanded config filename.
 func Filename(filename string) (string, error) {
@@ -49,11 +95,9 @@ func Load(filename string) (*Config, error) {
 		return nil, err
 	}
 
-	// if nothing is there, write first config file.
+	// if nothing is there, `ipfs init` was not run.
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		if err := WriteFile(filename, []byte(defaultConfigFile)); err != nil {
-			return nil, err
-		}
+		return nil, ErrNotInitialized
 	}
 
 	var cfg Config
@@ -1,17 +1,70 @@
 package config
 
 import (
-	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
 func TestConfig(t *testing.T) {
 
+	if _, err := Load(".ipfsconfig-missing"); err != ErrNotInitialized {
+		t.Error("expected ErrNotInitialized, got", err)
+	}
+
+	orig := &Config{
+		Identity:  &Identity{PeerID: "QmPeer", PrivKey: "a2V5", Address: DefaultAddress},
+		Datastore: &Datastore{Type: "leveldb", Path: "~/.go-ipfs/datastore"},
+		Bootstrap: DefaultBootstrapPeers,
+	}
+
+	if err := WriteConfigFile(".ipfsconfig", orig); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(".ipfsconfig")
+
 	cfg, err := Load(".ipfsconfig")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	fmt.Printf(cfg.Datastore.Path)
+	if cfg.Identity.PeerID != "QmPeer" || cfg.Identity.PrivKey != "a2V5" {
+		t.Error("identity was not read back", cfg.Identity)
+	}
+
+	if len(cfg.Bootstrap) != len(orig.Bootstrap) ||
+		cfg.Bootstrap[0].PeerID != orig.Bootstrap[0].PeerID {
+		t.Error("bootstrap peers were not read back", cfg.Bootstrap)
+	}
+
+	if cfg.Datastore.Path == orig.Datastore.Path {
+		t.Error("datastore path was not tilde expanded")
+	}
+
+	// keys are lowercase, as documented in `ipfs config`.
+	path, err := ReadConfigKey(".ipfsconfig", "datastore.path")
+	if err != nil || path != orig.Datastore.Path {
+		t.Error("failed to read datastore.path", path, err)
+	}
+}
+
+func TestConfigFileMode(t *testing.T) {
+	// as written by older versions.
+	if err := ioutil.WriteFile(".ipfsconfig-mode", []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(".ipfsconfig-mode")
+
+	if err := WriteConfigFile(".ipfsconfig-mode", &Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(".ipfsconfig-mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Error("config file should only be readable by its owner, got", fi.Mode())
+	}
 }
@@ -19,14 +19,20 @@ func ReadConfigFile(filename string, cfg interface{}) error {
 	return Decode(f, cfg)
 }
 
-// WriteConfigFile writes the config from `cfg` into `filename`.
+// WriteConfigFile writes the config from `cfg` into `filename`. The config
+// holds the private key, so the file is only readable by its owner.
 func WriteConfigFile(filename string, cfg interface{}) error {
-	f, err := os.Create(filename)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	// files written by older versions are world readable.
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+
 	return Encode(f, cfg)
 }
 
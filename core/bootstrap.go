@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	b58 "github.com/jbenet/go-base58"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"../config"
+	"../peer"
+	dht "../routing/dht"
+	u "../util"
+)
+
+// BootstrapTimeout is how long joining the network through the bootstrap
+// peers may take.
+var BootstrapTimeout = time.Minute
+
+// bootstrap joins the network through the configured bootstrap peers.
+func bootstrap(route *dht.IpfsDHT, cfg []*config.BootstrapPeer) error {
+	peers, err := bootstrapPeers(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), BootstrapTimeout)
+	defer cancel()
+	return route.Bootstrap(ctx, peers)
+}
+
+// bootstrapPeers returns the peers described in the bootstrap config.
+func bootstrapPeers(cfg []*config.BootstrapPeer) ([]*peer.Peer, error) {
+	var peers []*peer.Peer
+	for _, bp := range cfg {
+		id := peer.ID(b58.Decode(bp.PeerID))
+		if len(id) == 0 {
+			return nil, fmt.Errorf("bootstrap peer with bad ID: %q", bp.PeerID)
+		}
+
+		maddr, err := ma.NewMultiaddr(bp.Address)
+		if err != nil {
+			return nil, u.WrapError(err, "bootstrap peer with bad address")
+		}
+
+		p := &peer.Peer{ID: id}
+		p.AddAddress(maddr)
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
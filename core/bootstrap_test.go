@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+
+	"../config"
+)
+
+func TestBootstrapPeers(t *testing.T) {
+	peers, err := bootstrapPeers(config.DefaultBootstrapPeers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != len(config.DefaultBootstrapPeers) {
+		t.Fatalf("expected %d peers, got %d", len(config.DefaultBootstrapPeers), len(peers))
+	}
+	if peers[0].ID.Pretty() != config.DefaultBootstrapPeers[0].PeerID {
+		t.Error("peer ID not decoded", peers[0].ID.Pretty())
+	}
+	if peers[0].NetAddress("tcp") == nil {
+		t.Error("peer address not parsed")
+	}
+
+	bad := [][]*config.BootstrapPeer{
+		{{Address: "/ip4/127.0.0.1/tcp/4001", PeerID: ""}},
+		{{Address: "not an address", PeerID: config.DefaultBootstrapPeers[0].PeerID}},
+	}
+	for _, cfg := range bad {
+		if _, err := bootstrapPeers(cfg); err == nil {
+			t.Error("accepted bad bootstrap peer", cfg[0])
+		}
+	}
+}
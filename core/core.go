@@ -1,13 +1,28 @@
 package core
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
+	"sync"
 	ds "github.com/ipfs/go-datastore"
+	ma "github.com/multiformats/go-multiaddr"
+	"../bitswap"
 	"../blocks"
 	"../config"
+	ci "../crypto"
+	"../gc"
 	"../merkledag"
+	"../namesys"
+	"../netmux"
 	path "../path"
 	"../peer"
+	"../pin"
+	"../routing"
+	dht "../routing/dht"
+	kb "../routing/kbucket"
+	"../swarm"
+	u "../util"
 )
 
 // IpfsNode is IPFS Core module. It represents an IPFS instance.
@@ -26,13 +41,13 @@ type IpfsNode struct {
 	Datastore ds.Datastore
 
 	// the network message stream
-	// Network *netmux.Netux
+	Network swarm.Network
 
 	// the routing system. recommend ipfs-dht
-	// Routing *routing.Routing
+	Routing routing.IpfsRouting
 
 	// the block exchange + strategy (bitswap)
-	// BitSwap *bitswap.BitSwap
+	BitSwap *bitswap.BitSwap
 
 	// the block service, get/add blocks.
 	Blocks *blocks.BlockService
@@ -43,36 +58,187 @@ type IpfsNode struct {
 	// the path resolution system
 	Resolver *path.Resolver
 
-	// the name system, resolves paths to hashes
-	// Namesys *namesys.Namesys
+	// the pinning system, what must be kept in local storage
+	Pinning pin.Pinner
+
+	// the name system, resolves paths to hashes. needs Routing, so it is
+	// nil when offline.
+	Namesys namesys.NameSystem
+
+	// Datastore, which the DHT also lists the keys of.
+	dstore blocks.Datastore
+
+	// held while the node goes online.
+	onlineLock sync.Mutex
 }
 
 // NewIpfsNode constructs a new IpfsNode based on the given config.
-func NewIpfsNode(cfg *config.Config) (*IpfsNode, error) {
+// An online node joins the network, and fetches missing blocks from peers.
+func NewIpfsNode(cfg *config.Config, online bool) (*IpfsNode, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("configuration required")
 	}
 
-	d, err := makeDatastore(cfg.Datastore)
+	dstore, err := makeDatastore(cfg.Datastore)
 	if err != nil {
 		return nil, err
 	}
 
-	bs, err := blocks.NewBlockService(d)
+	// the blocks, pins, DHT, bitswap and GC all use the one datastore.
+	d := &syncDatastore{Datastore: dstore}
+
+	bs, err := blocks.NewBlockService(d, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	dag := &merkledag.DAGService{Blocks: bs}
 
+	pinner, err := pin.LoadPinner(d, dag)
+	if err != nil {
+		return nil, err
+	}
+
 	n := &IpfsNode{
 		Config:    cfg,
 		PeerMap:   &peer.Map{},
 		Datastore: d,
+		dstore:    d,
 		Blocks:    bs,
 		DAG:       dag,
 		Resolver:  &path.Resolver{DAG: dag},
+		Pinning:   pinner,
+	}
+
+	if !online {
+		return n, nil
+	}
+
+	if err := n.GoOnline(); err != nil {
+		return nil, err
+	}
+
+	// long running nodes collect garbage as storage fills up.
+	if cfg.Datastore.StorageMax > 0 {
+		go gc.Periodic(bs, pinner, cfg.Datastore, nil)
 	}
 
 	return n, nil
 }
+
+// GoOnline joins the network: blocks missing locally are then fetched
+// from peers, and /ipns/ paths resolved. The node keeps announcing its
+// blocks. It does nothing if the node is online already.
+func (n *IpfsNode) GoOnline() error {
+	n.onlineLock.Lock()
+	defer n.onlineLock.Unlock()
+
+	if n.Network != nil {
+		return nil
+	}
+
+	local, err := initIdentity(n.Config.Identity)
+	if err != nil {
+		return err
+	}
+
+	sw := swarm.NewSwarm(local)
+	if err = sw.Listen(); err != nil {
+		return err
+	}
+
+	// the services get streams of their own, so bitswap transfers do
+	// not hold up DHT queries.
+	net := netmux.NewNetwork(local, sw)
+
+	route := dht.NewDHT(local, net, n.dstore)
+	route.Validators["ipns"] = namesys.IpnsValidator
+	if r := n.Config.Routing; r != nil && (r.MaxPeersPerIP > 0 || r.MaxPeersPerSubnet > 0) {
+		route.SetTablePolicy(&kb.Policy{
+			PreferLowLatency: true,
+			MaxPerIP:         r.MaxPeersPerIP,
+			MaxPerSubnet:     r.MaxPeersPerSubnet,
+		})
+	}
+	route.Start()
+
+	swap := bitswap.NewBitSwap(local, net, n.dstore, n.Blocks)
+	swap.Start()
+
+	n.Identity = local
+	n.Network = net
+	n.BitSwap = swap
+	n.Routing = route
+	n.Namesys = namesys.NewNameSystem(route)
+
+	// a node online on demand keeps its onDemand as the exchange.
+	if n.Blocks.Remote == nil {
+		n.Blocks.Remote = swap
+	}
+	if n.Resolver.Namesys == nil {
+		n.Resolver.Namesys = n.Namesys
+	}
+
+	// join the network, then keep announcing our blocks, as provider
+	// records expire.
+	go func() {
+		if err := bootstrap(route, n.Config.Bootstrap); err != nil {
+			u.PErr("bootstrap: %v\n", err)
+		}
+		PeriodicReprovide(context.Background(), route, n.Blocks, n.Pinning)
+	}()
+	return nil
+}
+
+// GoOnlineOnDemand has an offline node go online the first time a block
+// is missing locally, or an /ipns/ path is resolved. Content held locally
+// is then read without touching the network.
+func (n *IpfsNode) GoOnlineOnDemand() {
+	od := &onDemand{node: n}
+	n.Blocks.Remote = od
+	n.Resolver.Namesys = od
+}
+
+// initIdentity constructs the local peer from the identity config.
+func initIdentity(cfg *config.Identity) (*peer.Peer, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("Identity was not set in config")
+	}
+
+	if len(cfg.PrivKey) == 0 {
+		return nil, fmt.Errorf("No private key in config (run 'ipfs init')")
+	}
+
+	if len(cfg.Address) == 0 {
+		return nil, fmt.Errorf("No local address in config")
+	}
+
+	skb, err := base64.StdEncoding.DecodeString(cfg.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, err := ci.UnmarshalPrivateKey(skb)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := peer.IDFromPubKey(sk.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+
+	// the ID is only there for users to read; the key is authoritative.
+	if len(cfg.PeerID) > 0 && cfg.PeerID != id.Pretty() {
+		return nil, fmt.Errorf("Peer ID in config does not match private key")
+	}
+
+	maddr, err := ma.NewMultiaddr(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peer.Peer{ID: id, PrivKey: sk, PubKey: sk.GetPublic()}
+	p.AddAddress(maddr)
+	return p, nil
+}
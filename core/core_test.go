@@ -1,8 +1,14 @@
 package core
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+	ds "github.com/ipfs/go-datastore"
+	"../blocks"
 	"../config"
+	u "../util"
 )
 
 func TestDatastores(t *testing.T) {
@@ -20,16 +26,78 @@ func TestDatastores(t *testing.T) {
 	}
 
 	for i, c := range good {
-		n, err := NewIpfsNode(c)
+		n, err := NewIpfsNode(c, false)
 		if n == nil || err != nil {
 			t.Error("Should have constructed.", i, err)
 		}
 	}
 
 	for i, c := range bad {
-		n, err := NewIpfsNode(c)
+		n, err := NewIpfsNode(c, false)
 		if n != nil || err == nil {
 			t.Error("Should have failed to construct.", i)
 		}
 	}
 }
+
+func TestGoOnlineOnDemand(t *testing.T) {
+	// without an identity, the node cannot go online.
+	cfg := &config.Config{Datastore: &config.Datastore{Type: "memory"}}
+	n, err := NewIpfsNode(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.GoOnlineOnDemand()
+
+	b, err := blocks.NewBlock([]byte("held locally"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.Blocks.AddBlock(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := n.Blocks.GetBlock(context.Background(), b.Key()); err != nil {
+		t.Fatal("local block not read offline:", err)
+	}
+	if n.Network != nil {
+		t.Fatal("went online for a local block")
+	}
+
+	if _, err := n.Blocks.GetBlock(context.Background(), u.Key("missing")); err == nil {
+		t.Fatal("expected going online without an identity to fail")
+	}
+}
+
+func TestSharedDatastore(t *testing.T) {
+	cfg := &config.Config{Datastore: &config.Datastore{Type: "memory"}}
+	n, err := NewIpfsNode(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the services use the datastore concurrently, blocks and all.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			b, err := blocks.NewBlock([]byte(fmt.Sprintf("block %d", i)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := n.Blocks.AddBlock(b); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			k := ds.NewKey(fmt.Sprintf("/dht/records/%d", i))
+			if err := n.Datastore.Put(k, []byte("value")); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
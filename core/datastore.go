@@ -2,12 +2,14 @@ package core
 
 import (
 	"fmt"
+	"sync"
 	ds "github.com/ipfs/go-datastore"
 	lds "github.com/jbenet/datastore.go/leveldb"
+	"../blocks"
 	"../config"
 )
 
-func makeDatastore(cfg *config.Datastore) (ds.Datastore, error) {
+func makeDatastore(cfg *config.Datastore) (blocks.Datastore, error) {
 	if cfg == nil || len(cfg.Type) == 0 {
 		return nil, fmt.Errorf("config datastore.type required")
 	}
@@ -22,10 +24,48 @@ func makeDatastore(cfg *config.Datastore) (ds.Datastore, error) {
 	return nil, fmt.Errorf("Unknown datastore type: %s", cfg.Type)
 }
 
-func makeLevelDBDatastore(cfg *config.Datastore) (ds.Datastore, error) {
+func makeLevelDBDatastore(cfg *config.Datastore) (blocks.Datastore, error) {
 	if len(cfg.Path) == 0 {
 		return nil, fmt.Errorf("config datastore.path required for leveldb")
 	}
 
 	return lds.NewDatastore(cfg.Path, nil)
 }
+
+// syncDatastore serializes access to the datastore shared by the services
+// of a node, which use it concurrently. MapDatastore, for one, is not safe
+// to use from several goroutines at once.
+type syncDatastore struct {
+	blocks.Datastore
+	lock sync.Mutex
+}
+
+func (d *syncDatastore) Get(k ds.Key) (interface{}, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.Get(k)
+}
+
+func (d *syncDatastore) Put(k ds.Key, v interface{}) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.Put(k, v)
+}
+
+func (d *syncDatastore) Has(k ds.Key) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.Has(k)
+}
+
+func (d *syncDatastore) Delete(k ds.Key) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.Delete(k)
+}
+
+func (d *syncDatastore) KeyList() ([]ds.Key, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.KeyList()
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"context"
+
+	"../blocks"
+	u "../util"
+)
+
+// onDemand is the exchange and name resolver of a node that goes online
+// the first time it needs the network.
+type onDemand struct {
+	node *IpfsNode
+}
+
+// GetBlock implements blocks.Exchange, going online first.
+func (od *onDemand) GetBlock(ctx context.Context, k u.Key) (*blocks.Block, error) {
+	if err := od.node.GoOnline(); err != nil {
+		return nil, err
+	}
+	return od.node.BitSwap.GetBlock(ctx, k)
+}
+
+// HaveBlock implements blocks.Exchange. Blocks added while offline are
+// announced by the reprovider, once online.
+func (od *onDemand) HaveBlock(b *blocks.Block) error {
+	od.node.onlineLock.Lock()
+	swap := od.node.BitSwap
+	od.node.onlineLock.Unlock()
+
+	if swap == nil {
+		return nil
+	}
+	return swap.HaveBlock(b)
+}
+
+// Resolve implements namesys.Resolver, going online first.
+func (od *onDemand) Resolve(name string) (string, error) {
+	if err := od.node.GoOnline(); err != nil {
+		return "", err
+	}
+	return od.node.Namesys.Resolve(name)
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"../blocks"
+	"../pin"
+	"../routing"
+	u "../util"
+)
+
+// ReprovideInterval is how often a node announces again the blocks it can
+// provide. It must be shorter than the time the routing system keeps
+// provider records.
+var ReprovideInterval = time.Hour * 12
+
+// Reprovide announces every block held in bs or pinned in pn through r.
+// A block that fails to be announced does not stop the others; the last
+// error is returned. Once ctx is done, no more blocks are announced.
+func Reprovide(ctx context.Context, r routing.IpfsRouting, bs *blocks.BlockService, pn pin.Pinner) error {
+	keys := make(map[u.Key]struct{})
+
+	local, err := bs.LocalKeys()
+	if err != nil {
+		u.PErr("reprovide: %v\n", err)
+	}
+	for _, k := range local {
+		keys[k] = struct{}{}
+	}
+
+	for _, k := range pn.DirectKeys() {
+		keys[k] = struct{}{}
+	}
+	for _, k := range pn.RecursiveKeys() {
+		keys[k] = struct{}{}
+	}
+	for k := range pn.IndirectKeys() {
+		keys[k] = struct{}{}
+	}
+
+	u.DOut("reprovide: announcing %d blocks\n", len(keys))
+	for k := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if perr := r.Provide(ctx, k); perr != nil {
+			u.DOut("reprovide: %s: %v\n", k.Pretty(), perr)
+			err = perr
+		}
+	}
+	return err
+}
+
+// PeriodicReprovide calls Reprovide every ReprovideInterval, starting right
+// away, until ctx is done.
+func PeriodicReprovide(ctx context.Context, r routing.IpfsRouting, bs *blocks.BlockService, pn pin.Pinner) {
+	tick := time.NewTicker(ReprovideInterval)
+	defer tick.Stop()
+
+	for {
+		if err := Reprovide(ctx, r, bs, pn); err != nil {
+			u.PErr("reprovide: %v\n", err)
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
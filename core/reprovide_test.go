@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+
+	"../blocks"
+	mdag "../merkledag"
+	"../peer"
+	"../pin"
+	u "../util"
+)
+
+// provideRouting records the keys provided, and fails on the key bad.
+type provideRouting struct {
+	provided map[u.Key]bool
+	bad      u.Key
+}
+
+func (r *provideRouting) PutValue(ctx context.Context, key u.Key, value []byte) error {
+	return nil
+}
+
+func (r *provideRouting) GetValue(ctx context.Context, key u.Key) ([]byte, error) {
+	return nil, u.ErrNotFound
+}
+
+func (r *provideRouting) Provide(ctx context.Context, key u.Key) error {
+	if key == r.bad {
+		return errors.New("cannot provide")
+	}
+	r.provided[key] = true
+	return nil
+}
+
+func (r *provideRouting) FindProviders(ctx context.Context, key u.Key) ([]*peer.Peer, error) {
+	return nil, u.ErrNotFound
+}
+
+func (r *provideRouting) FindPeer(ctx context.Context, id peer.ID) (*peer.Peer, error) {
+	return nil, u.ErrNotFound
+}
+
+func TestReprovide(t *testing.T) {
+	d := ds.NewMapDatastore()
+	bs, err := blocks.NewBlockService(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := &mdag.DAGService{Blocks: bs}
+	pn := pin.NewPinner(d, dserv)
+
+	held := &mdag.Node{Data: []byte("held")}
+	bad := &mdag.Node{Data: []byte("bad")}
+	for _, nd := range []*mdag.Node{held, bad} {
+		if err := dserv.AddRecursive(nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// pinned, but not held locally.
+	pinned := &mdag.Node{Data: []byte("pinned")}
+	if err := pn.Pin(context.Background(), pinned, false); err != nil {
+		t.Fatal(err)
+	}
+
+	heldk, _ := held.Key()
+	badk, _ := bad.Key()
+	pinnedk, _ := pinned.Key()
+
+	r := &provideRouting{provided: map[u.Key]bool{}, bad: badk}
+	if err := Reprovide(context.Background(), r, bs, pn); err == nil {
+		t.Error("expected the failure to provide to be returned")
+	}
+
+	if !r.provided[heldk] || !r.provided[pinnedk] {
+		t.Error("not all blocks were provided", r.provided)
+	}
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+)
+
+// Ed25519PrivateKey is an Ed25519 private key.
+type Ed25519PrivateKey struct {
+	k ed25519.PrivateKey
+}
+
+// Ed25519PublicKey is an Ed25519 public key.
+type Ed25519PublicKey struct {
+	k ed25519.PublicKey
+}
+
+func generateEd25519KeyPair() (PrivKey, PubKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Ed25519PrivateKey{k: priv}, &Ed25519PublicKey{k: pub}, nil
+}
+
+func unmarshalEd25519PublicKey(data []byte) (PubKey, error) {
+	if len(data) != ed25519.PublicKeySize {
+		return nil, errors.New("bad Ed25519 public key size")
+	}
+	return &Ed25519PublicKey{k: ed25519.PublicKey(data)}, nil
+}
+
+func unmarshalEd25519PrivateKey(data []byte) (PrivKey, error) {
+	if len(data) != ed25519.PrivateKeySize {
+		return nil, errors.New("bad Ed25519 private key size")
+	}
+	return &Ed25519PrivateKey{k: ed25519.PrivateKey(data)}, nil
+}
+
+// Sign signs data.
+func (pk *Ed25519PrivateKey) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(pk.k, data), nil
+}
+
+// GetPublic returns the matching public key.
+func (pk *Ed25519PrivateKey) GetPublic() PubKey {
+	return &Ed25519PublicKey{k: pk.k.Public().(ed25519.PublicKey)}
+}
+
+// Bytes returns the serialized key.
+func (pk *Ed25519PrivateKey) Bytes() ([]byte, error) {
+	return marshalPrivateKey(KeyType_Ed25519, pk.k)
+}
+
+// Hash returns the multihash of the serialized key.
+func (pk *Ed25519PrivateKey) Hash() ([]byte, error) {
+	return keyHash(pk)
+}
+
+// Equals checks whether two keys are the same.
+func (pk *Ed25519PrivateKey) Equals(k Key) bool {
+	return keyEqual(pk, k)
+}
+
+// Verify checks a signature made by Ed25519PrivateKey.Sign.
+func (pk *Ed25519PublicKey) Verify(data, sig []byte) (bool, error) {
+	return ed25519.Verify(pk.k, data, sig), nil
+}
+
+// Bytes returns the serialized key.
+func (pk *Ed25519PublicKey) Bytes() ([]byte, error) {
+	return marshalPublicKey(KeyType_Ed25519, pk.k)
+}
+
+// Hash returns the multihash of the serialized key.
+func (pk *Ed25519PublicKey) Hash() ([]byte, error) {
+	return keyHash(pk)
+}
+
+// Equals checks whether two keys are the same.
+func (pk *Ed25519PublicKey) Equals(k Key) bool {
+	return keyEqual(pk, k)
+}
@@ -0,0 +1,123 @@
+// Package crypto implements the public key cryptography behind peer
+// identities and signed ipfs records.
+package crypto
+
+import (
+	"bytes"
+	"errors"
+
+	proto "github.com/golang/protobuf/proto"
+
+	u "../util"
+)
+
+// ErrBadKeyType signals a serialized key of an unknown type.
+var ErrBadKeyType = errors.New("invalid or unsupported key type")
+
+// Key is a public or private key.
+type Key interface {
+	// Bytes returns the serialized key.
+	Bytes() ([]byte, error)
+
+	// Hash returns the multihash of the serialized key.
+	Hash() ([]byte, error)
+
+	// Equals checks whether two keys are the same.
+	Equals(Key) bool
+}
+
+// PrivKey is a private key, used to sign data.
+type PrivKey interface {
+	Key
+
+	// Sign returns a signature of data.
+	Sign(data []byte) ([]byte, error)
+
+	// GetPublic returns the matching public key.
+	GetPublic() PubKey
+}
+
+// PubKey is a public key, used to verify signatures.
+type PubKey interface {
+	Key
+
+	// Verify checks that sig is a signature of data by the matching
+	// private key.
+	Verify(data, sig []byte) (bool, error)
+}
+
+// GenerateKeyPair returns a new key pair of the given type. bits is the
+// key size, for the types that have one (RSA). Ed25519 keys have a fixed
+// size, and ignore it.
+func GenerateKeyPair(typ KeyType, bits int) (PrivKey, PubKey, error) {
+	switch typ {
+	case KeyType_RSA:
+		return generateRSAKeyPair(bits)
+	case KeyType_Ed25519:
+		return generateEd25519KeyPair()
+	}
+	return nil, nil, ErrBadKeyType
+}
+
+// UnmarshalPublicKey decodes a public key serialized with Bytes.
+func UnmarshalPublicKey(data []byte) (PubKey, error) {
+	pbk := new(PBPublicKey)
+	if err := proto.Unmarshal(data, pbk); err != nil {
+		return nil, err
+	}
+
+	switch pbk.GetType() {
+	case KeyType_RSA:
+		return unmarshalRSAPublicKey(pbk.GetData())
+	case KeyType_Ed25519:
+		return unmarshalEd25519PublicKey(pbk.GetData())
+	}
+	return nil, ErrBadKeyType
+}
+
+// UnmarshalPrivateKey decodes a private key serialized with Bytes.
+func UnmarshalPrivateKey(data []byte) (PrivKey, error) {
+	pbk := new(PBPrivateKey)
+	if err := proto.Unmarshal(data, pbk); err != nil {
+		return nil, err
+	}
+
+	switch pbk.GetType() {
+	case KeyType_RSA:
+		return unmarshalRSAPrivateKey(pbk.GetData())
+	case KeyType_Ed25519:
+		return unmarshalEd25519PrivateKey(pbk.GetData())
+	}
+	return nil, ErrBadKeyType
+}
+
+func marshalPublicKey(typ KeyType, data []byte) ([]byte, error) {
+	return proto.Marshal(&PBPublicKey{Type: typ.Enum(), Data: data})
+}
+
+func marshalPrivateKey(typ KeyType, data []byte) ([]byte, error) {
+	return proto.Marshal(&PBPrivateKey{Type: typ.Enum(), Data: data})
+}
+
+// keyHash returns the multihash of a serialized key.
+func keyHash(k Key) ([]byte, error) {
+	b, err := k.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return u.Hash(b)
+}
+
+// keyEqual compares two keys by their serialized forms.
+func keyEqual(a, b Key) bool {
+	ab, err := a.Bytes()
+	if err != nil {
+		return false
+	}
+
+	bb, err := b.Bytes()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ab, bb)
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	testSignVerify(t, KeyType_RSA)
+	testSignVerify(t, KeyType_Ed25519)
+}
+
+func testSignVerify(t *testing.T, typ KeyType) {
+	priv, pub, err := GenerateKeyPair(typ, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("hello! and welcome to some awesome crypto primitives")
+	sig, err := priv.Sign(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := pub.Verify(data, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error(typ, "signature did not verify")
+	}
+
+	data[0] ^= 0xff
+	ok, err = pub.Verify(data, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error(typ, "signature verified over modified data")
+	}
+}
+
+func TestMarshalKeys(t *testing.T) {
+	testMarshalKeys(t, KeyType_RSA)
+	testMarshalKeys(t, KeyType_Ed25519)
+}
+
+func testMarshalKeys(t *testing.T, typ KeyType) {
+	priv, pub, err := GenerateKeyPair(typ, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubb, err := pub.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub2, err := UnmarshalPublicKey(pubb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pub.Equals(pub2) {
+		t.Error(typ, "public key changed through serialization")
+	}
+
+	privb, err := priv.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv2, err := UnmarshalPrivateKey(privb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !priv.Equals(priv2) || !priv2.GetPublic().Equals(pub) {
+		t.Error(typ, "private key changed through serialization")
+	}
+
+	if _, err := UnmarshalPublicKey([]byte("garbage")); err == nil {
+		t.Error("garbage unmarshaled as a key")
+	}
+}
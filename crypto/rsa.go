@@ -0,0 +1,106 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+)
+
+// RsaPrivateKey is an RSA private key.
+type RsaPrivateKey struct {
+	k *rsa.PrivateKey
+}
+
+// RsaPublicKey is an RSA public key.
+type RsaPublicKey struct {
+	k *rsa.PublicKey
+}
+
+func generateRSAKeyPair(bits int) (PrivKey, PubKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pk := &RsaPrivateKey{k: priv}
+	return pk, pk.GetPublic(), nil
+}
+
+func unmarshalRSAPublicKey(data []byte) (PubKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return &RsaPublicKey{k: pk}, nil
+}
+
+func unmarshalRSAPrivateKey(data []byte) (PrivKey, error) {
+	priv, err := x509.ParsePKCS1PrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return &RsaPrivateKey{k: priv}, nil
+}
+
+// Sign signs the SHA-256 digest of data with PKCS #1 v1.5.
+func (pk *RsaPrivateKey) Sign(data []byte) ([]byte, error) {
+	h := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, pk.k, crypto.SHA256, h[:])
+}
+
+// GetPublic returns the matching public key.
+func (pk *RsaPrivateKey) GetPublic() PubKey {
+	return &RsaPublicKey{k: &pk.k.PublicKey}
+}
+
+// Bytes returns the serialized key.
+func (pk *RsaPrivateKey) Bytes() ([]byte, error) {
+	return marshalPrivateKey(KeyType_RSA, x509.MarshalPKCS1PrivateKey(pk.k))
+}
+
+// Hash returns the multihash of the serialized key.
+func (pk *RsaPrivateKey) Hash() ([]byte, error) {
+	return keyHash(pk)
+}
+
+// Equals checks whether two keys are the same.
+func (pk *RsaPrivateKey) Equals(k Key) bool {
+	return keyEqual(pk, k)
+}
+
+// Verify checks a signature made by RsaPrivateKey.Sign.
+func (pk *RsaPublicKey) Verify(data, sig []byte) (bool, error) {
+	h := sha256.Sum256(data)
+	err := rsa.VerifyPKCS1v15(pk.k, crypto.SHA256, h[:], sig)
+	if err == rsa.ErrVerification {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Bytes returns the serialized key.
+func (pk *RsaPublicKey) Bytes() ([]byte, error) {
+	b, err := x509.MarshalPKIXPublicKey(pk.k)
+	if err != nil {
+		return nil, err
+	}
+	return marshalPublicKey(KeyType_RSA, b)
+}
+
+// Hash returns the multihash of the serialized key.
+func (pk *RsaPublicKey) Hash() ([]byte, error) {
+	return keyHash(pk)
+}
+
+// Equals checks whether two keys are the same.
+func (pk *RsaPublicKey) Equals(k Key) bool {
+	return keyEqual(pk, k)
+}
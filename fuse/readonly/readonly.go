@@ -6,9 +6,15 @@ package readonly
 import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"bytes"
+	"context"
 	"fmt"
 	core "../../core"
+	"../../importer"
 	mdag "../../merkledag"
+	ft "../../unixfs"
+	u "../../util"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -50,9 +56,12 @@ func (s *Root) Lookup(name string, intr fs.Intr) (fs.Node, fuse.Error) {
 		return nil, fuse.ENOENT
 	}
 
-	nd, err := s.Ipfs.Resolver.ResolvePath(name)
+	ctx, cancel := intrContext(intr)
+	defer cancel()
+
+	nd, err := s.Ipfs.Resolver.ResolvePath(ctx, name)
 	if err != nil {
-		// todo: make this error more versatile.
+		u.DErr("Lookup: %s\n", err)
 		return nil, fuse.ENOENT
 	}
 
@@ -68,21 +77,56 @@ func (*Root) ReadDir(intr fs.Intr) ([]fuse.Dirent, fuse.Error) {
 type Node struct {
 	Ipfs *core.IpfsNode
 	Nd   *mdag.Node
+
+	// cached unixfs envelope of Nd
+	cached *ft.FSNode
+}
+
+// loadData decodes the unixfs envelope of the node, once.
+func (s *Node) loadData() (*ft.FSNode, error) {
+	if s.cached == nil {
+		fsn, err := ft.FSNodeFromBytes(s.Nd.Data)
+		if err != nil {
+			return nil, err
+		}
+		s.cached = fsn
+	}
+	return s.cached, nil
 }
 
 // Attr returns the attributes of a given node.
 func (s *Node) Attr() fuse.Attr {
-	if len(s.Nd.Links) > 0 {
-		return fuse.Attr{Mode: os.ModeDir | 0555}
+	fsn, err := s.loadData()
+	if err != nil {
+		// not a unixfs node. show its raw data.
+		u.DErr("Attr: %s", err)
+		return fuse.Attr{Mode: 0444, Size: uint64(len(s.Nd.Data))}
 	}
 
-	size, _ := s.Nd.Size()
-	return fuse.Attr{Mode: 0444, Size: uint64(size)}
+	switch fsn.Type {
+	case ft.TDirectory:
+		mode := os.FileMode(0555)
+		if fsn.Mode != 0 {
+			mode = fsn.Mode &^ 0222 // readonly
+		}
+		return fuse.Attr{Mode: os.ModeDir | mode, Mtime: fsn.Mtime}
+	case ft.TFile:
+		mode := os.FileMode(0444)
+		if fsn.Mode != 0 {
+			mode = fsn.Mode &^ 0222 // readonly
+		}
+		return fuse.Attr{Mode: mode, Size: fsn.FileSize(), Mtime: fsn.Mtime}
+	}
+
+	return fuse.Attr{Mode: 0444, Size: uint64(len(fsn.Data))}
 }
 
 // Lookup performs a lookup under this node.
 func (s *Node) Lookup(name string, intr fs.Intr) (fs.Node, fuse.Error) {
-	nd, err := s.Ipfs.Resolver.ResolveLinks(s.Nd, []string{name})
+	ctx, cancel := intrContext(intr)
+	defer cancel()
+
+	nd, err := s.Ipfs.Resolver.ResolveLinks(ctx, s.Nd, []string{name})
 	if err != nil {
 		// todo: make this error more versatile.
 		return nil, fuse.ENOENT
@@ -99,7 +143,7 @@ func (s *Node) ReadDir(intr fs.Intr) ([]fuse.Dirent, fuse.Error) {
 		if len(n) == 0 {
 			n = link.Hash.B58String()
 		}
-		entries[i] = fuse.Dirent{Name: n, Type: fuse.DT_File}
+		entries[i] = fuse.Dirent{Name: n, Type: fuse.DT_Unknown}
 	}
 
 	if len(entries) > 0 {
@@ -108,9 +152,56 @@ func (s *Node) ReadDir(intr fs.Intr) ([]fuse.Dirent, fuse.Error) {
 	return nil, fuse.ENOENT
 }
 
-// ReadAll reads the object data as file data
-func (s *Node) ReadAll(intr fs.Intr) ([]byte, fuse.Error) {
-	return []byte(s.Nd.Data), nil
+// Read reads the requested range of the file data. Only the blocks
+// holding that range are fetched.
+func (s *Node) Read(req *fuse.ReadRequest, resp *fuse.ReadResponse, intr fs.Intr) fuse.Error {
+	ctx, cancel := intrContext(intr)
+	defer cancel()
+
+	r, err := s.reader(ctx)
+	if err != nil {
+		u.PErr("Read error: %s", err)
+		return fuse.EIO
+	}
+
+	if _, err := r.Seek(req.Offset, os.SEEK_SET); err != nil {
+		u.PErr("Read seek error: %s", err)
+		return fuse.EIO
+	}
+
+	buf := make([]byte, req.Size)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		u.PErr("Read error: %s", err)
+		return fuse.EIO
+	}
+
+	resp.Data = buf[:n]
+	return nil
+}
+
+// reader returns a reader of the node's file data or, as Attr shows, of
+// its raw data if it is not a unixfs node.
+func (s *Node) reader(ctx context.Context) (io.ReadSeeker, error) {
+	if _, err := s.loadData(); err != nil {
+		return bytes.NewReader(s.Nd.Data), nil
+	}
+	return importer.NewDagReader(ctx, s.Nd, s.Ipfs.DAG)
+}
+
+// intrContext returns a context canceled when the kernel interrupts the
+// request, so fetching blocks for it gives up. cancel must be called once
+// the request is served.
+func intrContext(intr fs.Intr) (ctx context.Context, cancel context.CancelFunc) {
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-intr:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
 }
 
 // Mount mounts an IpfsNode instance at a particular path. It
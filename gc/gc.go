@@ -0,0 +1,153 @@
+// Package gc removes blocks that are not pinned from local storage.
+//
+// Collection is a mark and sweep: every block reachable from a pin is
+// marked, then every other block in the BlockService is deleted. Writers
+// hold the Pinner's PinLock until they have pinned what they wrote.
+package gc
+
+import (
+	"time"
+
+	blocks "../blocks"
+	config "../config"
+	mdag "../merkledag"
+	pin "../pin"
+	u "../util"
+)
+
+// DefaultWatermark is the percentage of the storage maximum at which
+// automatic collection starts, when the config does not set one.
+var DefaultWatermark uint64 = 90
+
+// CheckPeriod is how often Periodic checks the storage size.
+var CheckPeriod = time.Minute * 10
+
+// Result reports what a collection removed.
+type Result struct {
+	// Removed is the number of blocks deleted.
+	Removed int
+
+	// Freed is the number of bytes of block data deleted.
+	Freed uint64
+}
+
+// GC deletes every block in bs that is not reachable from a pin in pn.
+// Only local blocks are walked: the network is never asked for missing ones.
+// It waits for the PinLocks of pn, so blocks written under one are kept.
+func GC(bs *blocks.BlockService, pn pin.Pinner) (*Result, error) {
+	defer pn.GCLock()()
+
+	marked, err := mark(bs, pn)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := bs.LocalKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	for _, k := range keys {
+		if _, ok := marked[k]; ok {
+			continue
+		}
+
+		b, err := bs.GetLocalBlock(k)
+		if err != nil {
+			return res, err
+		}
+
+		if err := bs.DeleteBlock(k); err != nil {
+			return res, err
+		}
+
+		u.DOut("gc: removed %s\n", k.Pretty())
+		res.Removed++
+		res.Freed += uint64(len(b.Data))
+	}
+	return res, nil
+}
+
+// mark returns the keys of all the pinned blocks held locally.
+func mark(bs *blocks.BlockService, pn pin.Pinner) (map[u.Key]struct{}, error) {
+	marked := map[u.Key]struct{}{}
+	for _, k := range pn.DirectKeys() {
+		marked[k] = struct{}{}
+	}
+
+	var walk func(k u.Key) error
+	walk = func(k u.Key) error {
+		if _, ok := marked[k]; ok {
+			return nil
+		}
+
+		b, err := bs.GetLocalBlock(k)
+		if err != nil {
+			// not held locally, so nothing to keep under it either.
+			return nil
+		}
+		marked[k] = struct{}{}
+
+		nd, err := mdag.Decoded(b.Data)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range nd.Links {
+			if err := walk(u.Key(l.Hash)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, k := range pn.RecursiveKeys() {
+		if err := walk(k); err != nil {
+			return nil, err
+		}
+	}
+	return marked, nil
+}
+
+// MaybeGC runs a collection if the blocks in bs take more than the storage
+// watermark set in cfg. It returns a nil Result if no collection ran.
+func MaybeGC(bs *blocks.BlockService, pn pin.Pinner, cfg *config.Datastore) (*Result, error) {
+	if cfg == nil || cfg.StorageMax == 0 {
+		return nil, nil
+	}
+
+	watermark := cfg.StorageGCWatermark
+	if watermark == 0 {
+		watermark = DefaultWatermark
+	}
+
+	size := bs.Size()
+	if size < cfg.StorageMax/100*watermark {
+		return nil, nil
+	}
+
+	u.DOut("gc: storage at %d of %d bytes, collecting\n", size, cfg.StorageMax)
+	return GC(bs, pn)
+}
+
+// Periodic calls MaybeGC every CheckPeriod, until halt is closed. Blocks
+// are only safe from it once pinned, or while written under a PinLock.
+func Periodic(bs *blocks.BlockService, pn pin.Pinner, cfg *config.Datastore, halt <-chan struct{}) {
+	tick := time.NewTicker(CheckPeriod)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			res, err := MaybeGC(bs, pn, cfg)
+			if err != nil {
+				u.PErr("gc: %v\n", err)
+			} else if res != nil {
+				u.DOut("gc: freed %d bytes\n", res.Freed)
+			}
+		case <-halt:
+			return
+		}
+	}
+}
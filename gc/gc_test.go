@@ -0,0 +1,154 @@
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+
+	blocks "../blocks"
+	config "../config"
+	mdag "../merkledag"
+	pin "../pin"
+	u "../util"
+)
+
+func setup(t *testing.T) (*blocks.BlockService, *mdag.DAGService, pin.Pinner) {
+	d := ds.NewMapDatastore()
+	bs, err := blocks.NewBlockService(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dserv := &mdag.DAGService{Blocks: bs}
+	return bs, dserv, pin.NewPinner(d, dserv)
+}
+
+func put(t *testing.T, dserv *mdag.DAGService, nd *mdag.Node) u.Key {
+	if err := dserv.AddRecursive(nd); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := nd.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestGC(t *testing.T) {
+	bs, dserv, pn := setup(t)
+
+	// kept: root -> child, and a direct pin.
+	child := &mdag.Node{Data: []byte("child")}
+	root := &mdag.Node{Data: []byte("root")}
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	rootk := put(t, dserv, root)
+	childk := put(t, dserv, child)
+
+	// removed: garbage, and the link under the direct pin.
+	under := &mdag.Node{Data: []byte("under")}
+	direct := &mdag.Node{Data: []byte("direct")}
+	if err := direct.AddNodeLink("under", under); err != nil {
+		t.Fatal(err)
+	}
+	directk := put(t, dserv, direct)
+	underk := put(t, dserv, under)
+	garbagek := put(t, dserv, &mdag.Node{Data: []byte("garbage")})
+
+	if err := pn.Pin(context.Background(), root, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pn.Pin(context.Background(), direct, false); err != nil {
+		t.Fatal(err)
+	}
+
+	before := bs.Size()
+
+	res, err := GC(bs, pn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Removed != 2 {
+		t.Error("expected 2 blocks removed, got", res.Removed)
+	}
+
+	after := bs.Size()
+
+	if before-after != res.Freed {
+		t.Errorf("freed %d bytes, but storage went from %d to %d", res.Freed, before, after)
+	}
+
+	for _, k := range []u.Key{rootk, childk, directk} {
+		if _, err := bs.GetLocalBlock(k); err != nil {
+			t.Error("pinned block was removed", k.Pretty())
+		}
+	}
+
+	for _, k := range []u.Key{garbagek, underk} {
+		if _, err := bs.GetLocalBlock(k); err == nil {
+			t.Error("unpinned block was kept", k.Pretty())
+		}
+	}
+}
+
+func TestMaybeGC(t *testing.T) {
+	bs, dserv, pn := setup(t)
+	put(t, dserv, &mdag.Node{Data: make([]byte, 1000)})
+
+	cfg := &config.Datastore{StorageMax: 10000}
+	res, err := MaybeGC(bs, pn, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res != nil {
+		t.Error("collected below the watermark")
+	}
+
+	cfg.StorageMax = 1000
+	res, err = MaybeGC(bs, pn, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res == nil || res.Removed != 1 {
+		t.Error("did not collect above the watermark")
+	}
+}
+
+func TestGCWaitsForPinLock(t *testing.T) {
+	bs, dserv, pn := setup(t)
+
+	unlock := pn.PinLock()
+	added := &mdag.Node{Data: []byte("added")}
+	addedk := put(t, dserv, added)
+
+	done := make(chan struct{})
+	go func() {
+		if _, err := GC(bs, pn); err != nil {
+			t.Error(err)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("collected while an add held the pin lock")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if err := pn.Pin(context.Background(), added, true); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	<-done
+
+	if _, err := bs.GetLocalBlock(addedk); err != nil {
+		t.Error("block added under the pin lock was removed")
+	}
+}
@@ -1,20 +1,273 @@
 // The identify package handles how peers identify with eachother upon
 // connection to the network
+//
+// The handshake authenticates both peers, and sets up an encrypted
+// channel between them:
+//
+//  1. each side sends a Propose: a nonce, its public key, and the key
+//     exchanges, ciphers and hashes it supports.
+//  2. each side checks the remote peer.ID is the hash of the remote key,
+//     and picks the parameters both support.
+//  3. each side sends an Exchange: an ephemeral public key, signed with
+//     its identity key over both proposals.
+//  4. the shared secret of the ephemeral keys is stretched into a key for
+//     each direction, and the channels are wrapped in a SecurePipe.
+//  5. each side sends back the remote nonce over the SecurePipe, proving
+//     both derived the same keys.
 package identify
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+
+	ci "../crypto"
 	peer "../peer"
 	u "../util"
 )
 
-// Perform initial communication with this peer to share node ID's and
-// initiate communication
-func Handshake(self, remote *peer.Peer, in, out chan []byte) error {
-	// TODO: make this more... secure.
-	out <- self.ID
-	resp := <-in
-	remote.ID = peer.ID(resp)
+// SupportedExchanges lists the ephemeral key exchanges, most preferred first.
+var SupportedExchanges = "P-256,P-384,X25519"
+
+// SupportedCiphers lists the ciphers, most preferred first.
+var SupportedCiphers = "AES-256,AES-128"
+
+// SupportedHashes lists the hashes used to stretch keys, most preferred first.
+var SupportedHashes = "SHA256,SHA512"
+
+// HandshakeTimeout is how long to wait to send, or receive, each handshake
+// message.
+var HandshakeTimeout = time.Second * 10
+
+// nonceSize is the size of the nonce in Propose.
+const nonceSize = 16
+
+// ErrBadRemoteID signals a remote key that does not match the remote
+// peer.ID we expected.
+var ErrBadRemoteID = errors.New("remote peer ID does not match its public key")
+
+// ErrTalkingToSelf signals a connection to ourselves.
+var ErrTalkingToSelf = errors.New("connected to self")
+
+// Handshake performs the authenticated key exchange with the remote peer
+// over in and out. If remote.ID is set, the remote key must match it;
+// otherwise remote.ID is set from the key. All further messages must go
+// through the returned SecurePipe.
+func Handshake(self, remote *peer.Peer, in, out chan []byte) (*SecurePipe, error) {
+	if self.PrivKey == nil || self.PubKey == nil {
+		return nil, errors.New("local peer has no keys")
+	}
+
+	// 1. propose
+	myPubKey, err := self.PubKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	myProp := &Propose{
+		Rand:      nonce,
+		Pubkey:    myPubKey,
+		Exchanges: proto.String(SupportedExchanges),
+		Ciphers:   proto.String(SupportedCiphers),
+		Hashes:    proto.String(SupportedHashes),
+	}
+
+	myPropBytes, err := proto.Marshal(myProp)
+	if err != nil {
+		return nil, err
+	}
+
+	theirPropBytes, err := exchangeMessages(in, out, myPropBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	theirProp := new(Propose)
+	if err := proto.Unmarshal(theirPropBytes, theirProp); err != nil {
+		return nil, err
+	}
+
+	// 2. identify the remote, and pick parameters
+	theirPubKey, err := ci.UnmarshalPublicKey(theirProp.GetPubkey())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkRemoteID(remote, theirPubKey); err != nil {
+		return nil, err
+	}
 	u.DOut("identify: Got node id: %s", remote.ID.Pretty())
 
+	order := bytes.Compare(
+		hashConcat(theirProp.GetPubkey(), myProp.GetRand()),
+		hashConcat(myProp.GetPubkey(), theirProp.GetRand()))
+	if order == 0 {
+		return nil, ErrTalkingToSelf
+	}
+
+	exchange, err := selectBest(order, SupportedExchanges, theirProp.GetExchanges())
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := selectBest(order, SupportedCiphers, theirProp.GetCiphers())
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := selectBest(order, SupportedHashes, theirProp.GetHashes())
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. exchange signed ephemeral keys
+	epriv, epub, err := generateEphemeral(exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := self.PrivKey.Sign(join(myPropBytes, theirPropBytes, epub))
+	if err != nil {
+		return nil, err
+	}
+
+	myExBytes, err := proto.Marshal(&Exchange{Epubkey: epub, Signature: sig})
+	if err != nil {
+		return nil, err
+	}
+
+	theirExBytes, err := exchangeMessages(in, out, myExBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	theirEx := new(Exchange)
+	if err := proto.Unmarshal(theirExBytes, theirEx); err != nil {
+		return nil, err
+	}
+
+	ok, err := theirPubKey.Verify(join(theirPropBytes, myPropBytes, theirEx.GetEpubkey()),
+		theirEx.GetSignature())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("bad signature on ephemeral key")
+	}
+
+	// 4. derive keys, and wrap the channels
+	secret, err := epriv.sharedSecret(theirEx.GetEpubkey())
+	if err != nil {
+		return nil, err
+	}
+
+	k1, k2, err := stretchKeys(cipher, hash, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// the side that won the ordering sends with k1.
+	if order < 0 {
+		k1, k2 = k2, k1
+	}
+
+	sp, err := newSecurePipe(cipher, k1, k2, in, out)
+	if err != nil {
+		return nil, err
+	}
+
+	// 5. prove we share the keys
+	select {
+	case sp.Out <- theirProp.GetRand():
+	case <-time.After(HandshakeTimeout):
+		sp.Close()
+		return nil, u.ErrTimeout
+	}
+
+	select {
+	case got, ok := <-sp.In:
+		if !ok || !bytes.Equal(got, nonce) {
+			sp.Close()
+			return nil, errors.New("handshake did not verify")
+		}
+	case <-time.After(HandshakeTimeout):
+		sp.Close()
+		return nil, u.ErrTimeout
+	}
+
+	return sp, nil
+}
+
+// exchangeMessages sends mine, and waits for the remote's message.
+func exchangeMessages(in, out chan []byte, mine []byte) ([]byte, error) {
+	select {
+	case out <- mine:
+	case <-time.After(HandshakeTimeout):
+		return nil, u.ErrTimeout
+	}
+
+	select {
+	case theirs, ok := <-in:
+		if !ok {
+			return nil, errors.New("connection closed during handshake")
+		}
+		return theirs, nil
+	case <-time.After(HandshakeTimeout):
+		return nil, u.ErrTimeout
+	}
+}
+
+// checkRemoteID checks the remote key against the expected remote.ID, or
+// sets it if we did not know who we were talking to.
+func checkRemoteID(remote *peer.Peer, pk ci.PubKey) error {
+	id, err := peer.IDFromPubKey(pk)
+	if err != nil {
+		return err
+	}
+
+	if len(remote.ID) > 0 && !remote.ID.Equal(id) {
+		return ErrBadRemoteID
+	}
+
+	remote.ID = id
+	remote.PubKey = pk
 	return nil
 }
+
+// selectBest returns the first of the preferred list also in the other.
+// Whose list is preferred depends on order, so both sides agree.
+func selectBest(order int, mine, theirs string) (string, error) {
+	first, second := strings.Split(mine, ","), strings.Split(theirs, ",")
+	if order < 0 {
+		first, second = second, first
+	}
+
+	for _, f := range first {
+		for _, s := range second {
+			if f == s {
+				return f, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no algorithm in common: %s / %s", mine, theirs)
+}
+
+func hashConcat(a, b []byte) []byte {
+	h := sha256.Sum256(join(a, b))
+	return h[:]
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
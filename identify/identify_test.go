@@ -0,0 +1,165 @@
+package identify
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	ci "../crypto"
+	peer "../peer"
+	u "../util"
+)
+
+func newPeer(t *testing.T, typ ci.KeyType) *peer.Peer {
+	sk, pk, err := ci.GenerateKeyPair(typ, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := peer.IDFromPubKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &peer.Peer{ID: id, PrivKey: sk, PubKey: pk}
+}
+
+type handshakeResult struct {
+	sp  *SecurePipe
+	err error
+}
+
+// handshake runs both sides of a handshake over buffered channels.
+func handshake(a, b, aSeesB, bSeesA *peer.Peer) (*handshakeResult, *handshakeResult) {
+	atob := make(chan []byte, 10)
+	btoa := make(chan []byte, 10)
+
+	done := make(chan *handshakeResult)
+	go func() {
+		sp, err := Handshake(b, bSeesA, atob, btoa)
+		done <- &handshakeResult{sp, err}
+	}()
+
+	sp, err := Handshake(a, aSeesB, btoa, atob)
+	return &handshakeResult{sp, err}, <-done
+}
+
+func TestHandshake(t *testing.T) {
+	a := newPeer(t, ci.KeyType_RSA)
+	b := newPeer(t, ci.KeyType_Ed25519)
+
+	// a knows who it dials; b learns who dialed it.
+	aSeesB := &peer.Peer{ID: b.ID}
+	bSeesA := new(peer.Peer)
+
+	ra, rb := handshake(a, b, aSeesB, bSeesA)
+	if ra.err != nil || rb.err != nil {
+		t.Fatal(ra.err, rb.err)
+	}
+
+	if !bSeesA.ID.Equal(a.ID) || !bSeesA.PubKey.Equals(a.PubKey) {
+		t.Error("b did not learn a's identity")
+	}
+
+	for _, msg := range []string{"beep", "boop", "beep"} {
+		ra.sp.Out <- []byte(msg)
+		if got := <-rb.sp.In; string(got) != msg {
+			t.Errorf("expected %s, got %s", msg, got)
+		}
+
+		rb.sp.Out <- []byte(msg)
+		if got := <-ra.sp.In; string(got) != msg {
+			t.Errorf("expected %s, got %s", msg, got)
+		}
+	}
+}
+
+func TestHandshakeWrongID(t *testing.T) {
+	a := newPeer(t, ci.KeyType_Ed25519)
+	b := newPeer(t, ci.KeyType_Ed25519)
+	c := newPeer(t, ci.KeyType_Ed25519)
+
+	// b waits for a until it gives up.
+	defer func(d time.Duration) { HandshakeTimeout = d }(HandshakeTimeout)
+	HandshakeTimeout = time.Millisecond * 100
+
+	// a expects c, but reaches b.
+	ra, _ := handshake(a, b, &peer.Peer{ID: c.ID}, new(peer.Peer))
+	if ra.err != ErrBadRemoteID {
+		t.Error("expected ErrBadRemoteID, got", ra.err)
+	}
+}
+
+func TestHandshakeStalledSend(t *testing.T) {
+	a := newPeer(t, ci.KeyType_Ed25519)
+
+	defer func(d time.Duration) { HandshakeTimeout = d }(HandshakeTimeout)
+	HandshakeTimeout = time.Millisecond * 100
+
+	// nobody reads what a sends.
+	done := make(chan error)
+	go func() {
+		_, err := Handshake(a, new(peer.Peer), make(chan []byte), make(chan []byte))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != u.ErrTimeout {
+			t.Error("expected ErrTimeout, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handshake blocked on a stalled send")
+	}
+}
+
+func TestSecurePipeTampering(t *testing.T) {
+	k1, k2, err := stretchKeys("AES-256", "SHA256", []byte("shared secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// we carry the sealed messages from a to b ourselves.
+	aOut := make(chan []byte, 10)
+	bIn := make(chan []byte, 10)
+
+	a, err := newSecurePipe("AES-256", k1, k2, make(chan []byte), aOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newSecurePipe("AES-256", k2, k1, bIn, make(chan []byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("attack at dawn")
+	a.Out <- secret
+	a.Out <- secret
+
+	first := <-aOut
+	if bytes.Contains(first, secret) {
+		t.Error("message sent in the clear")
+	}
+
+	bIn <- first
+	if got := <-b.In; !bytes.Equal(got, secret) {
+		t.Error("expected the secret, got", got)
+	}
+
+	// replaying the first message fails, as the sequence moved on.
+	bIn <- first
+	if _, ok := <-b.In; ok {
+		t.Error("replayed message was delivered")
+	}
+
+	// and so does a tampered message, on a fresh pipe.
+	c, err := newSecurePipe("AES-256", k2, k1, bIn, make(chan []byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first[0] ^= 0xff
+	bIn <- first
+	if _, ok := <-c.In; ok {
+		t.Error("tampered message was delivered")
+	}
+}
@@ -0,0 +1,215 @@
+package identify
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync"
+
+	u "../util"
+)
+
+// SecurePipe wraps a pair of message channels in authenticated
+// encryption. Messages written to Out are sealed and sent; messages
+// received are opened and delivered on In. A message that fails to
+// open ends the pipe: In is closed.
+type SecurePipe struct {
+	In  chan []byte
+	Out chan []byte
+
+	in  chan []byte
+	out chan []byte
+
+	sendAEAD cipher.AEAD
+	recvAEAD cipher.AEAD
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// PipeBuffer is the size of the buffer of the SecurePipe channels.
+var PipeBuffer = 10
+
+func newSecurePipe(cipherName string, sendKey, recvKey []byte, in, out chan []byte) (*SecurePipe, error) {
+	sendAEAD, err := newAEAD(cipherName, sendKey)
+	if err != nil {
+		return nil, err
+	}
+
+	recvAEAD, err := newAEAD(cipherName, recvKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &SecurePipe{
+		In:       make(chan []byte, PipeBuffer),
+		Out:      make(chan []byte, PipeBuffer),
+		in:       in,
+		out:      out,
+		sendAEAD: sendAEAD,
+		recvAEAD: recvAEAD,
+		closed:   make(chan struct{}),
+	}
+
+	go sp.handleOut()
+	go sp.handleIn()
+	return sp, nil
+}
+
+// Close stops the pipe. The underlying channels are left to their owner.
+func (sp *SecurePipe) Close() {
+	sp.closeOnce.Do(func() { close(sp.closed) })
+}
+
+func (sp *SecurePipe) handleOut() {
+	var seq uint64
+	for {
+		select {
+		case <-sp.closed:
+			return
+		case msg, ok := <-sp.Out:
+			if !ok {
+				return
+			}
+
+			sealed := sp.sendAEAD.Seal(nil, nonceFor(sp.sendAEAD, seq), msg, nil)
+			seq++
+
+			select {
+			case sp.out <- sealed:
+			case <-sp.closed:
+				return
+			}
+		}
+	}
+}
+
+func (sp *SecurePipe) handleIn() {
+	defer close(sp.In)
+
+	var seq uint64
+	for {
+		select {
+		case <-sp.closed:
+			return
+		case data, ok := <-sp.in:
+			if !ok {
+				return
+			}
+
+			// sequence numbers in the nonces stop replayed or reordered
+			// messages from opening.
+			msg, err := sp.recvAEAD.Open(nil, nonceFor(sp.recvAEAD, seq), data, nil)
+			if err != nil {
+				u.PErr("identify: dropping connection, bad message: %v\n", err)
+				sp.Close()
+				return
+			}
+			seq++
+
+			select {
+			case sp.In <- msg:
+			case <-sp.closed:
+				return
+			}
+		}
+	}
+}
+
+// nonceFor returns the nonce of the seq-th message.
+func nonceFor(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+func newAEAD(cipherName string, key []byte) (cipher.AEAD, error) {
+	var block cipher.Block
+	var err error
+
+	switch cipherName {
+	case "AES-128", "AES-256":
+		block, err = aes.NewCipher(key)
+	default:
+		return nil, fmt.Errorf("unrecognized cipher: %s", cipherName)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ephemeral is the private half of an ephemeral key exchange.
+type ephemeral struct {
+	curve ecdh.Curve
+	priv  *ecdh.PrivateKey
+}
+
+// generateEphemeral returns a new ephemeral key for the named exchange,
+// and its serialized public half.
+func generateEphemeral(exchange string) (*ephemeral, []byte, error) {
+	var curve ecdh.Curve
+	switch exchange {
+	case "P-256":
+		curve = ecdh.P256()
+	case "P-384":
+		curve = ecdh.P384()
+	case "X25519":
+		curve = ecdh.X25519()
+	default:
+		return nil, nil, fmt.Errorf("unrecognized key exchange: %s", exchange)
+	}
+
+	priv, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &ephemeral{curve: curve, priv: priv}, priv.PublicKey().Bytes(), nil
+}
+
+// sharedSecret computes the secret shared with the holder of theirs.
+func (e *ephemeral) sharedSecret(theirs []byte) ([]byte, error) {
+	pub, err := e.curve.NewPublicKey(theirs)
+	if err != nil {
+		return nil, err
+	}
+	return e.priv.ECDH(pub)
+}
+
+// stretchKeys derives a key for each direction from the shared secret.
+func stretchKeys(cipherName, hashName string, secret []byte) ([]byte, []byte, error) {
+	var keySize int
+	switch cipherName {
+	case "AES-128":
+		keySize = 16
+	case "AES-256":
+		keySize = 32
+	default:
+		return nil, nil, fmt.Errorf("unrecognized cipher: %s", cipherName)
+	}
+
+	var h func() hash.Hash
+	switch hashName {
+	case "SHA256":
+		h = sha256.New
+	case "SHA512":
+		h = sha512.New
+	default:
+		return nil, nil, fmt.Errorf("unrecognized hash: %s", hashName)
+	}
+
+	derive := func(label string) []byte {
+		m := hmac.New(h, secret)
+		m.Write([]byte("ipfs handshake " + label))
+		return m.Sum(nil)[:keySize]
+	}
+	return derive("key 1"), derive("key 2"), nil
+}
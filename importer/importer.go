@@ -3,8 +3,8 @@ package importer
 import (
 	"fmt"
 	dag "../merkledag"
+	ft "../unixfs"
 	"io"
-	"io/ioutil"
 	"os"
 )
 
@@ -14,36 +14,147 @@ var BlockSizeLimit = int64(1048576) // 1 MB
 // ErrSizeLimitExceeded signals that a block is larger than BlockSizeLimit.
 var ErrSizeLimitExceeded = fmt.Errorf("object size limit exceeded")
 
-// todo: incremental construction with an ipfs node. dumping constructed
-// objects into the datastore, to avoid buffering all in memory
+// DefaultSplitter is the Splitter used by NewDagFromReader.
+var DefaultSplitter Splitter = &SizeSplitter{Size: 1024 * 512}
 
-// NewDagFromReader constructs a Merkle DAG from the given io.Reader.
-// size required for block construction.
-func NewDagFromReader(r io.Reader, size int64) (*dag.Node, error) {
-	// todo: block-splitting based on rabin fingerprinting
-	// todo: block-splitting with user-defined function
-	// todo: block-splitting at all. :P
+// NewDagFromReader constructs a Merkle DAG from the given io.Reader,
+// splitting it into blocks with DefaultSplitter.
+func NewDagFromReader(r io.Reader) (*dag.Node, error) {
+	return NewDagFromReaderWithSplitter(r, DefaultSplitter)
+}
+
+// NewDagFromReaderWithSplitter constructs a Merkle DAG from the given
+// io.Reader, splitting it into blocks with spl. Each block becomes a leaf
+// node linked from the returned root. Data that fits in a single block
+// yields a single node.
+func NewDagFromReaderWithSplitter(r io.Reader, spl Splitter) (*dag.Node, error) {
+	blkChan, errs := spl.Split(r)
+
+	var leaves [][]byte
+	var err error
+	for blk := range blkChan {
+		if int64(len(blk)) > BlockSizeLimit {
+			err = ErrSizeLimitExceeded
+			continue // drain the splitter.
+		}
+		leaves = append(leaves, blk)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := splitError(errs); err != nil {
+		return nil, err
+	}
+
+	switch len(leaves) {
+	case 0:
+		return &dag.Node{Data: ft.FilePBData(nil)}, nil
+	case 1:
+		return &dag.Node{Data: ft.FilePBData(leaves[0])}, nil
+	}
+
+	root := &dag.Node{}
+	fsroot := &ft.FSNode{Type: ft.TFile}
+	for _, blk := range leaves {
+		leaf := &dag.Node{Data: ft.FilePBData(blk)}
+		if err := root.AddNodeLink("", leaf); err != nil {
+			return nil, err
+		}
+		fsroot.AddBlockSize(uint64(len(blk)))
+	}
 
-	// totally just trusts the reported size. fix later.
-	if size > BlockSizeLimit { // 1 MB limit for now.
-		return nil, ErrSizeLimitExceeded
+	root.Data, err = fsroot.GetBytes()
+	if err != nil {
+		return nil, err
 	}
+	return root, nil
+}
 
-	// we're doing it live!
-	buf, err := ioutil.ReadAll(r)
+// BuildDagFromReader constructs a Merkle DAG from the given io.Reader,
+// writing each node to ds as soon as it is complete, so arbitrarily large
+// inputs can be imported in bounded memory. Blocks are arranged with a
+// BalancedLayout. Returns the root, which is also written to ds.
+func BuildDagFromReader(r io.Reader, ds *dag.DAGService, spl Splitter) (*dag.Node, error) {
+	layout := &BalancedLayout{MaxLinks: DefaultLinksPerBlock}
+	return BuildDagFromReaderWithLayout(r, ds, spl, layout)
+}
+
+// BuildDagFromReaderWithLayout is like BuildDagFromReader, but arranges
+// the blocks with the given Layout.
+func BuildDagFromReaderWithLayout(r io.Reader, ds *dag.DAGService, spl Splitter,
+	layout Layout) (*dag.Node, error) {
+	return buildDag(r, ds, spl, layout, nil)
+}
+
+// BuildDagFromFile constructs a Merkle DAG from the file at given path,
+// like BuildDagFromReaderWithLayout. The file's mode and modification time
+// are recorded in the root's unixfs envelope.
+func BuildDagFromFile(fpath string, ds *dag.DAGService, spl Splitter,
+	layout Layout) (*dag.Node, error) {
+
+	stat, err := os.Stat(fpath)
 	if err != nil {
 		return nil, err
 	}
 
-	if int64(len(buf)) > BlockSizeLimit {
-		return nil, ErrSizeLimitExceeded // lying punk.
+	if stat.IsDir() {
+		return nil, fmt.Errorf("`fpath` is a directory")
 	}
 
-	root := &dag.Node{Data: buf}
-	// no children for now because not block splitting yet
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return buildDag(f, ds, spl, layout, stat)
+}
+
+// buildDag lays out the blocks of r, and writes the root to ds. If stat is
+// given, its mode and mtime are recorded in the root.
+func buildDag(r io.Reader, ds *dag.DAGService, spl Splitter, layout Layout,
+	stat os.FileInfo) (*dag.Node, error) {
+
+	blocks, errs := spl.Split(r)
+	db := newDagBuilder(ds, blocks, errs)
+	uroot, err := layout.Build(db)
+	if err != nil {
+		db.drain()
+		return nil, err
+	}
+
+	// a failed read ends the blocks early, as if the input was shorter.
+	if db.err != nil {
+		return nil, db.err
+	}
+
+	if stat != nil {
+		uroot.FSNode().Mode = stat.Mode().Perm()
+		uroot.FSNode().Mtime = stat.ModTime()
+	}
+
+	root, err := uroot.GetDagNode()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ds.Put(root); err != nil {
+		return nil, err
+	}
 	return root, nil
 }
 
+// splitError returns the error a splitter ended with, if any. It must be
+// called once the splitter's chunk channel is closed.
+func splitError(errs chan error) error {
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
 // NewDagFromFile constructs a Merkle DAG from the file at given path.
 func NewDagFromFile(fpath string) (*dag.Node, error) {
 	stat, err := os.Stat(fpath)
@@ -61,5 +172,5 @@ func NewDagFromFile(fpath string) (*dag.Node, error) {
 	}
 	defer f.Close()
 
-	return NewDagFromReader(f, stat.Size())
+	return NewDagFromReader(f)
 }
@@ -0,0 +1,431 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+
+	bs "../blocks"
+	dag "../merkledag"
+	ft "../unixfs"
+	u "../util"
+)
+
+func randBytes(t *testing.T, n int) []byte {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func unwrap(t *testing.T, nd *dag.Node) []byte {
+	data, err := ft.UnwrapData(nd.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func collect(t *testing.T, spl Splitter, data []byte) [][]byte {
+	var out [][]byte
+	blks, errs := spl.Split(bytes.NewReader(data))
+	for blk := range blks {
+		out = append(out, blk)
+	}
+	if err := splitError(errs); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSizeSplitter(t *testing.T) {
+	data := randBytes(t, 1000)
+	chunks := collect(t, &SizeSplitter{Size: 300}, data)
+
+	if len(chunks) != 4 {
+		t.Fatalf("expected 4 chunks, got %d", len(chunks))
+	}
+
+	if len(chunks[3]) != 100 {
+		t.Error("last chunk should hold the remainder", len(chunks[3]))
+	}
+
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Error("chunks do not reassemble into the input")
+	}
+}
+
+func TestRabinSplitter(t *testing.T) {
+	data := randBytes(t, 1<<20)
+	spl, err := NewRabin(1 << 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := collect(t, spl, data)
+
+	for i, c := range chunks[:len(chunks)-1] {
+		if len(c) < spl.MinBlockSize || len(c) > spl.MaxBlockSize {
+			t.Fatalf("chunk %d has out of bounds size %d", i, len(c))
+		}
+	}
+
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Error("chunks do not reassemble into the input")
+	}
+
+	// prepending data should only disturb the first few chunks.
+	shifted := collect(t, spl, append(randBytes(t, 100), data...))
+	seen := map[string]bool{}
+	for _, c := range chunks {
+		seen[string(c)] = true
+	}
+
+	shared := 0
+	for _, c := range shifted {
+		if seen[string(c)] {
+			shared++
+		}
+	}
+
+	if shared < len(chunks)-2 {
+		t.Errorf("expected most chunks to be shared, got %d of %d", shared, len(chunks))
+	}
+}
+
+func TestNewDagFromReader(t *testing.T) {
+	data := randBytes(t, 1<<20)
+	root, err := NewDagFromReaderWithSplitter(bytes.NewReader(data), &SizeSplitter{Size: 1 << 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(root.Links) != 16 {
+		t.Fatalf("expected 16 links, got %d", len(root.Links))
+	}
+
+	var buf bytes.Buffer
+	for _, l := range root.Links {
+		buf.Write(unwrap(t, l.Node))
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("leaves do not reassemble into the input")
+	}
+
+	small, err := NewDagFromReader(bytes.NewReader([]byte("beep boop")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(small.Links) != 0 || string(unwrap(t, small)) != "beep boop" {
+		t.Error("small input should be a single node")
+	}
+}
+
+func TestBuildDagFromReader(t *testing.T) {
+	bsrv, err := bs.NewBlockService(ds.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := &dag.DAGService{Blocks: bsrv}
+
+	data := randBytes(t, 1<<20)
+	root, err := BuildDagFromReader(bytes.NewReader(data), dserv, &SizeSplitter{Size: 1 << 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := dserv.Get(context.Background(), k)
+	if err != nil {
+		t.Fatal("root was not written to the DAGService", err)
+	}
+
+	for _, l := range root.Links {
+		if l.Node != nil {
+			t.Fatal("leaf node was kept in memory")
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, l := range stored.Links {
+		leaf, err := dserv.Get(context.Background(), u.Key(l.Hash))
+		if err != nil {
+			t.Fatal("leaf was not written to the DAGService", err)
+		}
+		buf.Write(unwrap(t, leaf))
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("stored leaves do not reassemble into the input")
+	}
+}
+
+// leafDepths collects the depth of every leaf under nd.
+func leafDepths(t *testing.T, dserv *dag.DAGService, nd *dag.Node, depth int, out map[int]int) {
+	if len(nd.Links) == 0 {
+		out[depth]++
+		return
+	}
+
+	for _, l := range nd.Links {
+		child, err := dserv.Get(context.Background(), u.Key(l.Hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		leafDepths(t, dserv, child, depth+1, out)
+	}
+}
+
+func testLayout(t *testing.T, layout Layout, size int) (*dag.DAGService, *dag.Node) {
+	bsrv, err := bs.NewBlockService(ds.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := &dag.DAGService{Blocks: bsrv}
+
+	data := randBytes(t, size)
+	root, err := BuildDagFromReaderWithLayout(bytes.NewReader(data), dserv,
+		&SizeSplitter{Size: 512}, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := NewDagReader(context.Background(), root, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadAll(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Fatal("DagReader output does not match the input")
+	}
+	return dserv, root
+}
+
+func TestBalancedLayout(t *testing.T) {
+	dserv, root := testLayout(t, &BalancedLayout{MaxLinks: 4}, 512*50)
+
+	depths := map[int]int{}
+	leafDepths(t, dserv, root, 0, depths)
+	if len(depths) != 1 || depths[3] != 50 {
+		t.Error("expected all 50 leaves at depth 3, got", depths)
+	}
+
+	if len(root.Links) > 4 {
+		t.Error("root has more than MaxLinks links")
+	}
+}
+
+func TestTrickleLayout(t *testing.T) {
+	dserv, root := testLayout(t, &TrickleLayout{MaxLinks: 4, LayerRepeat: 2}, 512*50)
+
+	depths := map[int]int{}
+	leafDepths(t, dserv, root, 0, depths)
+	if depths[1] != 4 {
+		t.Error("expected the first 4 leaves right under the root, got", depths)
+	}
+}
+
+func TestLayoutsSmallInput(t *testing.T) {
+	layouts := []Layout{
+		&BalancedLayout{MaxLinks: 4},
+		&TrickleLayout{MaxLinks: 4, LayerRepeat: 2},
+	}
+
+	for _, l := range layouts {
+		_, root := testLayout(t, l, 100)
+		if len(root.Links) != 0 {
+			t.Error("single block input should be a single node")
+		}
+
+		testLayout(t, l, 0)
+	}
+}
+
+func TestLayoutBadParams(t *testing.T) {
+	bsrv, err := bs.NewBlockService(ds.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := &dag.DAGService{Blocks: bsrv}
+
+	bad := []struct {
+		layout Layout
+		err    error
+	}{
+		{&BalancedLayout{MaxLinks: 0}, ErrMaxLinks},
+		{&BalancedLayout{MaxLinks: 1}, ErrMaxLinks},
+		{&TrickleLayout{MaxLinks: 0, LayerRepeat: 2}, ErrMaxLinks},
+		{&TrickleLayout{MaxLinks: 1, LayerRepeat: 2}, ErrMaxLinks},
+		{&TrickleLayout{MaxLinks: 4, LayerRepeat: 0}, ErrLayerRepeat},
+	}
+
+	for _, b := range bad {
+		data := randBytes(t, 512*50)
+		_, err := BuildDagFromReaderWithLayout(bytes.NewReader(data), dserv,
+			&SizeSplitter{Size: 512}, b.layout)
+		if err != b.err {
+			t.Errorf("%#v: expected %v, got %v", b.layout, b.err, err)
+		}
+	}
+}
+
+// failingReader returns n bytes of data, then err.
+type failingReader struct {
+	n   int
+	err error
+}
+
+func (r *failingReader) Read(buf []byte) (int, error) {
+	if r.n == 0 {
+		return 0, r.err
+	}
+	if len(buf) > r.n {
+		buf = buf[:r.n]
+	}
+	r.n -= len(buf)
+	return len(buf), nil
+}
+
+func TestSplitReadError(t *testing.T) {
+	bsrv, err := bs.NewBlockService(ds.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := &dag.DAGService{Blocks: bsrv}
+	readErr := errors.New("disk on fire")
+
+	splitters := []Splitter{
+		&SizeSplitter{Size: 512},
+		&Rabin{AvgBlockSize: 512, MinBlockSize: 128, MaxBlockSize: 2048, WindowSize: 48},
+	}
+
+	for _, spl := range splitters {
+		_, err := BuildDagFromReader(&failingReader{n: 512 * 10, err: readErr}, dserv, spl)
+		if err != readErr {
+			t.Errorf("%T: expected the read error from BuildDagFromReader, got %v", spl, err)
+		}
+
+		_, err = NewDagFromReaderWithSplitter(&failingReader{n: 512 * 10, err: readErr}, spl)
+		if err != readErr {
+			t.Errorf("%T: expected the read error from NewDagFromReader, got %v", spl, err)
+		}
+	}
+}
+
+func TestSplitterBadParams(t *testing.T) {
+	if _, err := NewRabin(1000); err != ErrAvgBlockSize {
+		t.Error("expected ErrAvgBlockSize, got", err)
+	}
+	if _, err := NewRabin(0); err != ErrAvgBlockSize {
+		t.Error("expected ErrAvgBlockSize, got", err)
+	}
+
+	bad := []struct {
+		spl Splitter
+		err error
+	}{
+		{&SizeSplitter{Size: 0}, ErrBlockSize},
+		{&Rabin{AvgBlockSize: 512, MaxBlockSize: 2048}, ErrWindowSize},
+		{&Rabin{AvgBlockSize: 500, MaxBlockSize: 2048, WindowSize: 48}, ErrAvgBlockSize},
+	}
+
+	for _, b := range bad {
+		_, err := NewDagFromReaderWithSplitter(bytes.NewReader(randBytes(t, 1000)), b.spl)
+		if err != b.err {
+			t.Errorf("%#v: expected %v, got %v", b.spl, b.err, err)
+		}
+	}
+}
+
+func TestFileSizes(t *testing.T) {
+	_, root := testLayout(t, &BalancedLayout{MaxLinks: 4}, 512*50+7)
+
+	size, err := ft.DataSize(root.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 512*50+7 {
+		t.Error("root does not record the file size", size)
+	}
+}
+
+func TestDagReaderSeek(t *testing.T) {
+	layouts := []Layout{
+		&BalancedLayout{MaxLinks: 4},
+		&TrickleLayout{MaxLinks: 4, LayerRepeat: 2},
+	}
+
+	for _, l := range layouts {
+		bsize := 512*50 + 7
+		dserv, root := testLayout(t, l, bsize)
+
+		read, err := NewDagReader(context.Background(), root, dserv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(read)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		offsets := []int64{0, 1, 511, 512, 513, 4000, int64(bsize) - 10}
+		for _, off := range offsets {
+			n, err := read.Seek(off, os.SEEK_SET)
+			if err != nil || n != off {
+				t.Fatal("seek failed", off, err)
+			}
+
+			buf := make([]byte, 1000)
+			nread, err := io.ReadFull(read, buf)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(buf[:nread], data[off:off+int64(nread)]) {
+				t.Fatalf("bad data after seeking to %d", off)
+			}
+
+			if nread != 1000 && off+int64(nread) != int64(bsize) {
+				t.Fatalf("short read after seeking to %d", off)
+			}
+		}
+
+		// relative seeks.
+		read.Seek(100, os.SEEK_SET)
+		if n, _ := read.Seek(50, os.SEEK_CUR); n != 150 {
+			t.Error("SEEK_CUR landed at", n)
+		}
+
+		if n, _ := read.Seek(-7, os.SEEK_END); n != int64(bsize)-7 {
+			t.Error("SEEK_END landed at", n)
+		}
+
+		rest, _ := ioutil.ReadAll(read)
+		if !bytes.Equal(rest, data[bsize-7:]) {
+			t.Error("bad data after SEEK_END")
+		}
+
+		if _, err := read.Seek(-1, os.SEEK_SET); err != ErrInvalidSeek {
+			t.Error("expected invalid seek error, got", err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package importer
+
+import (
+	"errors"
+
+	dag "../merkledag"
+	ft "../unixfs"
+)
+
+// DefaultLinksPerBlock is the default maximum number of links per
+// intermediate node. It keeps intermediate nodes around 8KB.
+var DefaultLinksPerBlock = 174
+
+// DefaultLayerRepeat is the default number of subtrees of each depth a
+// trickle node gets.
+var DefaultLayerRepeat = 4
+
+// ErrMaxLinks signals a layout allowing fewer than 2 links per node, which
+// could never hold more than one block.
+var ErrMaxLinks = errors.New("layout needs at least 2 links per node")
+
+// ErrLayerRepeat signals a TrickleLayout with no subtrees per depth.
+var ErrLayerRepeat = errors.New("trickle layout needs a LayerRepeat of at least 1")
+
+// Layout arranges the blocks produced by a Splitter into a tree of nodes.
+// In every layout, file data is held by the leaves only; intermediate
+// nodes just link to their children, in order.
+type Layout interface {
+	Build(db *DagBuilder) (*UnixfsNode, error)
+}
+
+// UnixfsNode is a merkledag node under construction, along with its unixfs
+// envelope. The envelope is encoded into the node's Data once it is done.
+type UnixfsNode struct {
+	node *dag.Node
+	ufmt *ft.FSNode
+}
+
+// NewUnixfsNode returns an empty unixfs file node.
+func NewUnixfsNode() *UnixfsNode {
+	return &UnixfsNode{
+		node: new(dag.Node),
+		ufmt: &ft.FSNode{Type: ft.TFile},
+	}
+}
+
+// NumChildren returns the number of links of the node.
+func (n *UnixfsNode) NumChildren() int {
+	return len(n.node.Links)
+}
+
+// FSNode returns the node's unixfs envelope, e.g. to set its mode.
+func (n *UnixfsNode) FSNode() *ft.FSNode {
+	return n.ufmt
+}
+
+// GetDagNode encodes the envelope into the node, and returns it.
+func (n *UnixfsNode) GetDagNode() (*dag.Node, error) {
+	data, err := n.ufmt.GetBytes()
+	if err != nil {
+		return nil, err
+	}
+	n.node.Data = data
+	return n.node, nil
+}
+
+// DagBuilder hands out the blocks of a split stream to a Layout, and
+// writes the finished nodes to a DAGService.
+type DagBuilder struct {
+	serv   *dag.DAGService
+	blocks chan []byte
+	errs   chan error
+	next   []byte
+	done   bool
+
+	// the error the splitter ended with, once done.
+	err error
+}
+
+func newDagBuilder(serv *dag.DAGService, blocks chan []byte, errs chan error) *DagBuilder {
+	db := &DagBuilder{serv: serv, blocks: blocks, errs: errs}
+	db.prepareNext()
+	return db
+}
+
+// prepareNext peeks at the next block, so Done can tell if there are more.
+func (db *DagBuilder) prepareNext() {
+	blk, ok := <-db.blocks
+	db.next = blk
+	db.done = !ok
+	if db.done {
+		db.err = splitError(db.errs)
+	}
+}
+
+// Done returns whether all blocks have been handed out.
+func (db *DagBuilder) Done() bool {
+	return db.done
+}
+
+// NewLeaf returns a leaf node for the next block. At the end of the stream
+// it returns an empty leaf, so that empty inputs still get a node.
+func (db *DagBuilder) NewLeaf() (*UnixfsNode, error) {
+	blk := db.next
+	if !db.done {
+		db.prepareNext()
+	}
+	if db.err != nil {
+		return nil, db.err
+	}
+
+	if int64(len(blk)) > BlockSizeLimit {
+		return nil, ErrSizeLimitExceeded
+	}
+
+	leaf := NewUnixfsNode()
+	leaf.ufmt.Data = blk
+	return leaf, nil
+}
+
+// AddChild writes child to the DAGService and links it from parent. The
+// in-memory child is dropped, so finished subtrees are not kept around.
+func (db *DagBuilder) AddChild(parent, child *UnixfsNode) error {
+	nd, err := child.GetDagNode()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.serv.Put(nd); err != nil {
+		return err
+	}
+
+	if err := parent.node.AddNodeLink("", nd); err != nil {
+		return err
+	}
+	parent.node.Links[len(parent.node.Links)-1].Node = nil
+	parent.ufmt.AddBlockSize(child.ufmt.FileSize())
+	return nil
+}
+
+// FillNodeLayer adds leaves to nd until it has maxlinks links, or the
+// stream ends.
+func (db *DagBuilder) FillNodeLayer(nd *UnixfsNode, maxlinks int) error {
+	for nd.NumChildren() < maxlinks && !db.Done() {
+		leaf, err := db.NewLeaf()
+		if err != nil {
+			return err
+		}
+
+		if err := db.AddChild(nd, leaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drain consumes the remaining blocks, so the splitter can finish.
+func (db *DagBuilder) drain() {
+	for !db.done {
+		db.prepareNext()
+	}
+}
+
+// BalancedLayout builds trees where every leaf is at the same depth, and
+// every intermediate node but the rightmost ones is full. Good for random
+// access: any offset is reached in a logarithmic number of fetches.
+type BalancedLayout struct {
+	// MaxLinks is the maximum number of links per intermediate node.
+	MaxLinks int
+}
+
+// Build implements Layout.
+func (l *BalancedLayout) Build(db *DagBuilder) (*UnixfsNode, error) {
+	if l.MaxLinks < 2 {
+		return nil, ErrMaxLinks
+	}
+
+	root, err := db.NewLeaf()
+	if err != nil {
+		return nil, err
+	}
+
+	// each round, the current (full) tree becomes the first child of a
+	// new root one level deeper, which is then filled.
+	for depth := 1; !db.Done(); depth++ {
+		nroot := NewUnixfsNode()
+		if err := db.AddChild(nroot, root); err != nil {
+			return nil, err
+		}
+
+		if err := l.fill(db, nroot, depth); err != nil {
+			return nil, err
+		}
+		root = nroot
+	}
+	return root, nil
+}
+
+// fill adds subtrees of depth-1 to nd until it is full, or the stream ends.
+func (l *BalancedLayout) fill(db *DagBuilder, nd *UnixfsNode, depth int) error {
+	if depth == 1 {
+		return db.FillNodeLayer(nd, l.MaxLinks)
+	}
+
+	for nd.NumChildren() < l.MaxLinks && !db.Done() {
+		child := NewUnixfsNode()
+		if err := l.fill(db, child, depth-1); err != nil {
+			return err
+		}
+
+		if err := db.AddChild(nd, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrickleLayout builds trees where the first blocks sit right under the
+// root, followed by LayerRepeat subtrees of each increasing depth. Good for
+// streaming: the beginning of the file is reachable with few fetches, and
+// readers can start before the whole tree is known.
+type TrickleLayout struct {
+	// MaxLinks is the maximum number of leaves directly under a node.
+	MaxLinks int
+
+	// LayerRepeat is the number of subtrees of each depth under a node.
+	LayerRepeat int
+}
+
+// Build implements Layout.
+func (l *TrickleLayout) Build(db *DagBuilder) (*UnixfsNode, error) {
+	if l.MaxLinks < 2 {
+		return nil, ErrMaxLinks
+	}
+	if l.LayerRepeat < 1 {
+		return nil, ErrLayerRepeat
+	}
+
+	first, err := db.NewLeaf()
+	if err != nil {
+		return nil, err
+	}
+
+	// data that fits in a single block is just that block.
+	if db.Done() {
+		return first, nil
+	}
+
+	root := NewUnixfsNode()
+	if err := db.AddChild(root, first); err != nil {
+		return nil, err
+	}
+
+	if err := l.fill(db, root, -1); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// fill gives nd a layer of leaves, then LayerRepeat subtrees of each depth
+// below maxDepth (unbounded if maxDepth is -1).
+func (l *TrickleLayout) fill(db *DagBuilder, nd *UnixfsNode, maxDepth int) error {
+	if err := db.FillNodeLayer(nd, l.MaxLinks); err != nil {
+		return err
+	}
+
+	for depth := 1; maxDepth == -1 || depth < maxDepth; depth++ {
+		if db.Done() {
+			break
+		}
+
+		for i := 0; i < l.LayerRepeat && !db.Done(); i++ {
+			child := NewUnixfsNode()
+			if err := l.fill(db, child, depth); err != nil {
+				return err
+			}
+
+			if err := db.AddChild(nd, child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package importer
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// rabinPrime is the base of the rolling polynomial hash.
+const rabinPrime = 16777619
+
+// ErrAvgBlockSize signals a Rabin splitter whose AvgBlockSize is not a
+// power of two.
+var ErrAvgBlockSize = errors.New("rabin average block size must be a power of two")
+
+// ErrWindowSize signals a Rabin splitter with an empty window.
+var ErrWindowSize = errors.New("rabin window size must be positive")
+
+// Rabin splits data at content-defined boundaries, found with a Rabin-Karp
+// rolling hash over a small window of bytes. Since boundaries depend only
+// on nearby content, inserting or removing data in one place of a file
+// leaves the other chunks (and so their blocks) unchanged, which lets
+// different versions of a file share most of their blocks.
+type Rabin struct {
+	// AvgBlockSize is the expected chunk size. Must be a power of two.
+	AvgBlockSize int
+
+	// MinBlockSize and MaxBlockSize bound the chunk sizes.
+	MinBlockSize int
+	MaxBlockSize int
+
+	// WindowSize is the number of bytes the fingerprint is computed over.
+	WindowSize int
+}
+
+// NewRabin returns a Rabin splitter with the given average chunk size,
+// and sensible bounds around it. avg must be a power of two.
+func NewRabin(avg int) (*Rabin, error) {
+	rs := &Rabin{
+		AvgBlockSize: avg,
+		MinBlockSize: avg / 4,
+		MaxBlockSize: avg * 4,
+		WindowSize:   48,
+	}
+	if err := rs.check(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// check returns an error if the parameters of rs cannot split anything.
+func (rs *Rabin) check() error {
+	if rs.AvgBlockSize <= 0 || rs.AvgBlockSize&(rs.AvgBlockSize-1) != 0 {
+		return ErrAvgBlockSize
+	}
+	if rs.WindowSize <= 0 {
+		return ErrWindowSize
+	}
+	if rs.MaxBlockSize <= 0 {
+		return ErrBlockSize
+	}
+	return nil
+}
+
+// Split implements Splitter. Parameters set by hand are checked as
+// NewRabin does, and the error is sent on the error channel.
+func (rs *Rabin) Split(r io.Reader) (chan []byte, chan error) {
+	out := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(out)
+		if err := rs.check(); err != nil {
+			errs <- err
+			return
+		}
+
+		in := bufio.NewReader(r)
+		mask := uint64(rs.AvgBlockSize - 1)
+
+		// prime^WindowSize, to remove the byte leaving the window.
+		pow := uint64(1)
+		for i := 0; i < rs.WindowSize; i++ {
+			pow *= rabinPrime
+		}
+
+		window := make([]byte, rs.WindowSize)
+		wpos := 0
+		var hash uint64
+		var chunk []byte
+
+		for {
+			b, err := in.ReadByte()
+			if err == io.EOF {
+				if len(chunk) > 0 {
+					out <- chunk
+				}
+				return
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			chunk = append(chunk, b)
+
+			// roll the window forward by one byte.
+			hash = hash*rabinPrime + uint64(b) - pow*uint64(window[wpos])
+			window[wpos] = b
+			wpos = (wpos + 1) % rs.WindowSize
+
+			if len(chunk) < rs.MinBlockSize {
+				continue
+			}
+
+			if hash&mask == 0 || len(chunk) >= rs.MaxBlockSize {
+				out <- chunk
+				chunk = nil
+			}
+		}
+	}()
+	return out, errs
+}
@@ -0,0 +1,212 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	dag "../merkledag"
+	ft "../unixfs"
+	u "../util"
+)
+
+// ErrInvalidSeek signals a seek to a negative offset or with a bad whence.
+var ErrInvalidSeek = errors.New("invalid seek")
+
+// DagReader reads the data of a unixfs file DAG back as a byte stream,
+// walking the nodes in order. It works with any Layout, fetching child
+// nodes from the DAGService only when the read reaches them. Seeking uses
+// the block sizes recorded in each node to skip over whole subtrees,
+// without fetching them.
+type DagReader struct {
+	serv *dag.DAGService
+	root *dag.Node
+
+	// ctx bounds the fetching of child nodes.
+	ctx context.Context
+
+	// size is the total size of the file data.
+	size uint64
+
+	// offset is the current position in the file data.
+	offset int64
+
+	// stack of intermediate nodes being walked, innermost last.
+	stack []*readerFrame
+
+	// buf holds the unread data of the current node.
+	buf *bytes.Reader
+}
+
+// readerFrame tracks the next link to follow in an intermediate node.
+type readerFrame struct {
+	nd   *dag.Node
+	next int
+}
+
+// NewDagReader returns a reader for the file DAG rooted at nd. Child nodes
+// are fetched within ctx, so reads fail once it is done.
+// Directories cannot be read.
+func NewDagReader(ctx context.Context, nd *dag.Node, serv *dag.DAGService) (*DagReader, error) {
+	fsn, err := ft.FSNodeFromBytes(nd.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &DagReader{
+		serv: serv,
+		root: nd,
+		ctx:  ctx,
+		size: fsn.FileSize(),
+		buf:  bytes.NewReader(nil),
+	}
+	if err := dr.enter(nd); err != nil {
+		return nil, err
+	}
+	return dr, nil
+}
+
+// Size returns the total size of the file data.
+func (dr *DagReader) Size() uint64 {
+	return dr.size
+}
+
+// enter starts reading nd: its own data is buffered, and its links walked
+// after.
+func (dr *DagReader) enter(nd *dag.Node) error {
+	return dr.enterAt(nd, 0)
+}
+
+// enterAt positions the reader at offset within the data under nd,
+// descending through the links that hold it.
+func (dr *DagReader) enterAt(nd *dag.Node, offset uint64) error {
+	for {
+		fsn, err := ft.FSNodeFromBytes(nd.Data)
+		if err != nil {
+			return err
+		}
+
+		if fsn.Type == ft.TDirectory {
+			return ft.ErrIsDir
+		}
+
+		data := fsn.Data
+		if fsn.Type == ft.TRaw || len(nd.Links) == 0 || offset < uint64(len(data)) {
+			if offset > uint64(len(data)) {
+				offset = uint64(len(data))
+			}
+			dr.buf = bytes.NewReader(data[offset:])
+
+			if fsn.Type != ft.TRaw && len(nd.Links) > 0 {
+				dr.stack = append(dr.stack, &readerFrame{nd: nd})
+			}
+			return nil
+		}
+
+		// skip over the subtrees before the one holding offset.
+		offset -= uint64(len(data))
+		if len(fsn.Blocksizes) != len(nd.Links) {
+			return ft.ErrMalformedFileFormat
+		}
+
+		i := 0
+		for ; i < len(nd.Links); i++ {
+			if offset < fsn.Blocksizes[i] {
+				break
+			}
+			offset -= fsn.Blocksizes[i]
+		}
+
+		if i == len(nd.Links) {
+			// past the end of the data.
+			dr.buf = bytes.NewReader(nil)
+			return nil
+		}
+
+		dr.stack = append(dr.stack, &readerFrame{nd: nd, next: i + 1})
+		nd, err = dr.getChild(nd.Links[i])
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// getChild returns the node a link points to, fetching it if needed.
+func (dr *DagReader) getChild(link *dag.Link) (*dag.Node, error) {
+	if link.Node != nil {
+		return link.Node, nil
+	}
+	return dr.serv.Get(dr.ctx, u.Key(link.Hash))
+}
+
+// precalcNextBuf advances to the next node, fetching nodes as needed.
+func (dr *DagReader) precalcNextBuf() error {
+	for len(dr.stack) > 0 {
+		top := dr.stack[len(dr.stack)-1]
+		if top.next >= len(top.nd.Links) {
+			dr.stack = dr.stack[:len(dr.stack)-1]
+			continue
+		}
+
+		link := top.nd.Links[top.next]
+		top.next++
+
+		child, err := dr.getChild(link)
+		if err != nil {
+			return err
+		}
+		return dr.enter(child)
+	}
+	return io.EOF
+}
+
+// Read implements io.Reader.
+func (dr *DagReader) Read(b []byte) (int, error) {
+	total := 0
+	for total < len(b) {
+		n, _ := dr.buf.Read(b[total:])
+		total += n
+		if total == len(b) {
+			break
+		}
+
+		if err := dr.precalcNextBuf(); err != nil {
+			dr.offset += int64(total)
+			if err == io.EOF && total > 0 {
+				return total, nil
+			}
+			return total, err
+		}
+	}
+	dr.offset += int64(total)
+	return total, nil
+}
+
+// Seek implements io.Seeker.
+func (dr *DagReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR:
+		offset += dr.offset
+	case os.SEEK_END:
+		offset += int64(dr.size)
+	default:
+		return dr.offset, ErrInvalidSeek
+	}
+
+	if offset < 0 {
+		return dr.offset, ErrInvalidSeek
+	}
+
+	dr.stack = nil
+	if err := dr.enterAt(dr.root, uint64(offset)); err != nil {
+		return dr.offset, err
+	}
+
+	dr.offset = offset
+	return offset, nil
+}
+
+var _ io.ReadSeeker = &DagReader{}
@@ -0,0 +1,54 @@
+package importer
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrBlockSize signals a splitter asked for chunks of no bytes.
+var ErrBlockSize = errors.New("splitter needs a positive block size")
+
+// Splitter splits a stream of data into chunks, each of which becomes a
+// block in the Merkle DAG.
+type Splitter interface {
+	// Split reads r until EOF, sending each chunk on the returned channel,
+	// which is closed when r is exhausted. If reading fails, the error is
+	// sent on the error channel before the chunk channel is closed, so a
+	// failed read can be told apart from the end of the input.
+	Split(r io.Reader) (chan []byte, chan error)
+}
+
+// SizeSplitter splits data into fixed-size chunks. The last chunk may
+// be shorter.
+type SizeSplitter struct {
+	Size int
+}
+
+// Split implements Splitter.
+func (ss *SizeSplitter) Split(r io.Reader) (chan []byte, chan error) {
+	out := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(out)
+		if ss.Size <= 0 {
+			errs <- ErrBlockSize
+			return
+		}
+
+		for {
+			chunk := make([]byte, ss.Size)
+			nread, err := io.ReadFull(r, chunk)
+			if nread > 0 {
+				out <- chunk[:nread]
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	return out, errs
+}
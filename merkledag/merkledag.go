@@ -1,6 +1,7 @@
 package merkledag
 
 import (
+	"context"
 	"fmt"
 	blocks "../blocks"
 	u "../util"
@@ -53,6 +54,7 @@ func (n *Node) AddNodeLink(name string, that *Node) error {
 		Name: name,
 		Size: s,
 		Hash: h,
+		Node: that,
 	})
 	return nil
 }
@@ -114,13 +116,34 @@ func (n *DAGService) Put(nd *Node) (u.Key, error) {
 	return n.Blocks.AddBlock(b)
 }
 
-// Get retrieves a node from the DAGService, fetching the block in the BlockService
-func (n *DAGService) Get(k u.Key) (*Node, error) {
+// AddRecursive adds the given node and all child nodes reachable through
+// in-memory links (Link.Node) to the DAGService.
+func (n *DAGService) AddRecursive(nd *Node) error {
+	_, err := n.Put(nd)
+	if err != nil {
+		return err
+	}
+
+	for _, link := range nd.Links {
+		if link.Node != nil {
+			err := n.AddRecursive(link.Node)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get retrieves a node from the DAGService, fetching the block in the BlockService.
+// Fetching from the network gives up when ctx is done.
+func (n *DAGService) Get(ctx context.Context, k u.Key) (*Node, error) {
 	if n == nil {
 		return nil, fmt.Errorf("DAGService is nil")
 	}
 
-	b, err := n.Blocks.GetBlock(k)
+	b, err := n.Blocks.GetBlock(ctx, k)
 	if err != nil {
 		return nil, err
 	}
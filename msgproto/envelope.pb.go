@@ -0,0 +1,119 @@
+// Code generated by protoc-gen-go.
+// source: envelope.proto
+// DO NOT EDIT!
+
+package msgproto
+
+import proto "github.com/golang/protobuf/proto"
+import json "encoding/json"
+import math "math"
+
+// Reference proto, json, and math imports to suppress error if they are not otherwise used.
+var _ = proto.Marshal
+var _ = &json.SyntaxError{}
+var _ = math.Inf
+
+type PBEnvelope_ErrorCode int32
+
+const (
+	PBEnvelope_OK          PBEnvelope_ErrorCode = 0
+	PBEnvelope_BAD_REQUEST PBEnvelope_ErrorCode = 1
+	PBEnvelope_NOT_FOUND   PBEnvelope_ErrorCode = 2
+	PBEnvelope_UNSUPPORTED PBEnvelope_ErrorCode = 3
+	PBEnvelope_INTERNAL    PBEnvelope_ErrorCode = 4
+	PBEnvelope_BUSY        PBEnvelope_ErrorCode = 5
+)
+
+var PBEnvelope_ErrorCode_name = map[int32]string{
+	0: "OK",
+	1: "BAD_REQUEST",
+	2: "NOT_FOUND",
+	3: "UNSUPPORTED",
+	4: "INTERNAL",
+	5: "BUSY",
+}
+var PBEnvelope_ErrorCode_value = map[string]int32{
+	"OK":          0,
+	"BAD_REQUEST": 1,
+	"NOT_FOUND":   2,
+	"UNSUPPORTED": 3,
+	"INTERNAL":    4,
+	"BUSY":        5,
+}
+
+func (x PBEnvelope_ErrorCode) Enum() *PBEnvelope_ErrorCode {
+	p := new(PBEnvelope_ErrorCode)
+	*p = x
+	return p
+}
+func (x PBEnvelope_ErrorCode) String() string {
+	return proto.EnumName(PBEnvelope_ErrorCode_name, int32(x))
+}
+func (x *PBEnvelope_ErrorCode) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(PBEnvelope_ErrorCode_value, data, "PBEnvelope_ErrorCode")
+	if err != nil {
+		return err
+	}
+	*x = PBEnvelope_ErrorCode(value)
+	return nil
+}
+
+type PBEnvelope struct {
+	Version          *uint32               `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	Protocol         *string               `protobuf:"bytes,2,req,name=protocol" json:"protocol,omitempty"`
+	Id               *uint64               `protobuf:"varint,3,req,name=id" json:"id,omitempty"`
+	Response         *bool                 `protobuf:"varint,4,opt,name=response" json:"response,omitempty"`
+	Error            *PBEnvelope_ErrorCode `protobuf:"varint,5,opt,name=error,enum=msgproto.PBEnvelope_ErrorCode" json:"error,omitempty"`
+	Payload          []byte                `protobuf:"bytes,6,opt,name=payload" json:"payload,omitempty"`
+	XXX_unrecognized []byte                `json:"-"`
+}
+
+func (m *PBEnvelope) Reset()         { *m = PBEnvelope{} }
+func (m *PBEnvelope) String() string { return proto.CompactTextString(m) }
+func (*PBEnvelope) ProtoMessage()    {}
+
+func (m *PBEnvelope) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+func (m *PBEnvelope) GetProtocol() string {
+	if m != nil && m.Protocol != nil {
+		return *m.Protocol
+	}
+	return ""
+}
+
+func (m *PBEnvelope) GetId() uint64 {
+	if m != nil && m.Id != nil {
+		return *m.Id
+	}
+	return 0
+}
+
+func (m *PBEnvelope) GetResponse() bool {
+	if m != nil && m.Response != nil {
+		return *m.Response
+	}
+	return false
+}
+
+func (m *PBEnvelope) GetError() PBEnvelope_ErrorCode {
+	if m != nil && m.Error != nil {
+		return *m.Error
+	}
+	return PBEnvelope_OK
+}
+
+func (m *PBEnvelope) GetPayload() []byte {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("msgproto.PBEnvelope_ErrorCode", PBEnvelope_ErrorCode_name, PBEnvelope_ErrorCode_value)
+}
@@ -1 +1,125 @@
+// Package msgproto defines the envelope every message between nodes is
+// sent in, and matches responses to the requests they answer.
+//
+// An envelope names the protocol of its payload, and carries a message ID.
+// Responses carry the ID of their request, and an error code if the
+// request failed.
 package msgproto
+
+import (
+	"errors"
+	"fmt"
+
+	proto "github.com/golang/protobuf/proto"
+
+	u "../util"
+)
+
+// Version is the version of the envelope format.
+const Version = 1
+
+// ProtocolID names the protocol of a payload, e.g. "/ipfs/dht".
+type ProtocolID string
+
+// ErrBadVersion signals an envelope of an unknown version.
+var ErrBadVersion = errors.New("unsupported envelope version")
+
+// Error is a failure reported by the remote peer in a response.
+type Error struct {
+	Code PBEnvelope_ErrorCode
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("remote error: %s", e.Code)
+}
+
+// Errors handlers return to report failures to the requester. Requesters
+// get the same values back.
+var (
+	ErrBadRequest  = &Error{PBEnvelope_BAD_REQUEST}
+	ErrNotFound    = &Error{PBEnvelope_NOT_FOUND}
+	ErrUnsupported = &Error{PBEnvelope_UNSUPPORTED}
+	ErrInternal    = &Error{PBEnvelope_INTERNAL}
+	ErrBusy        = &Error{PBEnvelope_BUSY}
+)
+
+// NewRequest returns the envelope of a request with the given ID. ID 0
+// marks requests that expect no response.
+func NewRequest(pid ProtocolID, id uint64, payload []byte) *PBEnvelope {
+	return &PBEnvelope{
+		Version:  proto.Uint32(Version),
+		Protocol: proto.String(string(pid)),
+		Id:       proto.Uint64(id),
+		Payload:  payload,
+	}
+}
+
+// NewResponse returns the envelope answering req with payload, or with
+// the error code for err if it is not nil.
+func NewResponse(req *PBEnvelope, payload []byte, err error) *PBEnvelope {
+	resp := &PBEnvelope{
+		Version:  proto.Uint32(Version),
+		Protocol: proto.String(req.GetProtocol()),
+		Id:       proto.Uint64(req.GetId()),
+		Response: proto.Bool(true),
+	}
+
+	if err != nil {
+		resp.Error = ErrorCode(err).Enum()
+	} else {
+		resp.Payload = payload
+	}
+	return resp
+}
+
+// ErrorCode returns the code sent for err.
+func ErrorCode(err error) PBEnvelope_ErrorCode {
+	switch e := err.(type) {
+	case nil:
+		return PBEnvelope_OK
+	case *Error:
+		return e.Code
+	}
+
+	if err == u.ErrNotFound {
+		return PBEnvelope_NOT_FOUND
+	}
+	return PBEnvelope_INTERNAL
+}
+
+// ResponseError returns the error a response reports, or nil.
+func ResponseError(resp *PBEnvelope) error {
+	switch resp.GetError() {
+	case PBEnvelope_OK:
+		return nil
+	case PBEnvelope_BAD_REQUEST:
+		return ErrBadRequest
+	case PBEnvelope_NOT_FOUND:
+		return ErrNotFound
+	case PBEnvelope_UNSUPPORTED:
+		return ErrUnsupported
+	case PBEnvelope_INTERNAL:
+		return ErrInternal
+	case PBEnvelope_BUSY:
+		return ErrBusy
+	}
+	return &Error{resp.GetError()}
+}
+
+// Marshal encodes an envelope for the wire.
+func Marshal(env *PBEnvelope) ([]byte, error) {
+	return proto.Marshal(env)
+}
+
+// Unmarshal decodes an envelope, checking its version.
+func Unmarshal(data []byte) (*PBEnvelope, error) {
+	env := new(PBEnvelope)
+	if err := proto.Unmarshal(data, env); err != nil {
+		return nil, err
+	}
+
+	if env.GetVersion() != Version {
+		return nil, ErrBadVersion
+	}
+	return env, nil
+}
@@ -0,0 +1,320 @@
+package msgproto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	peer "../peer"
+	swarm "../swarm"
+	u "../util"
+)
+
+// forward delivers the messages sent on from to to, as coming from src.
+func forward(from, to *swarm.Chan, src *peer.Peer) {
+	for msg := range from.Outgoing {
+		to.Incoming <- &swarm.Message{Peer: src, Data: msg.Data}
+	}
+}
+
+// newServicePair returns two services connected to each other, and the
+// peer of the second one.
+func newServicePair(ha, hb Handler) (*Service, *Service, *peer.Peer) {
+	pa := &peer.Peer{ID: peer.ID("peer a")}
+	pb := &peer.Peer{ID: peer.ID("peer b")}
+	ca, cb := swarm.NewChan(10), swarm.NewChan(10)
+
+	go forward(ca, cb, pa)
+	go forward(cb, ca, pb)
+
+	a := NewService("/test", ca, ha)
+	b := NewService("/test", cb, hb)
+	a.Start()
+	b.Start()
+	return a, b, pb
+}
+
+var echo = HandlerFunc(func(p *peer.Peer, payload []byte) ([]byte, error) {
+	return payload, nil
+})
+
+func TestRequestResponse(t *testing.T) {
+	a, b, pb := newServicePair(echo, echo)
+	defer a.Halt()
+	defer b.Halt()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, msg := range []string{"beep", "boop", ""} {
+		resp, err := a.SendRequest(ctx, pb, []byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp) != msg {
+			t.Errorf("expected %q, got %q", msg, resp)
+		}
+	}
+
+	if a.Pending() != 0 {
+		t.Error("answered requests were not forgotten")
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	slow := HandlerFunc(func(p *peer.Peer, payload []byte) ([]byte, error) {
+		if string(payload) == "slow" {
+			time.Sleep(time.Millisecond * 100)
+		}
+		return payload, nil
+	})
+
+	a, b, pb := newServicePair(echo, slow)
+	defer a.Halt()
+	defer b.Halt()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan []byte)
+	go func() {
+		resp, _ := a.SendRequest(ctx, pb, []byte("slow"))
+		done <- resp
+	}()
+
+	resp, err := a.SendRequest(ctx, pb, []byte("fast"))
+	if err != nil || string(resp) != "fast" {
+		t.Fatal("fast request got", string(resp), err)
+	}
+
+	select {
+	case resp := <-done:
+		if string(resp) != "slow" {
+			t.Fatal("slow request got", string(resp))
+		}
+		t.Error("fast request waited for the slow one")
+	default:
+	}
+
+	if resp := <-done; string(resp) != "slow" {
+		t.Error("slow request got", string(resp))
+	}
+}
+
+func TestRemoteErrors(t *testing.T) {
+	failing := HandlerFunc(func(p *peer.Peer, payload []byte) ([]byte, error) {
+		switch string(payload) {
+		case "bad":
+			return nil, ErrBadRequest
+		case "missing":
+			return nil, u.ErrNotFound
+		}
+		return nil, errors.New("local details stay local")
+	})
+
+	a, b, pb := newServicePair(echo, failing)
+	defer a.Halt()
+	defer b.Halt()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cases := map[string]error{
+		"bad":     ErrBadRequest,
+		"missing": ErrNotFound,
+		"other":   ErrInternal,
+	}
+
+	for req, expected := range cases {
+		_, err := a.SendRequest(ctx, pb, []byte(req))
+		if err != expected {
+			t.Errorf("%s: expected %v, got %v", req, expected, err)
+		}
+	}
+}
+
+func TestOneWayMessage(t *testing.T) {
+	got := make(chan string, 1)
+	sink := HandlerFunc(func(p *peer.Peer, payload []byte) ([]byte, error) {
+		got <- string(payload)
+		return []byte("ignored"), nil
+	})
+
+	a, b, pb := newServicePair(echo, sink)
+	defer a.Halt()
+	defer b.Halt()
+
+	if err := a.SendMessage(context.Background(), pb, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-got:
+		if msg != "hello" {
+			t.Error("unexpected message", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message did not arrive")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	block := make(chan struct{})
+	stuck := HandlerFunc(func(p *peer.Peer, payload []byte) ([]byte, error) {
+		<-block
+		return payload, nil
+	})
+
+	a, b, pb := newServicePair(echo, stuck)
+	defer a.Halt()
+	defer b.Halt()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	_, err := a.SendRequest(ctx, pb, []byte("hello"))
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected timeout, got", err)
+	}
+
+	// the late response finds nobody waiting.
+	close(block)
+	time.Sleep(time.Millisecond * 20)
+	if a.Pending() != 0 {
+		t.Error("timed out request was not forgotten")
+	}
+}
+
+func TestEnvelopeVersion(t *testing.T) {
+	env := NewRequest("/test", 1, []byte("hello"))
+	data, err := Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.GetId() != 1 || string(out.GetPayload()) != "hello" {
+		t.Error("envelope did not round trip")
+	}
+
+	env.Version = new(uint32)
+	data, _ = Marshal(env)
+	if _, err := Unmarshal(data); err != ErrBadVersion {
+		t.Error("expected bad version error, got", err)
+	}
+}
+
+func TestRequestCancel(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	stuck := HandlerFunc(func(p *peer.Peer, payload []byte) ([]byte, error) {
+		<-block
+		return payload, nil
+	})
+
+	a, b, pb := newServicePair(echo, stuck)
+	defer a.Halt()
+	defer b.Halt()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		cancel()
+	}()
+
+	_, err := a.SendRequest(ctx, pb, []byte("hello"))
+	if err != context.Canceled {
+		t.Fatal("expected cancel, got", err)
+	}
+
+	if a.Pending() != 0 {
+		t.Error("canceled request was not forgotten")
+	}
+}
+
+func TestBusy(t *testing.T) {
+	old := MaxConcurrentRequests
+	MaxConcurrentRequests = 1
+	defer func() { MaxConcurrentRequests = old }()
+
+	block := make(chan struct{})
+	stuck := HandlerFunc(func(p *peer.Peer, payload []byte) ([]byte, error) {
+		<-block
+		return payload, nil
+	})
+
+	a, b, pb := newServicePair(echo, stuck)
+	defer a.Halt()
+	defer b.Halt()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		_, err := a.SendRequest(ctx, pb, []byte("first"))
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	if _, err := a.SendRequest(ctx, pb, []byte("second")); err != ErrBusy {
+		t.Error("expected busy, got", err)
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Error("first request failed", err)
+	}
+
+	// the worker is free again.
+	if _, err := a.SendRequest(ctx, pb, []byte("third")); err != nil {
+		t.Error("request after the busy one failed", err)
+	}
+}
+
+func TestBusyMessageWaits(t *testing.T) {
+	old := MaxConcurrentRequests
+	MaxConcurrentRequests = 1
+	defer func() { MaxConcurrentRequests = old }()
+
+	block := make(chan struct{})
+	got := make(chan string, 2)
+	stuck := HandlerFunc(func(p *peer.Peer, payload []byte) ([]byte, error) {
+		if string(payload) == "first" {
+			<-block
+		}
+		got <- string(payload)
+		return nil, nil
+	})
+
+	a, b, pb := newServicePair(echo, stuck)
+	defer a.Halt()
+	defer b.Halt()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := a.SendMessage(ctx, pb, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	// sent while the only worker is busy, the message waits for it.
+	if err := a.SendMessage(ctx, pb, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 20)
+	close(block)
+
+	for _, want := range []string{"first", "second"} {
+		select {
+		case m := <-got:
+			if m != want {
+				t.Errorf("expected %s, got %s", want, m)
+			}
+		case <-ctx.Done():
+			t.Fatal("message was dropped while busy")
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package msgproto
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	peer "../peer"
+	swarm "../swarm"
+	u "../util"
+)
+
+// Handler answers the requests of a protocol. The response, or the error
+// code for the error, is sent back if the request expects a response.
+type Handler interface {
+	HandleMessage(p *peer.Peer, payload []byte) ([]byte, error)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(p *peer.Peer, payload []byte) ([]byte, error)
+
+// HandleMessage calls f.
+func (f HandlerFunc) HandleMessage(p *peer.Peer, payload []byte) ([]byte, error) {
+	return f(p, payload)
+}
+
+// MaxConcurrentRequests is the number of incoming requests a Service
+// handles at once. Requests arriving while all are being handled are
+// refused with ErrBusy. Messages expecting no response cannot be refused,
+// as their sender would never know; up to MaxConcurrentRequests of them
+// are queued for a free worker instead.
+var MaxConcurrentRequests = 64
+
+// requestKey identifies a request awaiting its response: IDs are only
+// checked against the peer the request went to.
+type requestKey struct {
+	peer u.Key
+	id   uint64
+}
+
+// queuedMessage is a message expecting no response, waiting for a worker.
+type queuedMessage struct {
+	peer *peer.Peer
+	env  *PBEnvelope
+}
+
+// Service speaks one protocol over a swarm Chan. It passes incoming
+// requests to its Handler, and delivers responses to the requests waiting
+// for them.
+type Service struct {
+	pid     ProtocolID
+	ch      *swarm.Chan
+	handler Handler
+
+	// nextID is the last request ID used.
+	nextID uint64
+
+	pending     map[requestKey]chan *PBEnvelope
+	pendingLock sync.Mutex
+
+	// workers holds a token for every request being handled.
+	workers chan struct{}
+
+	// messages expecting no response, waiting for a worker.
+	queue chan queuedMessage
+
+	halt chan struct{}
+}
+
+// NewService constructs a Service for protocol pid, exchanging messages
+// over ch, and answering requests with h.
+func NewService(pid ProtocolID, ch *swarm.Chan, h Handler) *Service {
+	return &Service{
+		pid:     pid,
+		ch:      ch,
+		handler: h,
+		nextID:  uint64(rand.Uint32()),
+		pending: map[requestKey]chan *PBEnvelope{},
+		workers: make(chan struct{}, MaxConcurrentRequests),
+		queue:   make(chan queuedMessage, MaxConcurrentRequests),
+		halt:    make(chan struct{}),
+	}
+}
+
+// Start up background goroutines needed by the service
+func (s *Service) Start() {
+	go s.handleMessages()
+	go s.handleQueue()
+}
+
+// Halt stops the message handling routine.
+func (s *Service) Halt() {
+	close(s.halt)
+}
+
+// SendMessage sends payload to p, expecting no response.
+func (s *Service) SendMessage(ctx context.Context, p *peer.Peer, payload []byte) error {
+	return s.send(ctx, p, NewRequest(s.pid, 0, payload))
+}
+
+// SendRequest sends payload to p, and waits for the response, until ctx
+// is done. Failures reported by p are returned as *Error.
+func (s *Service) SendRequest(ctx context.Context, p *peer.Peer, payload []byte) ([]byte, error) {
+	id := s.newID()
+	key := requestKey{p.Key(), id}
+	resp := make(chan *PBEnvelope, 1)
+
+	s.pendingLock.Lock()
+	s.pending[key] = resp
+	s.pendingLock.Unlock()
+
+	defer func() {
+		s.pendingLock.Lock()
+		delete(s.pending, key)
+		s.pendingLock.Unlock()
+	}()
+
+	if err := s.send(ctx, p, NewRequest(s.pid, id, payload)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case env := <-resp:
+		if err := ResponseError(env); err != nil {
+			return nil, err
+		}
+		return env.GetPayload(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.halt:
+		return nil, u.ErrTimeout
+	}
+}
+
+// Pending returns the number of requests waiting for their response.
+func (s *Service) Pending() int {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	return len(s.pending)
+}
+
+// newID returns a request ID, never 0.
+func (s *Service) newID() uint64 {
+	for {
+		if id := atomic.AddUint64(&s.nextID, 1); id != 0 {
+			return id
+		}
+	}
+}
+
+func (s *Service) send(ctx context.Context, p *peer.Peer, env *PBEnvelope) error {
+	data, err := Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.ch.Outgoing <- &swarm.Message{Peer: p, Data: data}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.halt:
+		return u.ErrTimeout
+	}
+}
+
+// Read in all messages from the Chan and handle them appropriately
+func (s *Service) handleMessages() {
+	for {
+		select {
+		case mes, ok := <-s.ch.Incoming:
+			if !ok {
+				return
+			}
+
+			env, err := Unmarshal(mes.Data)
+			if err != nil {
+				u.PErr("msgproto: bad envelope from %s: %v\n", mes.Peer.Key().Pretty(), err)
+				continue
+			}
+
+			if ProtocolID(env.GetProtocol()) != s.pid {
+				u.DOut("msgproto: dropping %s message on %s\n", env.GetProtocol(), s.pid)
+				continue
+			}
+
+			if env.GetResponse() {
+				s.handleResponse(mes.Peer, env)
+				continue
+			}
+
+			// handlers may wait on other peers; don't hold up the rest,
+			// but don't take on more than we can handle either.
+			if env.GetId() == 0 {
+				select {
+				case s.queue <- queuedMessage{mes.Peer, env}:
+				case <-s.halt:
+					return
+				}
+				continue
+			}
+
+			select {
+			case s.workers <- struct{}{}:
+				go s.work(mes.Peer, env)
+			default:
+				s.refuse(mes.Peer, env)
+			}
+
+		case <-s.halt:
+			return
+		}
+	}
+}
+
+// handleQueue hands the queued messages to workers, as they free up.
+func (s *Service) handleQueue() {
+	for {
+		select {
+		case m := <-s.queue:
+			select {
+			case s.workers <- struct{}{}:
+				go s.work(m.peer, m.env)
+			case <-s.halt:
+				return
+			}
+		case <-s.halt:
+			return
+		}
+	}
+}
+
+// work handles a request, and frees its worker.
+func (s *Service) work(p *peer.Peer, env *PBEnvelope) {
+	s.handleRequest(p, env)
+	<-s.workers
+}
+
+func (s *Service) handleResponse(p *peer.Peer, env *PBEnvelope) {
+	s.pendingLock.Lock()
+	resp, found := s.pending[requestKey{p.Key(), env.GetId()}]
+	delete(s.pending, requestKey{p.Key(), env.GetId()})
+	s.pendingLock.Unlock()
+
+	if !found {
+		u.DOut("msgproto: received response with nobody listening\n")
+		return
+	}
+	resp <- env
+}
+
+// refuse answers a request with ErrBusy, if that can be done without
+// waiting.
+func (s *Service) refuse(p *peer.Peer, env *PBEnvelope) {
+	u.DOut("msgproto: too busy for %s request from %s\n", s.pid, p.Key().Pretty())
+	data, err := Marshal(NewResponse(env, nil, ErrBusy))
+	if err != nil {
+		return
+	}
+
+	select {
+	case s.ch.Outgoing <- &swarm.Message{Peer: p, Data: data}:
+	default:
+	}
+}
+
+func (s *Service) handleRequest(p *peer.Peer, env *PBEnvelope) {
+	payload, err := s.handler.HandleMessage(p, env.GetPayload())
+	if env.GetId() == 0 {
+		if err != nil {
+			u.DOut("msgproto: %s message from %s failed: %v\n", s.pid, p.Key().Pretty(), err)
+		}
+		return
+	}
+
+	if err := s.send(context.Background(), p, NewResponse(env, payload, err)); err != nil {
+		u.PErr("msgproto: failed to respond to %s: %v\n", p.Key().Pretty(), err)
+	}
+}
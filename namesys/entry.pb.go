@@ -0,0 +1,104 @@
+// Code generated by protoc-gen-go.
+// source: entry.proto
+// DO NOT EDIT!
+
+package namesys
+
+import proto "github.com/golang/protobuf/proto"
+import json "encoding/json"
+import math "math"
+
+// Reference proto, json, and math imports to suppress error if they are not otherwise used.
+var _ = proto.Marshal
+var _ = &json.SyntaxError{}
+var _ = math.Inf
+
+type PBIpnsEntry_ValidityType int32
+
+const (
+	PBIpnsEntry_EOL PBIpnsEntry_ValidityType = 0
+)
+
+var PBIpnsEntry_ValidityType_name = map[int32]string{
+	0: "EOL",
+}
+var PBIpnsEntry_ValidityType_value = map[string]int32{
+	"EOL": 0,
+}
+
+func (x PBIpnsEntry_ValidityType) Enum() *PBIpnsEntry_ValidityType {
+	p := new(PBIpnsEntry_ValidityType)
+	*p = x
+	return p
+}
+func (x PBIpnsEntry_ValidityType) String() string {
+	return proto.EnumName(PBIpnsEntry_ValidityType_name, int32(x))
+}
+func (x *PBIpnsEntry_ValidityType) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(PBIpnsEntry_ValidityType_value, data, "PBIpnsEntry_ValidityType")
+	if err != nil {
+		return err
+	}
+	*x = PBIpnsEntry_ValidityType(value)
+	return nil
+}
+
+type PBIpnsEntry struct {
+	Value            []byte                    `protobuf:"bytes,1,req" json:"Value,omitempty"`
+	Signature        []byte                    `protobuf:"bytes,2,req" json:"Signature,omitempty"`
+	ValidityType     *PBIpnsEntry_ValidityType `protobuf:"varint,3,opt,name=validityType,enum=namesys.PBIpnsEntry_ValidityType" json:"validityType,omitempty"`
+	Validity         []byte                    `protobuf:"bytes,4,opt,name=validity" json:"validity,omitempty"`
+	Sequence         *uint64                   `protobuf:"varint,5,opt,name=sequence" json:"sequence,omitempty"`
+	PubKey           []byte                    `protobuf:"bytes,6,opt,name=pubKey" json:"pubKey,omitempty"`
+	XXX_unrecognized []byte                    `json:"-"`
+}
+
+func (m *PBIpnsEntry) Reset()         { *m = PBIpnsEntry{} }
+func (m *PBIpnsEntry) String() string { return proto.CompactTextString(m) }
+func (*PBIpnsEntry) ProtoMessage()    {}
+
+func (m *PBIpnsEntry) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *PBIpnsEntry) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *PBIpnsEntry) GetValidityType() PBIpnsEntry_ValidityType {
+	if m != nil && m.ValidityType != nil {
+		return *m.ValidityType
+	}
+	return PBIpnsEntry_EOL
+}
+
+func (m *PBIpnsEntry) GetValidity() []byte {
+	if m != nil {
+		return m.Validity
+	}
+	return nil
+}
+
+func (m *PBIpnsEntry) GetSequence() uint64 {
+	if m != nil && m.Sequence != nil {
+		return *m.Sequence
+	}
+	return 0
+}
+
+func (m *PBIpnsEntry) GetPubKey() []byte {
+	if m != nil {
+		return m.PubKey
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("namesys.PBIpnsEntry_ValidityType", PBIpnsEntry_ValidityType_name, PBIpnsEntry_ValidityType_value)
+}
@@ -0,0 +1,86 @@
+// Package namesys implements mutable names (ipns) on top of the routing
+// system.
+//
+// A name is the hash of a public key. Publishing a name puts a record
+// signed by the matching private key into the routing system, pointing the
+// name at an /ipfs/<hash> path, or at another name. Records carry a
+// sequence number, so newer records win, and an end of life, after which
+// they are no longer valid.
+package namesys
+
+import (
+	"errors"
+	"time"
+
+	ci "../crypto"
+	routing "../routing"
+)
+
+// ErrResolveFailed signals a name that could not be resolved.
+var ErrResolveFailed = errors.New("could not resolve name")
+
+// ErrBadSignature signals a record that was not signed by the name's key.
+var ErrBadSignature = errors.New("record signature does not verify")
+
+// ErrExpiredRecord signals a record past its end of life.
+var ErrExpiredRecord = errors.New("record has expired")
+
+// ErrStaleRecord signals a record older than one already seen for the
+// same name.
+var ErrStaleRecord = errors.New("record is older than one already seen")
+
+// ErrInvalidPath signals a value that is not an /ipfs/ or /ipns/ path.
+var ErrInvalidPath = errors.New("value is not an /ipfs/ or /ipns/ path")
+
+// DefaultRecordTTL is how long published records stay valid.
+var DefaultRecordTTL = time.Hour * 24
+
+// ResolveTimeout is how long lookups in the routing system may take.
+var ResolveTimeout = time.Second * 30
+
+// PublishTimeout is how long publishing a record in the routing system may
+// take.
+var PublishTimeout = time.Minute
+
+// Resolver resolves names to paths.
+type Resolver interface {
+	// Resolve returns the path the name points to. name is the base58
+	// hash of the publisher's public key.
+	Resolve(name string) (string, error)
+}
+
+// Publisher publishes names.
+type Publisher interface {
+	// Publish points the name of k's public key at value, which must be
+	// an /ipfs/<hash> or /ipns/<name> path.
+	Publish(k ci.PrivKey, value string) error
+}
+
+// NameSystem resolves and publishes names.
+type NameSystem interface {
+	Resolver
+	Publisher
+}
+
+type nameSystem struct {
+	*routingResolver
+	*routingPublisher
+}
+
+// NewNameSystem returns a NameSystem storing records in r.
+func NewNameSystem(r routing.IpfsRouting) NameSystem {
+	return &nameSystem{
+		routingResolver:  newRoutingResolver(r),
+		routingPublisher: newRoutingPublisher(r),
+	}
+}
+
+// ipnsKey returns the routing key a name's record is kept under.
+func ipnsKey(hash []byte) string {
+	return "/ipns/" + string(hash)
+}
+
+// pkKey returns the routing key a name's public key is kept under.
+func pkKey(hash []byte) string {
+	return "/pk/" + string(hash)
+}
@@ -0,0 +1,226 @@
+package namesys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+	b58 "github.com/jbenet/go-base58"
+
+	ci "../crypto"
+	peer "../peer"
+	u "../util"
+)
+
+// mockRouting keeps values in a map, standing in for the dht.
+type mockRouting map[u.Key][]byte
+
+func (m mockRouting) PutValue(ctx context.Context, key u.Key, value []byte) error {
+	m[key] = value
+	return nil
+}
+
+func (m mockRouting) GetValue(ctx context.Context, key u.Key) ([]byte, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, u.ErrNotFound
+	}
+	return v, nil
+}
+
+func (m mockRouting) Provide(ctx context.Context, key u.Key) error {
+	return u.ErrNotImplemented
+}
+
+func (m mockRouting) FindProviders(ctx context.Context, key u.Key) ([]*peer.Peer, error) {
+	return nil, u.ErrNotImplemented
+}
+
+func (m mockRouting) FindPeer(ctx context.Context, id peer.ID) (*peer.Peer, error) {
+	return nil, u.ErrNotImplemented
+}
+
+const (
+	path1 = "/ipfs/QmTkzDwWqPbnAh5YiV5VwcTLnGdwSNsNTn2aDxdXBFca7D"
+	path2 = "/ipfs/QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG/readme"
+)
+
+func setup(t *testing.T) (mockRouting, NameSystem, ci.PrivKey, string) {
+	r := mockRouting{}
+	ns := NewNameSystem(r)
+
+	priv, pub, err := ci.GenerateKeyPair(ci.KeyType_RSA, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := pub.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, ns, priv, b58.Encode(hash)
+}
+
+func TestPublishResolve(t *testing.T) {
+	_, ns, priv, name := setup(t)
+
+	if err := ns.Publish(priv, path1); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ns.Resolve(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != path1 {
+		t.Errorf("resolved to %s, expected %s", res, path1)
+	}
+
+	if err := ns.Publish(priv, path2); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = ns.Resolve(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != path2 {
+		t.Errorf("resolved to %s after republishing, expected %s", res, path2)
+	}
+
+	if err := ns.Publish(priv, "not a path"); err != ErrInvalidPath {
+		t.Error("published an invalid path, got", err)
+	}
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	r, ns, priv, name := setup(t)
+	hash := b58.Decode(name)
+
+	if err := ns.Publish(priv, path1); err != nil {
+		t.Fatal(err)
+	}
+	old := r[u.Key(ipnsKey(hash))]
+
+	// a fresh publisher continues from the sequence in the routing system.
+	if err := newRoutingPublisher(r).Publish(priv, path2); err != nil {
+		t.Fatal(err)
+	}
+
+	e := new(PBIpnsEntry)
+	if err := proto.Unmarshal(r[u.Key(ipnsKey(hash))], e); err != nil {
+		t.Fatal(err)
+	}
+	if e.GetSequence() != 2 {
+		t.Error("expected sequence 2, got", e.GetSequence())
+	}
+
+	if _, err := ns.Resolve(name); err != nil {
+		t.Fatal(err)
+	}
+
+	// replaying the old record is refused.
+	r[u.Key(ipnsKey(hash))] = old
+	if _, err := ns.Resolve(name); err != ErrStaleRecord {
+		t.Error("expected ErrStaleRecord, got", err)
+	}
+}
+
+func TestInvalidRecords(t *testing.T) {
+	r, ns, priv, name := setup(t)
+	hash := b58.Decode(name)
+
+	expired, err := createEntry(priv, path1, 1, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ns.Publish(priv, path1); err != nil {
+		t.Fatal(err)
+	}
+
+	r[u.Key(ipnsKey(hash))] = expired
+	if _, err := ns.Resolve(name); err != ErrExpiredRecord {
+		t.Error("expected ErrExpiredRecord, got", err)
+	}
+
+	// a record signed by another key.
+	other, _, err := ci.GenerateKeyPair(ci.KeyType_RSA, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged, err := createEntry(other, path2, 10, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r[u.Key(ipnsKey(hash))] = forged
+	if _, err := ns.Resolve(name); err != ErrBadSignature {
+		t.Error("expected ErrBadSignature, got", err)
+	}
+
+	if _, err := ns.Resolve(b58.Encode([]byte("unknown"))); err != ErrResolveFailed {
+		t.Error("expected ErrResolveFailed, got", err)
+	}
+}
+
+func TestIpnsValidator(t *testing.T) {
+	_, _, priv, name := setup(t)
+	key := u.Key(ipnsKey(b58.Decode(name)))
+
+	eol := time.Now().Add(time.Hour)
+	rec1, err := createEntry(priv, path1, 1, eol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec2, err := createEntry(priv, path2, 2, eol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec2later, err := createEntry(priv, path2, 2, eol.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range [][]byte{rec1, rec2, rec2later} {
+		if err := IpnsValidator.Validate(key, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	best, err := IpnsValidator.Select(key, [][]byte{rec1, rec2later, rec2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best != 1 {
+		t.Error("expected the newest, longest valid record, got", best)
+	}
+
+	other, _, err := ci.GenerateKeyPair(ci.KeyType_RSA, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := createEntry(other, path2, 10, eol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := IpnsValidator.Validate(key, forged); err != ErrBadSignature {
+		t.Error("expected ErrBadSignature, got", err)
+	}
+
+	// a record without its key cannot be checked.
+	e := new(PBIpnsEntry)
+	if err := proto.Unmarshal(rec1, e); err != nil {
+		t.Fatal(err)
+	}
+	e.PubKey = nil
+	nokey, err := proto.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := IpnsValidator.Validate(key, nokey); err != ErrNoPublicKey {
+		t.Error("expected ErrNoPublicKey, got", err)
+	}
+}
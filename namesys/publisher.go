@@ -0,0 +1,141 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+	mh "github.com/multiformats/go-multihash"
+
+	ci "../crypto"
+	routing "../routing"
+	u "../util"
+)
+
+// routingPublisher publishes records into the routing system.
+type routingPublisher struct {
+	routing routing.IpfsRouting
+
+	// last sequence number published per name, in case the routing
+	// system lags behind.
+	seqs    map[u.Key]uint64
+	seqLock sync.Mutex
+}
+
+func newRoutingPublisher(r routing.IpfsRouting) *routingPublisher {
+	return &routingPublisher{routing: r, seqs: map[u.Key]uint64{}}
+}
+
+// Publish implements Publisher.
+func (p *routingPublisher) Publish(k ci.PrivKey, value string) error {
+	if err := checkPath(value); err != nil {
+		return err
+	}
+
+	pubkey := k.GetPublic()
+	pkbytes, err := pubkey.Bytes()
+	if err != nil {
+		return err
+	}
+
+	hash, err := pubkey.Hash()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PublishTimeout)
+	defer cancel()
+
+	seq := p.nextSequence(ctx, hash)
+	data, err := createEntry(k, value, seq, time.Now().Add(DefaultRecordTTL))
+	if err != nil {
+		return err
+	}
+
+	// the key goes first, so resolvers can check the record right away.
+	if err := p.routing.PutValue(ctx, u.Key(pkKey(hash)), pkbytes); err != nil {
+		return err
+	}
+
+	return p.routing.PutValue(ctx, u.Key(ipnsKey(hash)), data)
+}
+
+// nextSequence returns the sequence number for the next record of the
+// name, newer than both what we published, and what the routing system
+// holds.
+func (p *routingPublisher) nextSequence(ctx context.Context, hash []byte) uint64 {
+	p.seqLock.Lock()
+	defer p.seqLock.Unlock()
+
+	seq := p.seqs[u.Key(hash)]
+	if val, err := p.routing.GetValue(ctx, u.Key(ipnsKey(hash))); err == nil {
+		e := new(PBIpnsEntry)
+		if err := proto.Unmarshal(val, e); err == nil && e.GetSequence() > seq {
+			seq = e.GetSequence()
+		}
+	}
+
+	seq++
+	p.seqs[u.Key(hash)] = seq
+	return seq
+}
+
+// createEntry returns a serialized record pointing at value, signed by k.
+// The record carries the public key of k.
+func createEntry(k ci.PrivKey, value string, seq uint64, eol time.Time) ([]byte, error) {
+	pkbytes, err := k.GetPublic().Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &PBIpnsEntry{
+		Value:        []byte(value),
+		ValidityType: PBIpnsEntry_EOL.Enum(),
+		Validity:     []byte(eol.UTC().Format(time.RFC3339Nano)),
+		Sequence:     proto.Uint64(seq),
+		PubKey:       pkbytes,
+	}
+
+	sig, err := k.Sign(entryDataForSig(e))
+	if err != nil {
+		return nil, err
+	}
+	e.Signature = sig
+	return proto.Marshal(e)
+}
+
+// entryDataForSig returns the bytes of e covered by its signature.
+func entryDataForSig(e *PBIpnsEntry) []byte {
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, e.GetSequence())
+
+	return bytes.Join([][]byte{
+		e.GetValue(),
+		e.GetValidity(),
+		[]byte(e.GetValidityType().String()),
+		seq,
+	}, nil)
+}
+
+// checkPath checks that value is an /ipfs/<hash> or /ipns/<name> path.
+func checkPath(value string) error {
+	var rest string
+	switch {
+	case strings.HasPrefix(value, "/ipfs/"):
+		rest = value[len("/ipfs/"):]
+	case strings.HasPrefix(value, "/ipns/"):
+		rest = value[len("/ipns/"):]
+	default:
+		return ErrInvalidPath
+	}
+
+	parts := strings.SplitN(rest, "/", 2)
+	if _, err := mh.FromB58String(parts[0]); err != nil {
+		return ErrInvalidPath
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package namesys
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+	b58 "github.com/jbenet/go-base58"
+
+	ci "../crypto"
+	routing "../routing"
+	u "../util"
+)
+
+// routingResolver resolves names from records in the routing system.
+type routingResolver struct {
+	routing routing.IpfsRouting
+
+	// highest sequence number seen per name, so that old records cannot
+	// be replayed.
+	seen     map[u.Key]uint64
+	seenLock sync.Mutex
+}
+
+func newRoutingResolver(r routing.IpfsRouting) *routingResolver {
+	return &routingResolver{routing: r, seen: map[u.Key]uint64{}}
+}
+
+// Resolve implements Resolver.
+func (r *routingResolver) Resolve(name string) (string, error) {
+	hash := b58.Decode(name)
+	if len(hash) == 0 {
+		return "", ErrResolveFailed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ResolveTimeout)
+	defer cancel()
+
+	val, err := r.routing.GetValue(ctx, u.Key(ipnsKey(hash)))
+	if err != nil {
+		u.DOut("namesys: no record for %s: %v\n", name, err)
+		return "", ErrResolveFailed
+	}
+
+	e := new(PBIpnsEntry)
+	if err := proto.Unmarshal(val, e); err != nil {
+		return "", err
+	}
+
+	// older records do not carry the key.
+	pkval := e.GetPubKey()
+	if pkval == nil {
+		pkval, err = r.routing.GetValue(ctx, u.Key(pkKey(hash)))
+		if err != nil {
+			u.DOut("namesys: no public key for %s: %v\n", name, err)
+			return "", ErrResolveFailed
+		}
+	}
+
+	pubkey, err := ci.UnmarshalPublicKey(pkval)
+	if err != nil {
+		return "", err
+	}
+
+	if err := validateEntry(pubkey, hash, e, time.Now()); err != nil {
+		return "", err
+	}
+
+	if err := r.checkSequence(hash, e.GetSequence()); err != nil {
+		return "", err
+	}
+
+	return string(e.GetValue()), nil
+}
+
+// checkSequence rejects sequence numbers lower than already seen for the
+// name, and records the new ones.
+func (r *routingResolver) checkSequence(hash []byte, seq uint64) error {
+	r.seenLock.Lock()
+	defer r.seenLock.Unlock()
+
+	if seq < r.seen[u.Key(hash)] {
+		return ErrStaleRecord
+	}
+	r.seen[u.Key(hash)] = seq
+	return nil
+}
+
+// validateEntry checks that e was signed by pubkey, that pubkey hashes to
+// the name, and that e is still valid at now.
+func validateEntry(pubkey ci.PubKey, hash []byte, e *PBIpnsEntry, now time.Time) error {
+	pkhash, err := pubkey.Hash()
+	if err != nil {
+		return err
+	}
+
+	if string(pkhash) != string(hash) {
+		return ErrBadSignature
+	}
+
+	ok, err := pubkey.Verify(entryDataForSig(e), e.GetSignature())
+	if err != nil || !ok {
+		return ErrBadSignature
+	}
+
+	if err := checkPath(string(e.GetValue())); err != nil {
+		return err
+	}
+
+	switch e.GetValidityType() {
+	case PBIpnsEntry_EOL:
+		eol, err := time.Parse(time.RFC3339Nano, string(e.GetValidity()))
+		if err != nil {
+			return err
+		}
+
+		if now.After(eol) {
+			return ErrExpiredRecord
+		}
+	default:
+		return ErrExpiredRecord
+	}
+	return nil
+}
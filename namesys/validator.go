@@ -0,0 +1,71 @@
+package namesys
+
+import (
+	"errors"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+
+	ci "../crypto"
+	routing "../routing"
+	u "../util"
+)
+
+// ErrNoPublicKey signals a record that does not carry its public key, so
+// it cannot be checked on its own.
+var ErrNoPublicKey = errors.New("record does not carry its public key")
+
+// IpnsValidator checks the records stored under /ipns/<hash>. A record must
+// be signed by the key it carries, which must hash to the name, and must not
+// have expired. The record with the highest sequence number is the best;
+// among equal ones, the one valid the longest.
+var IpnsValidator = &routing.Validator{
+	Validate: validateIpnsRecord,
+	Select:   selectIpnsRecord,
+}
+
+func validateIpnsRecord(key u.Key, value []byte) error {
+	hash := []byte(key)[len("/ipns/"):]
+
+	e := new(PBIpnsEntry)
+	if err := proto.Unmarshal(value, e); err != nil {
+		return routing.ErrInvalidRecord
+	}
+
+	if e.GetPubKey() == nil {
+		return ErrNoPublicKey
+	}
+
+	pubkey, err := ci.UnmarshalPublicKey(e.GetPubKey())
+	if err != nil {
+		return routing.ErrInvalidRecord
+	}
+	return validateEntry(pubkey, hash, e, time.Now())
+}
+
+func selectIpnsRecord(key u.Key, values [][]byte) (int, error) {
+	best := -1
+	var bestSeq uint64
+	var bestEOL time.Time
+	for i, val := range values {
+		e := new(PBIpnsEntry)
+		if err := proto.Unmarshal(val, e); err != nil {
+			continue
+		}
+
+		eol, err := time.Parse(time.RFC3339Nano, string(e.GetValidity()))
+		if err != nil {
+			continue
+		}
+
+		seq := e.GetSequence()
+		if best < 0 || seq > bestSeq || (seq == bestSeq && eol.After(bestEOL)) {
+			best, bestSeq, bestEOL = i, seq, eol
+		}
+	}
+
+	if best < 0 {
+		return 0, routing.ErrInvalidRecord
+	}
+	return best, nil
+}
@@ -0,0 +1,116 @@
+// Code generated by protoc-gen-go.
+// source: frame.proto
+// DO NOT EDIT!
+
+package netmux
+
+import proto "github.com/golang/protobuf/proto"
+import json "encoding/json"
+import math "math"
+
+// Reference proto, json, and math imports to suppress error if they are not otherwise used.
+var _ = proto.Marshal
+var _ = &json.SyntaxError{}
+var _ = math.Inf
+
+type PBFrame_FrameType int32
+
+const (
+	PBFrame_OPEN   PBFrame_FrameType = 0
+	PBFrame_DATA   PBFrame_FrameType = 1
+	PBFrame_WINDOW PBFrame_FrameType = 2
+	PBFrame_CLOSE  PBFrame_FrameType = 3
+	PBFrame_RESET  PBFrame_FrameType = 4
+)
+
+var PBFrame_FrameType_name = map[int32]string{
+	0: "OPEN",
+	1: "DATA",
+	2: "WINDOW",
+	3: "CLOSE",
+	4: "RESET",
+}
+var PBFrame_FrameType_value = map[string]int32{
+	"OPEN":   0,
+	"DATA":   1,
+	"WINDOW": 2,
+	"CLOSE":  3,
+	"RESET":  4,
+}
+
+func (x PBFrame_FrameType) Enum() *PBFrame_FrameType {
+	p := new(PBFrame_FrameType)
+	*p = x
+	return p
+}
+func (x PBFrame_FrameType) String() string {
+	return proto.EnumName(PBFrame_FrameType_name, int32(x))
+}
+func (x *PBFrame_FrameType) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(PBFrame_FrameType_value, data, "PBFrame_FrameType")
+	if err != nil {
+		return err
+	}
+	*x = PBFrame_FrameType(value)
+	return nil
+}
+
+type PBFrame struct {
+	Type             *PBFrame_FrameType `protobuf:"varint,1,req,name=type,enum=netmux.PBFrame_FrameType" json:"type,omitempty"`
+	Stream           *uint64            `protobuf:"varint,2,req,name=stream" json:"stream,omitempty"`
+	Protocol         *string            `protobuf:"bytes,3,opt,name=protocol" json:"protocol,omitempty"`
+	Data             []byte             `protobuf:"bytes,4,opt,name=data" json:"data,omitempty"`
+	Fin              *bool              `protobuf:"varint,5,opt,name=fin" json:"fin,omitempty"`
+	Delta            *uint32            `protobuf:"varint,6,opt,name=delta" json:"delta,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
+}
+
+func (m *PBFrame) Reset()         { *m = PBFrame{} }
+func (m *PBFrame) String() string { return proto.CompactTextString(m) }
+func (*PBFrame) ProtoMessage()    {}
+
+func (m *PBFrame) GetType() PBFrame_FrameType {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return PBFrame_OPEN
+}
+
+func (m *PBFrame) GetStream() uint64 {
+	if m != nil && m.Stream != nil {
+		return *m.Stream
+	}
+	return 0
+}
+
+func (m *PBFrame) GetProtocol() string {
+	if m != nil && m.Protocol != nil {
+		return *m.Protocol
+	}
+	return ""
+}
+
+func (m *PBFrame) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *PBFrame) GetFin() bool {
+	if m != nil && m.Fin != nil {
+		return *m.Fin
+	}
+	return false
+}
+
+func (m *PBFrame) GetDelta() uint32 {
+	if m != nil && m.Delta != nil {
+		return *m.Delta
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("netmux.PBFrame_FrameType", PBFrame_FrameType_name, PBFrame_FrameType_value)
+}
@@ -1,2 +1,22 @@
 package netmux
 
+import (
+	peer "../peer"
+)
+
+// ProtocolID names the protocol spoken over a stream, e.g. "/ipfs/dht".
+type ProtocolID string
+
+// Muxer opens and accepts streams of messages to other peers. All streams
+// to a peer share its connection.
+type Muxer interface {
+	// NewStream opens a stream to p, speaking protocol pid.
+	NewStream(p *peer.Peer, pid ProtocolID) (*Stream, error)
+
+	// Listen returns the channel that streams opened by other peers,
+	// speaking protocol pid, are delivered on.
+	Listen(pid ProtocolID) <-chan *Stream
+
+	// Close resets all streams, and stops the muxer.
+	Close()
+}
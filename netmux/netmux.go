@@ -1 +1,397 @@
+// Package netmux multiplexes streams of messages over the swarm. Every
+// stream speaks one protocol, and has its own ID, flow control and close
+// semantics. Messages are split into frames, and the frames of all streams
+// to a peer take turns on the connection, so a large block transfer does
+// not hold up a DHT query behind it. A stream whose reader falls behind
+// only stalls its own writer.
 package netmux
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+
+	peer "../peer"
+	swarm "../swarm"
+	u "../util"
+)
+
+// MaxFrameData is the size of the largest message fragment sent in one
+// frame. Larger messages are split.
+var MaxFrameData = 2048
+
+// ListenBuffer is the number of incoming streams a Listen channel holds.
+// Streams arriving while it is full are reset.
+var ListenBuffer = 10
+
+// InitialWindow is the number of bytes a stream writer may send before the
+// reader reads them. It also bounds the size of a message.
+const InitialWindow = 1 << 20
+
+// WriteTimeout is how long WriteMsg waits for the remote to make room in
+// the window. A stream whose reader stops reading is reset after it.
+var WriteTimeout = time.Minute
+
+// ErrReset signals a stream aborted by either side.
+var ErrReset = errors.New("stream reset")
+
+// ErrClosed signals a write to a stream already closed for writing.
+var ErrClosed = errors.New("stream closed")
+
+// ErrMuxClosed signals a new stream on a closed Mux.
+var ErrMuxClosed = errors.New("netmux closed")
+
+// ErrWriteTimeout signals a write that did not fit in the window within
+// WriteTimeout. The stream is reset.
+var ErrWriteTimeout = errors.New("stream write timed out")
+
+// ErrMessageTooLarge signals a message larger than InitialWindow.
+var ErrMessageTooLarge = errors.New("message larger than the stream window")
+
+// streamKey identifies a stream: IDs are only unique per peer.
+type streamKey struct {
+	peer u.Key
+	id   uint64
+}
+
+// Mux is a Muxer sending frames through a swarm Chan, usually the one the
+// Swarm gives out for PBWrapper_NETMUX. It reads every message coming in
+// on the Chan, so it must be the only reader.
+type Mux struct {
+	local *peer.Peer
+	ch    *swarm.Chan
+
+	// lock guards the fields below, and the state of all streams.
+	lock sync.Mutex
+
+	// cond is broadcast on every change of state.
+	cond *sync.Cond
+
+	streams   map[streamKey]*Stream
+	nextID    map[u.Key]uint64
+	listeners map[ProtocolID]chan *Stream
+
+	// control holds frames sent ahead of stream data.
+	control []*swarm.Message
+
+	// sending holds the streams with frames queued, in turn order.
+	sending []*Stream
+
+	closed bool
+	halt   chan struct{}
+}
+
+// NewMux constructs a Mux for the local peer, sending and receiving
+// frames through ch.
+func NewMux(local *peer.Peer, ch *swarm.Chan) *Mux {
+	m := &Mux{
+		local:     local,
+		ch:        ch,
+		streams:   map[streamKey]*Stream{},
+		nextID:    map[u.Key]uint64{},
+		listeners: map[ProtocolID]chan *Stream{},
+		halt:      make(chan struct{}),
+	}
+	m.cond = sync.NewCond(&m.lock)
+
+	go m.handleIncoming()
+	go m.handleOutgoing()
+	return m
+}
+
+// NewStream opens a stream to p, speaking protocol pid. The remote learns
+// of it with the first frame; if nobody listens for pid there, the stream
+// is reset.
+func (m *Mux) NewStream(p *peer.Peer, pid ProtocolID) (*Stream, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return nil, ErrMuxClosed
+	}
+
+	s := newStream(m, p, m.newStreamID(p), pid)
+	m.streams[s.key()] = s
+
+	s.queue(&PBFrame{
+		Type:     PBFrame_OPEN.Enum(),
+		Stream:   proto.Uint64(s.id),
+		Protocol: proto.String(string(pid)),
+	}, 0)
+	return s, nil
+}
+
+// newStreamID returns the ID of the next stream we open to p.
+func (m *Mux) newStreamID(p *peer.Peer) uint64 {
+	id, found := m.nextID[p.Key()]
+	if !found {
+		// the two sides of a connection open streams with IDs of
+		// different parity, so they never collide.
+		id = 2
+		if bytes.Compare(m.local.ID, p.ID) < 0 {
+			id = 1
+		}
+	}
+	m.nextID[p.Key()] = id + 2
+	return id
+}
+
+// remoteStreamID returns whether id has the parity of the streams p opens,
+// the opposite of newStreamID's.
+func (m *Mux) remoteStreamID(p *peer.Peer, id uint64) bool {
+	remoteOdd := bytes.Compare(p.ID, m.local.ID) < 0
+	return (id%2 == 1) == remoteOdd
+}
+
+// Listen returns the channel that streams opened by other peers, speaking
+// protocol pid, are delivered on. It is closed when the Mux is.
+func (m *Mux) Listen(pid ProtocolID) <-chan *Stream {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	l, found := m.listeners[pid]
+	if !found {
+		l = make(chan *Stream, ListenBuffer)
+		m.listeners[pid] = l
+		if m.closed {
+			close(l)
+		}
+	}
+	return l
+}
+
+// Close resets all streams, and stops the Mux. The swarm is left open.
+func (m *Mux) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return
+	}
+
+	for _, s := range m.streams {
+		s.abort()
+	}
+
+	for _, l := range m.listeners {
+		close(l)
+	}
+
+	m.closed = true
+	close(m.halt)
+	m.cond.Broadcast()
+}
+
+// ResetPeer resets all streams to p, e.g. once its connection is gone.
+func (m *Mux) ResetPeer(p *peer.Peer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for k, s := range m.streams {
+		if k.peer == p.Key() {
+			s.abort()
+		}
+	}
+}
+
+// peers returns the peers with open streams.
+func (m *Mux) peers() []*peer.Peer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	seen := map[u.Key]bool{}
+	var out []*peer.Peer
+	for k, s := range m.streams {
+		if !seen[k.peer] {
+			seen[k.peer] = true
+			out = append(out, s.peer)
+		}
+	}
+	return out
+}
+
+func (m *Mux) handleIncoming() {
+	for {
+		select {
+		case msg, ok := <-m.ch.Incoming:
+			if !ok {
+				return
+			}
+
+			frame := new(PBFrame)
+			if err := proto.Unmarshal(msg.Data, frame); err != nil {
+				u.PErr("netmux: bad frame from %s: %v\n", msg.Peer.ID.Pretty(), err)
+				continue
+			}
+			m.handleFrame(msg.Peer, frame)
+
+		case <-m.halt:
+			return
+		}
+	}
+}
+
+func (m *Mux) handleFrame(p *peer.Peer, f *PBFrame) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return
+	}
+	defer m.cond.Broadcast()
+
+	if f.GetType() == PBFrame_OPEN {
+		m.accept(p, f)
+		return
+	}
+
+	s, found := m.streams[streamKey{p.Key(), f.GetStream()}]
+
+	if !found {
+		if f.GetType() != PBFrame_RESET {
+			m.sendReset(p, f.GetStream())
+		}
+		return
+	}
+
+	switch f.GetType() {
+	case PBFrame_DATA:
+		s.receive(f.GetData(), f.GetFin())
+	case PBFrame_WINDOW:
+		s.sendWindow += int(f.GetDelta())
+	case PBFrame_CLOSE:
+		s.remoteClosed = true
+		s.maybeRemove()
+	case PBFrame_RESET:
+		s.resetLocal()
+	}
+}
+
+// accept delivers a stream opened by p to its listener, or resets it if
+// there is none.
+func (m *Mux) accept(p *peer.Peer, f *PBFrame) {
+	// p may only open streams with its own parity: reusing one of our IDs
+	// would take over a stream we opened.
+	if !m.remoteStreamID(p, f.GetStream()) {
+		u.PErr("netmux: %s opened stream %d, which is ours to open\n", p.ID.Pretty(), f.GetStream())
+		return
+	}
+
+	if s, found := m.streams[streamKey{p.Key(), f.GetStream()}]; found {
+		u.PErr("netmux: %s reopened stream %d\n", p.ID.Pretty(), f.GetStream())
+		s.abort()
+		return
+	}
+
+	l, found := m.listeners[ProtocolID(f.GetProtocol())]
+	if !found {
+		u.DOut("netmux: no listener for %s\n", f.GetProtocol())
+		m.sendReset(p, f.GetStream())
+		return
+	}
+
+	s := newStream(m, p, f.GetStream(), ProtocolID(f.GetProtocol()))
+	select {
+	case l <- s:
+		m.streams[s.key()] = s
+	default:
+		u.PErr("netmux: dropping stream for %s, listener is full\n", f.GetProtocol())
+		m.sendReset(p, f.GetStream())
+	}
+}
+
+// sendReset queues a RESET of stream id to p.
+func (m *Mux) sendReset(p *peer.Peer, id uint64) {
+	m.sendControl(p, &PBFrame{
+		Type:   PBFrame_RESET.Enum(),
+		Stream: proto.Uint64(id),
+	})
+}
+
+// sendControl queues f to p, ahead of stream data.
+func (m *Mux) sendControl(p *peer.Peer, f *PBFrame) {
+	msg := swarm.NewMessage(p, f)
+	if msg == nil {
+		return
+	}
+	m.control = append(m.control, msg)
+	m.cond.Broadcast()
+}
+
+func (m *Mux) handleOutgoing() {
+	for {
+		m.lock.Lock()
+		msg := m.nextFrame()
+		for msg == nil && !m.closed {
+			m.cond.Wait()
+			msg = m.nextFrame()
+		}
+		closed := m.closed
+		m.lock.Unlock()
+
+		if msg == nil {
+			return
+		}
+
+		if closed {
+			// flush the resets of Close, if there is room.
+			select {
+			case m.ch.Outgoing <- msg:
+			default:
+			}
+			continue
+		}
+
+		select {
+		case m.ch.Outgoing <- msg:
+		case <-m.halt:
+		}
+	}
+}
+
+// nextFrame returns the next frame to send, or nil if there is none that
+// fits in the windows of the streams.
+func (m *Mux) nextFrame() *swarm.Message {
+	if len(m.control) > 0 {
+		msg := m.control[0]
+		m.control = m.control[1:]
+		return msg
+	}
+
+	for i := 0; i < len(m.sending); i++ {
+		s := m.sending[i]
+		if s.reset || len(s.sendQ) == 0 {
+			m.sending = append(m.sending[:i], m.sending[i+1:]...)
+			i--
+			continue
+		}
+
+		c := s.sendQ[0]
+		if c.size > s.sendWindow {
+			continue
+		}
+
+		s.sendQ = s.sendQ[1:]
+		s.sendWindow -= c.size
+		s.sent++
+
+		// move s to the back, so the streams take turns.
+		m.sending = append(m.sending[:i], m.sending[i+1:]...)
+		if len(s.sendQ) > 0 {
+			m.sending = append(m.sending, s)
+		}
+
+		if c.frame.GetType() == PBFrame_CLOSE {
+			s.maybeRemove()
+		}
+
+		m.cond.Broadcast()
+		return swarm.NewMessage(s.peer, c.frame)
+	}
+	return nil
+}
+
+var _ Muxer = &Mux{}
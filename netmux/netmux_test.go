@@ -0,0 +1,304 @@
+package netmux
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+
+	peer "../peer"
+	swarm "../swarm"
+)
+
+// forward delivers the messages sent on from to to, as coming from src.
+func forward(from, to *swarm.Chan, src *peer.Peer) {
+	for msg := range from.Outgoing {
+		to.Incoming <- &swarm.Message{Peer: src, Data: msg.Data}
+	}
+}
+
+// newMuxPair returns two muxes connected to each other, and their peers.
+func newMuxPair() (*Mux, *Mux, *peer.Peer, *peer.Peer) {
+	pa := &peer.Peer{ID: peer.ID("peer a")}
+	pb := &peer.Peer{ID: peer.ID("peer b")}
+	ca, cb := swarm.NewChan(10), swarm.NewChan(10)
+
+	go forward(ca, cb, pa)
+	go forward(cb, ca, pb)
+	return NewMux(pa, ca), NewMux(pb, cb), pa, pb
+}
+
+// echo writes back every message of the streams it accepts.
+func echo(l <-chan *Stream) {
+	for s := range l {
+		go func(s *Stream) {
+			for {
+				msg, err := s.ReadMsg()
+				if err != nil {
+					s.Close()
+					return
+				}
+				s.WriteMsg(msg)
+			}
+		}(s)
+	}
+}
+
+func TestStreamEcho(t *testing.T) {
+	a, b, _, pb := newMuxPair()
+	defer a.Close()
+	defer b.Close()
+	go echo(b.Listen("/echo"))
+
+	s, err := a.NewStream(pb, "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := [][]byte{
+		[]byte("beep"),
+		{},
+		bytes.Repeat([]byte("boop"), MaxFrameData),
+	}
+
+	for _, msg := range msgs {
+		if err := s.WriteMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := s.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, msg) {
+			t.Fatalf("echoed %d bytes, expected %d", len(out), len(msg))
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteMsg([]byte("late")); err != ErrClosed {
+		t.Error("expected write after close to fail, got", err)
+	}
+	if _, err := s.ReadMsg(); err != io.EOF {
+		t.Error("expected EOF after the remote closed, got", err)
+	}
+}
+
+func TestStreamIDs(t *testing.T) {
+	a, b, pa, pb := newMuxPair()
+	defer a.Close()
+	defer b.Close()
+
+	sa, _ := a.NewStream(pb, "/x")
+	sb, _ := b.NewStream(pa, "/x")
+	if sa.ID()%2 == sb.ID()%2 {
+		t.Error("both sides opened streams with the same ID parity")
+	}
+
+	sa2, _ := a.NewStream(pb, "/x")
+	if sa2.ID() == sa.ID() {
+		t.Error("stream IDs repeated")
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	a, b, _, pb := newMuxPair()
+	defer a.Close()
+	defer b.Close()
+
+	bulk := b.Listen("/bulk")
+	go echo(b.Listen("/echo"))
+
+	s, err := a.NewStream(pb, "/bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the remote does not read: writes stop once the window is full.
+	msg := make([]byte, InitialWindow/4)
+	written := make(chan int, 8)
+	go func() {
+		for i := 0; i < 8; i++ {
+			if err := s.WriteMsg(msg); err != nil {
+				return
+			}
+			written <- i
+		}
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	if len(written) != 4 {
+		t.Fatalf("expected 4 writes to fit in the window, got %d", len(written))
+	}
+
+	// the stalled stream does not hold up others.
+	e, err := a.NewStream(pb, "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteMsg([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := e.ReadMsg(); err != nil || string(out) != "ping" {
+		t.Fatal("echo stream blocked behind bulk stream", err)
+	}
+
+	// reading opens the window again.
+	r := <-bulk
+	for i := 0; i < 8; i++ {
+		out, err := r.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != len(msg) {
+			t.Fatal("short message", len(out))
+		}
+	}
+
+	for i := 0; i < 8; i++ {
+		select {
+		case <-written:
+		case <-time.After(time.Second):
+			t.Fatal("writes did not finish")
+		}
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	old := WriteTimeout
+	WriteTimeout = time.Millisecond * 50
+	defer func() { WriteTimeout = old }()
+
+	a, b, _, pb := newMuxPair()
+	defer a.Close()
+	defer b.Close()
+
+	b.Listen("/bulk")
+	s, err := a.NewStream(pb, "/bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the remote does not read: the write past the window times out.
+	msg := make([]byte, InitialWindow/4)
+	for i := 0; i < 4; i++ {
+		if err := s.WriteMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.WriteMsg(msg); err != ErrWriteTimeout {
+		t.Fatal("expected ErrWriteTimeout, got", err)
+	}
+	if err := s.WriteMsg(msg); err != ErrReset {
+		t.Error("expected the stream reset after a timeout, got", err)
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	a, b, _, pb := newMuxPair()
+	defer a.Close()
+	defer b.Close()
+	l := b.Listen("/reset")
+
+	s, err := a.NewStream(pb, "/reset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteMsg([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-l
+	r.Reset()
+	if _, err := r.ReadMsg(); err != ErrReset {
+		t.Error("expected reset stream to fail reads, got", err)
+	}
+
+	if _, err := s.ReadMsg(); err != ErrReset {
+		t.Error("expected remote reset to fail reads, got", err)
+	}
+	if err := s.WriteMsg([]byte("hello")); err != ErrReset {
+		t.Error("expected remote reset to fail writes, got", err)
+	}
+}
+
+func TestUnknownProtocol(t *testing.T) {
+	a, b, _, pb := newMuxPair()
+	defer a.Close()
+	defer b.Close()
+
+	s, err := a.NewStream(pb, "/nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadMsg(); err != ErrReset {
+		t.Error("expected stream to nobody to be reset, got", err)
+	}
+}
+
+func TestMuxClose(t *testing.T) {
+	a, b, _, pb := newMuxPair()
+	defer b.Close()
+
+	s, err := a.NewStream(pb, "/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Close()
+	if _, err := s.ReadMsg(); err != ErrReset {
+		t.Error("expected Close to reset streams, got", err)
+	}
+	if _, err := a.NewStream(pb, "/x"); err != ErrMuxClosed {
+		t.Error("expected new stream on closed mux to fail, got", err)
+	}
+	if err := s.WriteMsg(make([]byte, InitialWindow+1)); err != ErrMessageTooLarge {
+		t.Error("expected large message to fail, got", err)
+	}
+}
+
+func TestOpenWithOurParity(t *testing.T) {
+	pa := &peer.Peer{ID: peer.ID("peer a")}
+	pb := &peer.Peer{ID: peer.ID("peer b")}
+	ca, cb := swarm.NewChan(10), swarm.NewChan(10)
+	go forward(ca, cb, pa)
+	go forward(cb, ca, pb)
+
+	a, b := NewMux(pa, ca), NewMux(pb, cb)
+	defer a.Close()
+	defer b.Close()
+	go echo(b.Listen("/echo"))
+	l := a.Listen("/echo")
+
+	s, err := a.NewStream(pb, "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b tries to take over s, and to open a stream with an ID a would use.
+	for _, id := range []uint64{s.ID(), s.ID() + 2} {
+		ca.Incoming <- swarm.NewMessage(pb, &PBFrame{
+			Type:     PBFrame_OPEN.Enum(),
+			Stream:   proto.Uint64(id),
+			Protocol: proto.String("/echo"),
+		})
+	}
+
+	if err := s.WriteMsg([]byte("beep")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadMsg(); err != nil {
+		t.Fatal("stream was taken over", err)
+	}
+
+	select {
+	case <-l:
+		t.Error("accepted a stream with our ID parity")
+	default:
+	}
+}
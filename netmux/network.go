@@ -0,0 +1,244 @@
+package netmux
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	peer "../peer"
+	swarm "../swarm"
+	u "../util"
+)
+
+// SendBuffer is the number of messages queued per peer and service.
+// Messages sent while a peer's queue is full are dropped, so a peer that
+// stops reading does not hold up the others.
+var SendBuffer = 10
+
+// ConnCheckInterval is how often the streams and queues of peers whose
+// connection is gone are torn down.
+var ConnCheckInterval = time.Second * 10
+
+// Network is a swarm.Network whose service Chans are carried over netmux
+// streams, one per peer and service, instead of sharing the connection
+// queue. A large bitswap block then only takes its turn with the DHT
+// frames, rather than holding them up until it is sent.
+type Network struct {
+	swarm.Network
+	mux *Mux
+
+	chans   map[swarm.PBWrapper_MessageType]*swarm.Chan
+	writers map[writerKey]*writer
+	lock    sync.Mutex
+
+	// SendBuffer, when the Network was made.
+	sendBuffer int
+
+	halt chan struct{}
+}
+
+// writerKey identifies the writer of a service to a peer.
+type writerKey struct {
+	peer u.Key
+	pid  ProtocolID
+}
+
+// writer sends the messages of a service to a peer, over a stream.
+type writer struct {
+	peer  *peer.Peer
+	pid   ProtocolID
+	queue chan []byte
+	halt  chan struct{}
+}
+
+// NewNetwork wraps n, multiplexing its services over the swarm Chan for
+// PBWrapper_NETMUX.
+func NewNetwork(local *peer.Peer, n swarm.Network) *Network {
+	net := &Network{
+		Network: n,
+		mux:     NewMux(local, n.GetChannel(swarm.PBWrapper_NETMUX)),
+		chans:   map[swarm.PBWrapper_MessageType]*swarm.Chan{},
+		writers: map[writerKey]*writer{},
+		halt:    make(chan struct{}),
+
+		sendBuffer: SendBuffer,
+	}
+	go net.checkConns()
+	return net
+}
+
+// GetChannel returns the Chan of the service speaking typ. Messages sent
+// on it go out on a stream of their own to each peer.
+func (n *Network) GetChannel(typ swarm.PBWrapper_MessageType) *swarm.Chan {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	ch, found := n.chans[typ]
+	if !found {
+		ch = swarm.NewChan(10)
+		n.chans[typ] = ch
+
+		// listen before anything is sent, so no stream is turned away.
+		pid := serviceProtocol(typ)
+		go n.handleOutgoing(ch, pid)
+		go n.handleStreams(ch, n.mux.Listen(pid))
+	}
+	return ch
+}
+
+// Close stops the services, resets their streams, and closes the
+// wrapped network.
+func (n *Network) Close() {
+	close(n.halt)
+	n.mux.Close()
+	n.Network.Close()
+}
+
+// serviceProtocol returns the protocol the streams of service typ speak.
+func serviceProtocol(typ swarm.PBWrapper_MessageType) ProtocolID {
+	return ProtocolID("/ipfs/swarm/" + typ.String())
+}
+
+// handleOutgoing hands the messages sent on ch to the writer of their
+// peer, or drops them if the writer is full.
+func (n *Network) handleOutgoing(ch *swarm.Chan, pid ProtocolID) {
+	for {
+		select {
+		case msg, ok := <-ch.Outgoing:
+			if !ok {
+				return
+			}
+
+			w := n.getWriter(msg.Peer, pid)
+			select {
+			case w.queue <- msg.Data:
+			default:
+				u.PErr("netmux: %s queue to %s full, dropping message\n", pid, msg.Peer.ID.Pretty())
+			}
+
+		case <-ch.Close:
+			return
+		case <-n.halt:
+			return
+		}
+	}
+}
+
+// getWriter returns the writer of pid to p, starting it if needed.
+func (n *Network) getWriter(p *peer.Peer, pid ProtocolID) *writer {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	k := writerKey{p.Key(), pid}
+	w, found := n.writers[k]
+	if !found {
+		w = &writer{
+			peer:  p,
+			pid:   pid,
+			queue: make(chan []byte, n.sendBuffer),
+			halt:  make(chan struct{}),
+		}
+		n.writers[k] = w
+		go n.writeStream(w)
+	}
+	return w
+}
+
+// writeStream sends the messages queued on w, over a stream opened on the
+// first one, and opened again if it fails.
+func (n *Network) writeStream(w *writer) {
+	var s *Stream
+	defer func() {
+		if s != nil {
+			s.Reset()
+		}
+	}()
+
+	for {
+		select {
+		case data := <-w.queue:
+			if s == nil {
+				var err error
+				s, err = n.mux.NewStream(w.peer, w.pid)
+				if err != nil {
+					u.PErr("netmux: cannot open %s stream to %s: %v\n", w.pid, w.peer.ID.Pretty(), err)
+					continue
+				}
+			}
+
+			if err := s.WriteMsg(data); err != nil {
+				u.PErr("netmux: %s message to %s lost: %v\n", w.pid, w.peer.ID.Pretty(), err)
+				s.Reset()
+				s = nil
+			}
+
+		case <-w.halt:
+			return
+		case <-n.halt:
+			return
+		}
+	}
+}
+
+// checkConns tears down the streams and writers of the peers the wrapped
+// network is no longer connected to, until the Network is closed.
+func (n *Network) checkConns() {
+	tick := time.NewTicker(ConnCheckInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			n.dropDisconnected()
+		case <-n.halt:
+			return
+		}
+	}
+}
+
+func (n *Network) dropDisconnected() {
+	for _, p := range n.mux.peers() {
+		if n.Network.Find(p.Key()) == nil {
+			n.mux.ResetPeer(p)
+		}
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for k, w := range n.writers {
+		if n.Network.Find(k.peer) == nil {
+			close(w.halt)
+			delete(n.writers, k)
+		}
+	}
+}
+
+// handleStreams reads the streams other peers open for a service, which
+// arrive on l, and delivers their messages on ch.
+func (n *Network) handleStreams(ch *swarm.Chan, l <-chan *Stream) {
+	for s := range l {
+		go n.readStream(ch, s)
+	}
+}
+
+func (n *Network) readStream(ch *swarm.Chan, s *Stream) {
+	for {
+		data, err := s.ReadMsg()
+		if err != nil {
+			if err != io.EOF {
+				u.DOut("netmux: %s stream from %s: %v\n", s.Protocol(), s.Peer().ID.Pretty(), err)
+			}
+			s.Close()
+			return
+		}
+
+		select {
+		case ch.Incoming <- &swarm.Message{Peer: s.Peer(), Data: data}:
+		case <-n.halt:
+			s.Reset()
+			return
+		}
+	}
+}
+
+var _ swarm.Network = &Network{}
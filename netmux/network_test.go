@@ -0,0 +1,152 @@
+package netmux
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	peer "../peer"
+	swarm "../swarm"
+	u "../util"
+)
+
+// chanNet is a swarm.Network handing out a single Chan. It is connected
+// to every peer not marked gone.
+type chanNet struct {
+	swarm.Network
+	ch *swarm.Chan
+
+	gone map[u.Key]bool
+	lock sync.Mutex
+}
+
+func (n *chanNet) GetChannel(swarm.PBWrapper_MessageType) *swarm.Chan {
+	return n.ch
+}
+
+func (n *chanNet) Find(k u.Key) *peer.Peer {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.gone[k] {
+		return nil
+	}
+	return &peer.Peer{ID: peer.ID(k)}
+}
+
+func (n *chanNet) disconnect(p *peer.Peer) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.gone[p.Key()] = true
+}
+
+func (n *chanNet) Close() {}
+
+// newNetworkPair returns two Networks connected to each other, and their
+// peers.
+func newNetworkPair() (*Network, *Network, *peer.Peer, *peer.Peer) {
+	pa := &peer.Peer{ID: peer.ID("peer a")}
+	pb := &peer.Peer{ID: peer.ID("peer b")}
+	ca, cb := swarm.NewChan(10), swarm.NewChan(10)
+
+	go forward(ca, cb, pa)
+	go forward(cb, ca, pb)
+	na := &chanNet{ch: ca, gone: map[u.Key]bool{}}
+	nb := &chanNet{ch: cb, gone: map[u.Key]bool{}}
+	return NewNetwork(pa, na), NewNetwork(pb, nb), pa, pb
+}
+
+func TestNetworkServices(t *testing.T) {
+	a, b, pa, pb := newNetworkPair()
+	defer a.Close()
+	defer b.Close()
+
+	bsa := a.GetChannel(swarm.PBWrapper_BITSWAP)
+	dhta := a.GetChannel(swarm.PBWrapper_DHT_MESSAGE)
+	bsb := b.GetChannel(swarm.PBWrapper_BITSWAP)
+	dhtb := b.GetChannel(swarm.PBWrapper_DHT_MESSAGE)
+
+	// a DHT message sent behind a large block takes its turn with it, and
+	// arrives while the block is not read yet.
+	block := bytes.Repeat([]byte("b"), InitialWindow)
+	bsa.Outgoing <- &swarm.Message{Peer: pb, Data: block}
+	dhta.Outgoing <- &swarm.Message{Peer: pb, Data: []byte("find peer")}
+
+	timeout := time.After(time.Second * 5)
+	select {
+	case msg := <-dhtb.Incoming:
+		if string(msg.Data) != "find peer" || msg.Peer != pa {
+			t.Error("DHT message garbled", msg)
+		}
+	case <-timeout:
+		t.Fatal("DHT message was not delivered")
+	}
+
+	select {
+	case msg := <-bsb.Incoming:
+		if !bytes.Equal(msg.Data, block) {
+			t.Error("block garbled")
+		}
+	case <-timeout:
+		t.Fatal("block was not delivered")
+	}
+}
+
+func TestNetworkStalledPeer(t *testing.T) {
+	old := SendBuffer
+	SendBuffer = 1
+	defer func() { SendBuffer = old }()
+
+	a, b, _, pb := newNetworkPair()
+	defer a.Close()
+	defer b.Close()
+
+	// b never reads its bitswap messages, so the window to it fills.
+	bsa := a.GetChannel(swarm.PBWrapper_BITSWAP)
+	b.GetChannel(swarm.PBWrapper_BITSWAP)
+
+	block := bytes.Repeat([]byte("b"), InitialWindow/2)
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			bsa.Outgoing <- &swarm.Message{Peer: pb, Data: block}
+		}
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second * 5):
+		t.Fatal("sends held up by a peer that does not read")
+	}
+}
+
+func TestNetworkDropDisconnected(t *testing.T) {
+	a, b, _, pb := newNetworkPair()
+	defer a.Close()
+	defer b.Close()
+
+	dhta := a.GetChannel(swarm.PBWrapper_DHT_MESSAGE)
+	dhtb := b.GetChannel(swarm.PBWrapper_DHT_MESSAGE)
+
+	dhta.Outgoing <- &swarm.Message{Peer: pb, Data: []byte("find peer")}
+	select {
+	case <-dhtb.Incoming:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message was not delivered")
+	}
+
+	a.Network.(*chanNet).disconnect(pb)
+	a.dropDisconnected()
+
+	a.lock.Lock()
+	writers := len(a.writers)
+	a.lock.Unlock()
+	if writers != 0 {
+		t.Error("writers of a disconnected peer kept", writers)
+	}
+
+	if peers := a.mux.peers(); len(peers) != 0 {
+		t.Error("streams of a disconnected peer kept", peers)
+	}
+}
@@ -0,0 +1,285 @@
+package netmux
+
+import (
+	"io"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+
+	peer "../peer"
+	u "../util"
+)
+
+// Stream is a two way stream of messages to a peer, speaking a single
+// protocol. Each side closes it for writing independently; Reset aborts
+// it both ways.
+type Stream struct {
+	mux      *Mux
+	id       uint64
+	peer     *peer.Peer
+	protocol ProtocolID
+
+	// receiving side.
+	recvQ        [][]byte
+	partial      []byte
+	recvBuffered int // bytes received, not read yet
+	recvUnacked  int // bytes read, not yet returned to the writer's window
+	remoteClosed bool
+
+	// sending side.
+	sendQ       []*chunk
+	sendWindow  int
+	queued      uint64
+	sent        uint64
+	localClosed bool
+
+	reset bool
+}
+
+// chunk is a frame queued on a stream, and the window it uses.
+type chunk struct {
+	frame *PBFrame
+	size  int
+}
+
+func newStream(m *Mux, p *peer.Peer, id uint64, pid ProtocolID) *Stream {
+	return &Stream{
+		mux:        m,
+		id:         id,
+		peer:       p,
+		protocol:   pid,
+		sendWindow: InitialWindow,
+	}
+}
+
+// ID returns the ID of the stream, unique among the streams to its peer.
+func (s *Stream) ID() uint64 {
+	return s.id
+}
+
+// Peer returns the remote peer.
+func (s *Stream) Peer() *peer.Peer {
+	return s.peer
+}
+
+// Protocol returns the protocol the stream speaks.
+func (s *Stream) Protocol() ProtocolID {
+	return s.protocol
+}
+
+// WriteMsg sends msg as one message. It returns once msg has been handed
+// to the swarm, which waits for the remote to read earlier messages if
+// the window is full, for up to WriteTimeout.
+func (s *Stream) WriteMsg(msg []byte) error {
+	if len(msg) > InitialWindow {
+		return ErrMessageTooLarge
+	}
+
+	m := s.mux
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if s.reset {
+		return ErrReset
+	}
+	if s.localClosed {
+		return ErrClosed
+	}
+
+	for {
+		n, fin := len(msg), true
+		if n > MaxFrameData {
+			n, fin = MaxFrameData, false
+		}
+
+		s.queue(&PBFrame{
+			Type:   PBFrame_DATA.Enum(),
+			Stream: proto.Uint64(s.id),
+			Data:   msg[:n],
+			Fin:    proto.Bool(fin),
+		}, n)
+
+		msg = msg[n:]
+		if fin {
+			break
+		}
+	}
+
+	// timedOut is guarded by the mux lock.
+	timedOut := false
+	timer := time.AfterFunc(WriteTimeout, func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		timedOut = true
+		m.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	target := s.queued
+	for s.sent < target && !s.reset && !timedOut {
+		m.cond.Wait()
+	}
+
+	if s.reset {
+		return ErrReset
+	}
+	if s.sent < target {
+		// part of msg may be sent already; the stream can't go on.
+		s.abort()
+		return ErrWriteTimeout
+	}
+	return nil
+}
+
+// ReadMsg returns the next message. It returns io.EOF once the remote has
+// closed the stream and all its messages have been read.
+func (s *Stream) ReadMsg() ([]byte, error) {
+	m := s.mux
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for len(s.recvQ) == 0 && !s.remoteClosed && !s.reset {
+		// the writer may be waiting on the window to finish the next
+		// message: return what was read before blocking.
+		s.sendWindowUpdate()
+		m.cond.Wait()
+	}
+
+	if s.reset {
+		return nil, ErrReset
+	}
+	if len(s.recvQ) == 0 {
+		return nil, io.EOF
+	}
+
+	msg := s.recvQ[0]
+	s.recvQ = s.recvQ[1:]
+	s.recvBuffered -= len(msg)
+	s.recvUnacked += len(msg)
+
+	if s.recvUnacked >= InitialWindow/4 {
+		s.sendWindowUpdate()
+	}
+	return msg, nil
+}
+
+// Close closes the stream for writing. Messages already written are still
+// delivered, and then the remote reads io.EOF. Reading goes on until the
+// remote closes too.
+func (s *Stream) Close() error {
+	m := s.mux
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if s.reset {
+		return ErrReset
+	}
+	if s.localClosed {
+		return ErrClosed
+	}
+
+	s.localClosed = true
+	s.queue(&PBFrame{
+		Type:   PBFrame_CLOSE.Enum(),
+		Stream: proto.Uint64(s.id),
+	}, 0)
+	return nil
+}
+
+// Reset aborts the stream both ways. Unread and unsent messages are
+// dropped, and reads and writes on either side fail with ErrReset.
+func (s *Stream) Reset() {
+	m := s.mux
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !s.reset {
+		s.abort()
+	}
+}
+
+func (s *Stream) key() streamKey {
+	return streamKey{s.peer.Key(), s.id}
+}
+
+// queue appends f to the frames to send. The mux lock must be held.
+func (s *Stream) queue(f *PBFrame, size int) {
+	s.sendQ = append(s.sendQ, &chunk{frame: f, size: size})
+	s.queued++
+
+	if len(s.sendQ) == 1 {
+		s.mux.sending = append(s.mux.sending, s)
+	}
+	s.mux.cond.Broadcast()
+}
+
+// receive buffers a message fragment. The mux lock must be held.
+func (s *Stream) receive(data []byte, fin bool) {
+	if s.remoteClosed {
+		u.PErr("netmux: %s sent data on closed stream %d\n", s.peer.ID.Pretty(), s.id)
+		s.abort()
+		return
+	}
+
+	s.recvBuffered += len(data)
+	if s.recvBuffered > InitialWindow {
+		u.PErr("netmux: %s overran the window of stream %d\n", s.peer.ID.Pretty(), s.id)
+		s.abort()
+		return
+	}
+
+	s.partial = append(s.partial, data...)
+	if fin {
+		msg := s.partial
+		if msg == nil {
+			msg = []byte{}
+		}
+		s.recvQ = append(s.recvQ, msg)
+		s.partial = nil
+	}
+}
+
+// sendWindowUpdate returns the bytes read to the writer's window. The mux
+// lock must be held.
+func (s *Stream) sendWindowUpdate() {
+	if s.recvUnacked == 0 || s.remoteClosed {
+		return
+	}
+
+	s.mux.sendControl(s.peer, &PBFrame{
+		Type:   PBFrame_WINDOW.Enum(),
+		Stream: proto.Uint64(s.id),
+		Delta:  proto.Uint32(uint32(s.recvUnacked)),
+	})
+	s.recvUnacked = 0
+}
+
+// abort resets the stream, and tells the remote. The mux lock must be held.
+func (s *Stream) abort() {
+	s.mux.sendReset(s.peer, s.id)
+	s.resetLocal()
+}
+
+// resetLocal resets the stream on this side. The mux lock must be held.
+func (s *Stream) resetLocal() {
+	s.reset = true
+	s.sendQ = nil
+	s.recvQ = nil
+	s.partial = nil
+	s.remove()
+}
+
+// maybeRemove forgets the stream once it is closed both ways, and all its
+// frames are sent. The mux lock must be held.
+func (s *Stream) maybeRemove() {
+	if s.localClosed && s.remoteClosed && len(s.sendQ) == 0 {
+		s.remove()
+	}
+}
+
+func (s *Stream) remove() {
+	if s.mux.streams[s.key()] == s {
+		delete(s.mux.streams, s.key())
+	}
+	s.mux.cond.Broadcast()
+}
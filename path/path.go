@@ -1,24 +1,80 @@
 package path
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	merkledag "../merkledag"
+	namesys "../namesys"
 	u "../util"
 	mh "github.com/jbenet/go-multihash"
 	"path"
 	"strings"
 )
 
+// MaxNameDepth is the number of /ipns/ names a path may go through, so
+// that names pointing at each other cannot loop forever.
+var MaxNameDepth = 32
+
+// ErrNoComponents signals an empty path.
+var ErrNoComponents = errors.New("path must contain at least one component")
+
+// ErrDepthLimit signals a path going through more than MaxNameDepth names.
+var ErrDepthLimit = errors.New("name resolution depth limit exceeded")
+
+// ErrNoNamesys signals an /ipns/ path given to a Resolver without Namesys.
+var ErrNoNamesys = errors.New("no name system to resolve /ipns/ paths")
+
+// ErrNoLink signals a path component naming no link of its parent object.
+type ErrNoLink struct {
+	Name string
+	Node mh.Multihash
+}
+
+func (e ErrNoLink) Error() string {
+	return fmt.Sprintf("no link named %q under %s", e.Name, e.Node.B58String())
+}
+
+// Error is returned when a path fails to resolve. It says which segment
+// of the path failed, and why.
+type Error struct {
+	// Path is the path being resolved.
+	Path string
+
+	// Segment is the component of Path that failed.
+	Segment string
+
+	// Err is the reason it failed.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("cannot resolve %s at %q: %v", e.Path, e.Segment, e.Err)
+}
+
 // Resolver provides path resolution to IPFS
 // It has a pointer to a DAGService, which is uses to resolve nodes.
+// Namesys, if set, resolves the names in /ipns/ paths.
 type Resolver struct {
-	DAG *merkledag.DAGService
+	DAG     *merkledag.DAGService
+	Namesys namesys.Resolver
+}
+
+// ResolvePath fetches the node for given path. Paths take the forms:
+//
+//   /ipfs/<hash>/<name>/...
+//   /ipns/<name>/<name>/...
+//   <hash>/<name>/...
+//
+// The first node is fetched by hash, or by resolving the name to another
+// path, then all other components are resolved walking the links, with
+// ResolveLinks. Failures are reported as an *Error. Fetching nodes from the
+// network gives up when ctx is done.
+func (s *Resolver) ResolvePath(ctx context.Context, fpath string) (*merkledag.Node, error) {
+	return s.resolvePath(ctx, fpath, 0)
 }
 
-// ResolvePath fetches the node for given path. It uses the first
-// path component as a hash (key) of the first node, then resolves
-// all other components walking the links, with ResolveLinks.
-func (s *Resolver) ResolvePath(fpath string) (*merkledag.Node, error) {
+func (s *Resolver) resolvePath(ctx context.Context, fpath string, depth int) (*merkledag.Node, error) {
 	fpath = path.Clean(fpath)
 
 	parts := strings.Split(fpath, "/")
@@ -29,37 +85,86 @@ func (s *Resolver) ResolvePath(fpath string) (*merkledag.Node, error) {
 	}
 
 	// if nothing, bail.
+	if len(parts) == 0 || len(parts[0]) == 0 {
+		return nil, &Error{Path: fpath, Err: ErrNoComponents}
+	}
+
+	switch parts[0] {
+	case "ipfs":
+		parts = parts[1:]
+	case "ipns":
+		return s.resolveName(ctx, fpath, parts[1:], depth)
+	}
+
 	if len(parts) == 0 {
-		return nil, fmt.Errorf("ipfs path must contain at least one component")
+		return nil, &Error{Path: fpath, Segment: "ipfs", Err: ErrNoComponents}
 	}
 
-	// first element in the path is a b58 hash (for now)
+	// first element in the path is a b58 hash
 	h, err := mh.FromB58String(parts[0])
 	if err != nil {
-		return nil, err
+		return nil, &Error{Path: fpath, Segment: parts[0], Err: err}
+	}
+
+	nd, err := s.DAG.Get(ctx, u.Key(h))
+	if err != nil {
+		return nil, &Error{Path: fpath, Segment: parts[0], Err: err}
+	}
+
+	nd, i, err := s.resolveLinks(ctx, nd, parts[1:])
+	if err != nil {
+		return nil, &Error{Path: fpath, Segment: parts[1+i], Err: err}
+	}
+	return nd, nil
+}
+
+// resolveName resolves the name in parts[0] to a path, and resolves the
+// rest of parts under it.
+func (s *Resolver) resolveName(ctx context.Context, fpath string, parts []string, depth int) (*merkledag.Node, error) {
+	if len(parts) == 0 {
+		return nil, &Error{Path: fpath, Segment: "ipns", Err: ErrNoComponents}
+	}
+
+	if s.Namesys == nil {
+		return nil, &Error{Path: fpath, Segment: parts[0], Err: ErrNoNamesys}
 	}
 
-	nd, err := s.DAG.Get(u.Key(h))
+	if depth >= MaxNameDepth {
+		return nil, &Error{Path: fpath, Segment: parts[0], Err: ErrDepthLimit}
+	}
+
+	target, err := s.Namesys.Resolve(parts[0])
 	if err != nil {
-		return nil, err
+		return nil, &Error{Path: fpath, Segment: parts[0], Err: err}
 	}
 
-	return s.ResolveLinks(nd, parts[1:])
+	u.DOut("resolved /ipns/%s to %s\n", parts[0], target)
+	rest := append([]string{target}, parts[1:]...)
+	return s.resolvePath(ctx, strings.Join(rest, "/"), depth+1)
 }
 
 // ResolveLinks iteratively resolves names by walking the link hierarchy.
 // Every node is fetched from the DAGService, resolving the next name.
 // Returns the last node found.
 //
-// ResolveLinks(nd, []string{"foo", "bar", "baz"})
+// ResolveLinks(ctx, nd, []string{"foo", "bar", "baz"})
 // would retrieve "baz" in ("bar" in ("foo" in nd.Links).Links).Links
-func (s *Resolver) ResolveLinks(ndd *merkledag.Node, names []string) (
+func (s *Resolver) ResolveLinks(ctx context.Context, ndd *merkledag.Node, names []string) (
 	nd *merkledag.Node, err error) {
 
+	nd, _, err = s.resolveLinks(ctx, ndd, names)
+	return nd, err
+}
+
+// resolveLinks is ResolveLinks, also returning the index of the name that
+// failed.
+func (s *Resolver) resolveLinks(ctx context.Context, ndd *merkledag.Node, names []string) (
+	nd *merkledag.Node, i int, err error) {
+
 	nd = ndd // dup arg workaround
 
 	// for each of the path components
-	for _, name := range names {
+	for i, name := range names {
 
 		var next u.Key
 		// for each of the links in nd, the current object
@@ -71,16 +176,15 @@ func (s *Resolver) ResolveLinks(ndd *merkledag.Node, names []string) (
 		}
 
 		if next == "" {
-			h1, _ := nd.Multihash()
-			h2 := h1.B58String()
-			return nil, fmt.Errorf("no link named %q under %s", name, h2)
+			h, _ := nd.Multihash()
+			return nil, i, ErrNoLink{Name: name, Node: mh.Multihash(h)}
 		}
 
 		// fetch object for link and assign to nd
-		nd, err = s.DAG.Get(next)
+		nd, err = s.DAG.Get(ctx, next)
 		if err != nil {
-			return nd, err
+			return nil, i, err
 		}
 	}
-	return
+	return nd, 0, nil
 }
@@ -0,0 +1,133 @@
+package path
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+
+	blocks "../blocks"
+	merkledag "../merkledag"
+	u "../util"
+)
+
+// mockNamesys resolves names out of a map.
+type mockNamesys map[string]string
+
+func (m mockNamesys) Resolve(name string) (string, error) {
+	p, ok := m[name]
+	if !ok {
+		return "", u.ErrNotFound
+	}
+	return p, nil
+}
+
+func setup(t *testing.T) (*Resolver, string, *merkledag.Node) {
+	bs, err := blocks.NewBlockService(ds.NewMapDatastore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dag := &merkledag.DAGService{Blocks: bs}
+
+	// root -> a -> b
+	b := &merkledag.Node{Data: []byte("b")}
+	a := &merkledag.Node{Data: []byte("a")}
+	if err := a.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	root := &merkledag.Node{Data: []byte("root")}
+	if err := root.AddNodeLink("a", a); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dag.AddRecursive(root); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := root.Multihash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns := mockNamesys{
+		"name":  "/ipfs/" + h.B58String(),
+		"alias": "/ipns/name/a",
+		"loop":  "/ipns/loop",
+	}
+	return &Resolver{DAG: dag, Namesys: ns}, h.B58String(), b
+}
+
+func TestResolvePath(t *testing.T) {
+	r, hash, b := setup(t)
+	bk, err := b.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{
+		hash + "/a/b",
+		"/" + hash + "/a/b",
+		"/ipfs/" + hash + "/a/b",
+		"/ipns/name/a/b",
+		"/ipns/alias/b",
+	}
+
+	for _, p := range paths {
+		nd, err := r.ResolvePath(context.Background(), p)
+		if err != nil {
+			t.Errorf("failed to resolve %s: %v", p, err)
+			continue
+		}
+
+		k, err := nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != bk {
+			t.Errorf("%s resolved to the wrong node", p)
+		}
+	}
+}
+
+func TestResolvePathErrors(t *testing.T) {
+	r, hash, _ := setup(t)
+
+	failures := []struct {
+		path    string
+		segment string
+		err     error
+	}{
+		{"/ipfs/" + hash + "/a/c", "c", nil},
+		{"/ipns/unknown/a", "unknown", u.ErrNotFound},
+		{"/ipns/loop", "loop", ErrDepthLimit},
+		{"/ipfs/", "ipfs", ErrNoComponents},
+		{"/ipfs/notahash", "notahash", nil},
+	}
+
+	for _, f := range failures {
+		_, err := r.ResolvePath(context.Background(), f.path)
+		perr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: expected a path error, got %v", f.path, err)
+			continue
+		}
+
+		if perr.Segment != f.segment {
+			t.Errorf("%s: expected failure at %q, got %q", f.path, f.segment, perr.Segment)
+		}
+
+		if f.err != nil && perr.Err != f.err {
+			t.Errorf("%s: expected %v, got %v", f.path, f.err, perr.Err)
+		}
+	}
+
+	_, err := r.ResolvePath(context.Background(), "/ipfs/" + hash + "/a/c")
+	if _, ok := err.(*Error).Err.(ErrNoLink); !ok {
+		t.Error("expected a missing link error, got", err)
+	}
+
+	r.Namesys = nil
+	if _, err := r.ResolvePath(context.Background(), "/ipns/name"); err.(*Error).Err != ErrNoNamesys {
+		t.Error("expected ErrNoNamesys, got", err)
+	}
+}
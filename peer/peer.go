@@ -4,6 +4,7 @@ import (
 	"time"
 	"sync"
 
+	ci "../crypto"
 	u "../util"
 	ma "github.com/multiformats/go-multiaddr"
 	mh "github.com/multiformats/go-multihash"
@@ -24,6 +25,16 @@ func (id ID) Pretty() string {
 	return b58.Encode(id)
 }
 
+// IDFromPubKey returns the ID of the peer holding pk: the multihash of the
+// serialized key.
+func IDFromPubKey(pk ci.PubKey) (ID, error) {
+	h, err := pk.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return ID(h), nil
+}
+
 // Map maps Key (string) : *Peer (slices are not comparable).
 type Map map[u.Key]*Peer
 
@@ -33,6 +44,10 @@ type Peer struct {
 	ID        ID
 	Addresses []*ma.Multiaddr
 
+	// PrivKey is only known for the local peer.
+	PrivKey ci.PrivKey
+	PubKey  ci.PubKey
+
 	latency   time.Duration
 	latenLock sync.RWMutex
 }
@@ -1,6 +1,7 @@
 package peer
 
 import (
+	ci "../crypto"
 	ma "github.com/jbenet/go-multiaddr"
 	mh "github.com/jbenet/go-multihash"
 	"testing"
@@ -40,3 +41,38 @@ func TestNetAddress(t *testing.T) {
 		t.Error("NetAddress lookup failed", udp, udp2)
 	}
 }
+
+func TestIDFromPubKey(t *testing.T) {
+	_, pk, err := ci.GenerateKeyPair(ci.KeyType_Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := IDFromPubKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := pk.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !id.Equal(ID(h)) {
+		t.Error("peer ID is not the hash of the public key")
+	}
+
+	_, pk2, err := ci.GenerateKeyPair(ci.KeyType_Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id2, err := IDFromPubKey(pk2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Equal(id2) {
+		t.Error("different keys gave the same peer ID")
+	}
+}
@@ -0,0 +1,370 @@
+// Package pin tracks the objects that must be kept in local storage.
+//
+// Objects are pinned directly (only the object itself), or recursively (the
+// object and everything it links to). Objects under a recursive pin are
+// pinned indirectly, and counted, so overlapping recursive pins each keep
+// them.
+package pin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	b58 "github.com/jbenet/go-base58"
+
+	mdag "../merkledag"
+	u "../util"
+)
+
+// ErrNotPinned signals an unpin of an object that is not pinned.
+var ErrNotPinned = errors.New("not pinned")
+
+var (
+	directPinKey    = ds.NewKey("/local/pins/direct/keys")
+	recursivePinKey = ds.NewKey("/local/pins/recursive/keys")
+	indirectPinKey  = ds.NewKey("/local/pins/indirect/keys")
+)
+
+// Mode is the way an object is pinned.
+type Mode int
+
+// Pin modes.
+const (
+	NotPinned Mode = iota
+	Direct
+	Recursive
+	Indirect
+)
+
+// String returns the name of the mode, as shown by `ipfs pin ls`.
+func (m Mode) String() string {
+	switch m {
+	case Direct:
+		return "direct"
+	case Recursive:
+		return "recursive"
+	case Indirect:
+		return "indirect"
+	}
+	return "unpinned"
+}
+
+// Pinner keeps track of pinned objects. Changes are only persisted to the
+// datastore on Flush.
+type Pinner interface {
+	// IsPinned returns whether k is pinned in any mode.
+	IsPinned(k u.Key) bool
+
+	// PinMode returns the mode k is pinned with. Direct and recursive pins
+	// take precedence over indirect ones.
+	PinMode(k u.Key) Mode
+
+	// Pin pins nd, and if recursive, all the objects it links to. Objects
+	// not held locally are fetched within ctx.
+	Pin(ctx context.Context, nd *mdag.Node, recursive bool) error
+
+	// Unpin removes the pin on k. Recursive pins are only removed if
+	// recursive is set.
+	Unpin(ctx context.Context, k u.Key, recursive bool) error
+
+	// Flush writes the pin sets to the datastore.
+	Flush() error
+
+	// PinLock keeps garbage collection from running until the returned
+	// func is called. Hold it while writing objects that are pinned
+	// afterwards, so they are not collected in between.
+	PinLock() func()
+
+	// GCLock waits for the held PinLocks, and keeps new ones from being
+	// taken, until the returned func is called.
+	GCLock() func()
+
+	DirectKeys() []u.Key
+	RecursiveKeys() []u.Key
+	IndirectKeys() map[u.Key]int
+}
+
+type keySet map[u.Key]struct{}
+
+// pinner is the datastore backed Pinner.
+type pinner struct {
+	lock      sync.RWMutex
+	gclock    sync.RWMutex
+	direct    keySet
+	recursive keySet
+	indirect  map[u.Key]int
+
+	dserv  *mdag.DAGService
+	dstore ds.Datastore
+}
+
+// NewPinner returns a Pinner with no pins, persisting into d. Recursive pins
+// walk the objects through dserv.
+func NewPinner(d ds.Datastore, dserv *mdag.DAGService) Pinner {
+	return &pinner{
+		direct:    keySet{},
+		recursive: keySet{},
+		indirect:  map[u.Key]int{},
+		dserv:     dserv,
+		dstore:    d,
+	}
+}
+
+// LoadPinner returns a Pinner holding the pins last flushed into d.
+func LoadPinner(d ds.Datastore, dserv *mdag.DAGService) (Pinner, error) {
+	p := NewPinner(d, dserv).(*pinner)
+
+	var direct, recursive []string
+	if err := loadJSON(d, directPinKey, &direct); err != nil {
+		return nil, err
+	}
+	if err := loadJSON(d, recursivePinKey, &recursive); err != nil {
+		return nil, err
+	}
+
+	indirect := map[string]int{}
+	if err := loadJSON(d, indirectPinKey, &indirect); err != nil {
+		return nil, err
+	}
+
+	for _, s := range direct {
+		p.direct[u.Key(b58.Decode(s))] = struct{}{}
+	}
+	for _, s := range recursive {
+		p.recursive[u.Key(b58.Decode(s))] = struct{}{}
+	}
+	for s, c := range indirect {
+		p.indirect[u.Key(b58.Decode(s))] = c
+	}
+	return p, nil
+}
+
+func (p *pinner) IsPinned(k u.Key) bool {
+	return p.PinMode(k) != NotPinned
+}
+
+func (p *pinner) PinMode(k u.Key) Mode {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if _, ok := p.recursive[k]; ok {
+		return Recursive
+	}
+	if _, ok := p.direct[k]; ok {
+		return Direct
+	}
+	if p.indirect[k] > 0 {
+		return Indirect
+	}
+	return NotPinned
+}
+
+func (p *pinner) Pin(ctx context.Context, nd *mdag.Node, recursive bool) error {
+	k, err := nd.Key()
+	if err != nil {
+		return err
+	}
+
+	if !recursive {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		if _, ok := p.recursive[k]; ok {
+			return fmt.Errorf("%s already pinned recursively", k.Pretty())
+		}
+		p.direct[k] = struct{}{}
+		return nil
+	}
+
+	if p.PinMode(k) == Recursive {
+		return nil
+	}
+
+	// the DAG may have to be fetched from the network: walk it before
+	// taking the lock, so other pins do not wait on it.
+	keys, err := p.linkKeys(ctx, nd, nil)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.recursive[k]; ok {
+		return nil
+	}
+
+	for _, lk := range keys {
+		p.indirect[lk]++
+	}
+	delete(p.direct, k)
+	p.recursive[k] = struct{}{}
+	return nil
+}
+
+func (p *pinner) Unpin(ctx context.Context, k u.Key, recursive bool) error {
+	switch p.PinMode(k) {
+	case Recursive:
+		if !recursive {
+			return fmt.Errorf("%s is pinned recursively", k.Pretty())
+		}
+	case Direct:
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		delete(p.direct, k)
+		return nil
+	case Indirect:
+		return fmt.Errorf("%s is pinned indirectly", k.Pretty())
+	default:
+		return ErrNotPinned
+	}
+
+	// as in Pin, walk the DAG before taking the lock.
+	nd, err := p.dserv.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+
+	keys, err := p.linkKeys(ctx, nd, nil)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.recursive[k]; !ok {
+		return ErrNotPinned
+	}
+
+	for _, lk := range keys {
+		p.indirect[lk]--
+		if p.indirect[lk] <= 0 {
+			delete(p.indirect, lk)
+		}
+	}
+	delete(p.recursive, k)
+	return nil
+}
+
+// linkKeys appends the key of every object under nd to keys, once per
+// link reaching it.
+func (p *pinner) linkKeys(ctx context.Context, nd *mdag.Node, keys []u.Key) ([]u.Key, error) {
+	for _, l := range nd.Links {
+		child, err := p.getChild(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+
+		keys, err = p.linkKeys(ctx, child, keys)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, u.Key(l.Hash))
+	}
+	return keys, nil
+}
+
+func (p *pinner) getChild(ctx context.Context, l *mdag.Link) (*mdag.Node, error) {
+	if l.Node != nil {
+		return l.Node, nil
+	}
+	return p.dserv.Get(ctx, u.Key(l.Hash))
+}
+
+func (p *pinner) Flush() error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	indirect := map[string]int{}
+	for k, c := range p.indirect {
+		indirect[b58.Encode([]byte(k))] = c
+	}
+
+	if err := storeJSON(p.dstore, directPinKey, encodeKeys(p.direct)); err != nil {
+		return err
+	}
+	if err := storeJSON(p.dstore, recursivePinKey, encodeKeys(p.recursive)); err != nil {
+		return err
+	}
+	return storeJSON(p.dstore, indirectPinKey, indirect)
+}
+
+func (p *pinner) PinLock() func() {
+	p.gclock.RLock()
+	return p.gclock.RUnlock
+}
+
+func (p *pinner) GCLock() func() {
+	p.gclock.Lock()
+	return p.gclock.Unlock
+}
+
+func (p *pinner) DirectKeys() []u.Key {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.direct.keys()
+}
+
+func (p *pinner) RecursiveKeys() []u.Key {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.recursive.keys()
+}
+
+func (p *pinner) IndirectKeys() map[u.Key]int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	out := make(map[u.Key]int, len(p.indirect))
+	for k, c := range p.indirect {
+		out[k] = c
+	}
+	return out
+}
+
+func (s keySet) keys() []u.Key {
+	out := make([]u.Key, 0, len(s))
+	for k := range s {
+		out = append(out, k)
+	}
+	return out
+}
+
+func encodeKeys(s keySet) []string {
+	out := make([]string, 0, len(s))
+	for k := range s {
+		out = append(out, b58.Encode([]byte(k)))
+	}
+	return out
+}
+
+// loadJSON decodes the value at k into v. A missing value leaves v as is.
+func loadJSON(d ds.Datastore, k ds.Key, v interface{}) error {
+	val, err := d.Get(k)
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data, ok := val.([]byte)
+	if !ok {
+		return fmt.Errorf("pin set at %s is not a []byte", k)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func storeJSON(d ds.Datastore, k ds.Key, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.Put(k, data)
+}
@@ -0,0 +1,232 @@
+package pin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+
+	bs "../blocks"
+	mdag "../merkledag"
+	u "../util"
+)
+
+func key(t *testing.T, nd *mdag.Node) u.Key {
+	k, err := nd.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestPinner(t *testing.T) {
+	ctx := context.Background()
+	d := ds.NewMapDatastore()
+	bsrv, err := bs.NewBlockService(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := &mdag.DAGService{Blocks: bsrv}
+
+	p := NewPinner(d, dserv)
+
+	a := &mdag.Node{Data: []byte("a")}
+	ak := key(t, a)
+	if err := p.Pin(ctx, a, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.PinMode(ak) != Direct {
+		t.Error("a should be pinned directly")
+	}
+
+	// c -> b -> leaf
+	leaf := &mdag.Node{Data: []byte("leaf")}
+	b := &mdag.Node{Data: []byte("b")}
+	if err := b.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	c := &mdag.Node{Data: []byte("c")}
+	if err := c.AddNodeLink("b", b); err != nil {
+		t.Fatal(err)
+	}
+	leafk, bk, ck := key(t, leaf), key(t, b), key(t, c)
+	if err := dserv.AddRecursive(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Pin(ctx, c, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, b, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.PinMode(ck) != Recursive || p.PinMode(bk) != Recursive {
+		t.Error("b and c should be pinned recursively")
+	}
+
+	if p.IndirectKeys()[leafk] != 2 {
+		t.Error("leaf should be counted under both recursive pins")
+	}
+
+	if err := p.Unpin(ctx, ck, false); err == nil {
+		t.Error("recursive pin was removed without recursive set")
+	}
+
+	if err := p.Unpin(ctx, ck, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.IsPinned(leafk) {
+		t.Error("leaf should still be pinned under b")
+	}
+
+	if err := p.Unpin(ctx, leafk, true); err == nil {
+		t.Error("indirect pin was removed")
+	}
+
+	// pins survive a reload from the datastore.
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	np, err := LoadPinner(d, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if np.PinMode(ak) != Direct || np.PinMode(bk) != Recursive {
+		t.Error("pins were not persisted")
+	}
+
+	if np.PinMode(leafk) != Indirect || np.IsPinned(ck) {
+		t.Error("indirect pins were not persisted")
+	}
+
+	if err := np.Unpin(ctx, bk, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if np.IsPinned(leafk) {
+		t.Error("leaf should no longer be pinned")
+	}
+
+	if err := np.Unpin(ctx, ck, true); err != ErrNotPinned {
+		t.Error("expected ErrNotPinned, got", err)
+	}
+}
+
+func TestPinMissingChild(t *testing.T) {
+	ctx := context.Background()
+	d := ds.NewMapDatastore()
+	bsrv, err := bs.NewBlockService(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := &mdag.DAGService{Blocks: bsrv}
+
+	p := NewPinner(d, dserv)
+
+	// root -> (x, y), with only x stored at first.
+	x := &mdag.Node{Data: []byte("x")}
+	y := &mdag.Node{Data: []byte("y")}
+	root := &mdag.Node{Data: []byte("root")}
+	if err := root.AddNodeLink("x", x); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("y", y); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range root.Links {
+		l.Node = nil
+	}
+	rootk, xk, yk := key(t, root), key(t, x), key(t, y)
+
+	if _, err := dserv.Put(x); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Pin(ctx, root, true); err == nil {
+		t.Fatal("pinned a DAG with a missing child")
+	}
+	if p.IsPinned(rootk) || len(p.IndirectKeys()) != 0 {
+		t.Error("failed pin left pins behind", p.IndirectKeys())
+	}
+
+	if _, err := dserv.Put(y); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bsrv.DeleteBlock(yk); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Unpin(ctx, rootk, true); err == nil {
+		t.Fatal("unpinned a DAG with a missing child")
+	}
+	if p.PinMode(rootk) != Recursive || p.IndirectKeys()[xk] != 1 {
+		t.Error("failed unpin dropped pins")
+	}
+}
+
+// stallExchange never finds a block, until ctx is done. Each fetch is
+// signalled on the channel.
+type stallExchange chan u.Key
+
+func (e stallExchange) GetBlock(ctx context.Context, k u.Key) (*bs.Block, error) {
+	e <- k
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stallExchange) HaveBlock(b *bs.Block) error { return nil }
+
+func TestPinFetchesUnlocked(t *testing.T) {
+	d := ds.NewMapDatastore()
+	fetches := make(stallExchange, 1)
+	bsrv, err := bs.NewBlockService(d, fetches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := &mdag.DAGService{Blocks: bsrv}
+
+	p := NewPinner(d, dserv)
+
+	// root -> missing, which is fetched until ctx is done.
+	root := &mdag.Node{Data: []byte("root")}
+	if err := root.AddNodeLink("missing", &mdag.Node{Data: []byte("missing")}); err != nil {
+		t.Fatal(err)
+	}
+	root.Links[0].Node = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fetching := make(chan error)
+	go func() {
+		fetching <- p.Pin(ctx, root, true)
+	}()
+	<-fetches
+
+	other := &mdag.Node{Data: []byte("other")}
+	pinned := make(chan error)
+	go func() {
+		pinned <- p.Pin(context.Background(), other, false)
+	}()
+
+	select {
+	case err := <-pinned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pin waited on another pin's fetch")
+	}
+
+	cancel()
+	if err := <-fetching; err == nil {
+		t.Error("pinned a DAG that could not be fetched")
+	}
+}
@@ -2,31 +2,16 @@ package dht
 
 import (
 	peer "../../peer"
-	"golang.org/x/crypto/openpgp"
 )
 
 // A helper struct to make working with protbuf types easier
 type DHTMessage struct {
-	Type     PBDHTMessage_MessageType
-	Key      string
-	Value    []byte
-	Response bool
-	Id       uint64
-	Success  bool
-	Peers    []*peer.Peer
-}
-
-func peerInfo(p *peer.Peer) *PBDHTMessage_PBPeer {
-	pbp := new(PBDHTMessage_PBPeer)
-	addr, err := p.Addresses[0].String()
-	if err != nil {
-		//Temp: what situations could cause this?
-		panic(err)
-	}
-	pbp.Addr = &addr
-	pid := string(p.ID)
-	pbp.Id = &pid
-	return pbp
+	Type    PBDHTMessage_MessageType
+	Key     string
+	Value   []byte
+	Success bool
+	Peers   []*peer.Peer
+	Target  []byte
 }
 
 func peerInfo(p *peer.Peer) *PBDHTMessage_PBPeer {
@@ -47,11 +32,12 @@ func (m *DHTMessage) ToProtobuf() *PBDHTMessage {
 	if m.Value != nil {
 		pmes.Value = m.Value
 	}
+	if m.Target != nil {
+		pmes.Target = m.Target
+	}
 
 	pmes.Type = &m.Type
 	pmes.Key = &m.Key
-	pmes.Response = &m.Response
-	pmes.Id = &m.Id
 	pmes.Success = &m.Success
 	for _, p := range m.Peers {
 		pmes.Peers = append(pmes.Peers, peerInfo(p))
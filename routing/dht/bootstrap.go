@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	peer "../../peer"
+	u "../../util"
+	kb "../kbucket"
+)
+
+// RefreshInterval is how long a routing table bucket may go without a
+// lookup through its range before it is refreshed.
+var RefreshInterval = time.Hour
+
+// RefreshTimeout is how long the lookup refreshing a bucket may take.
+var RefreshTimeout = time.Minute
+
+// ErrNoBootstrapPeers signals that none of the bootstrap peers could be
+// reached.
+var ErrNoBootstrapPeers = errors.New("could not connect to any bootstrap peer")
+
+// Bootstrap joins the network through peers: it connects to them, looks up
+// our own ID to find our neighbours, then refreshes every bucket, as a new
+// table's buckets start out fresh. It fails only if no peer could be
+// connected to.
+func (dht *IpfsDHT) Bootstrap(ctx context.Context, peers []*peer.Peer) error {
+	connected := 0
+	for _, p := range peers {
+		if err := dht.connectBootstrapPeer(ctx, p); err != nil {
+			u.PErr("bootstrap: could not connect to %s: %s", p.ID.Pretty(), err)
+			continue
+		}
+		connected++
+	}
+
+	if connected == 0 {
+		return ErrNoBootstrapPeers
+	}
+
+	if _, err := dht.closestPeers(ctx, kb.ConvertPeerID(dht.self.ID)); err != nil {
+		return err
+	}
+
+	dht.refreshBuckets(ctx, dht.routes[0].StaleBuckets(0))
+	u.DOut("bootstrap: %d peers in routing table", dht.routes[0].Size())
+	return nil
+}
+
+// connectBootstrapPeer connects to p, checks it is who we expect, and adds
+// it to the routing table.
+func (dht *IpfsDHT) connectBootstrapPeer(ctx context.Context, p *peer.Peer) error {
+	np, err := dht.ensureConnected(p)
+	if err != nil {
+		return err
+	}
+
+	if err := dht.Ping(ctx, np); err != nil {
+		return err
+	}
+
+	dht.Update(np)
+	return nil
+}
+
+// Refresh looks up a random ID in each bucket of the routing table no
+// lookup went through for RefreshInterval, as Kademlia does to keep the
+// buckets populated.
+func (dht *IpfsDHT) Refresh(ctx context.Context) {
+	dht.refreshBuckets(ctx, dht.routes[0].StaleBuckets(RefreshInterval))
+}
+
+// refreshBuckets looks up a random ID in each of buckets, until ctx is done.
+func (dht *IpfsDHT) refreshBuckets(ctx context.Context, buckets []int) {
+	rt := dht.routes[0]
+	for _, i := range buckets {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if _, err := dht.closestPeers(ctx, rt.RandomIDInBucket(i)); err != nil {
+			u.DOut("refresh of bucket %d failed: %s", i, err)
+		}
+	}
+}
+
+// periodicRefresh refreshes the stale buckets, until the DHT is halted.
+func (dht *IpfsDHT) periodicRefresh() {
+	tick := time.NewTicker(RefreshInterval / 4)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			ctx, cancel := context.WithTimeout(context.Background(), RefreshTimeout)
+			dht.Refresh(ctx)
+			cancel()
+		case <-dht.shutdown:
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package dht
+
+import (
+	"strings"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	b58 "github.com/jbenet/go-base58"
+
+	u "../../util"
+)
+
+// Datastore is where the DHT keeps its stored values and provider records.
+// It must list its keys, so the records can be found again by prefix.
+type Datastore interface {
+	ds.Datastore
+	KeyList() ([]ds.Key, error)
+}
+
+// syncDatastore serializes access to a datastore, as DHT requests are
+// handled concurrently.
+type syncDatastore struct {
+	Datastore
+	lock sync.Mutex
+}
+
+func (d *syncDatastore) Get(k ds.Key) (interface{}, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.Get(k)
+}
+
+func (d *syncDatastore) Put(k ds.Key, v interface{}) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.Put(k, v)
+}
+
+func (d *syncDatastore) Has(k ds.Key) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.Has(k)
+}
+
+func (d *syncDatastore) Delete(k ds.Key) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.Delete(k)
+}
+
+func (d *syncDatastore) KeyList() ([]ds.Key, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.Datastore.KeyList()
+}
+
+// keysUnder returns the keys whose records are kept in d under prefix,
+// each as prefix + <b58 encoded key>.
+func keysUnder(d Datastore, prefix string) ([]u.Key, error) {
+	dskeys, err := d.KeyList()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []u.Key
+	for _, dsk := range dskeys {
+		s := dsk.String()
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		keys = append(keys, u.Key(b58.Decode(s[len(prefix):])))
+	}
+	return keys, nil
+}
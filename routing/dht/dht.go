@@ -1,26 +1,37 @@
 package dht
 
 import (
+	"context"
 	"sync"
 	"time"
 	"bytes"
-	"encoding/json"
 
+	msgproto "../../msgproto"
 	"../../peer"
+	routing "../../routing"
 	kb "../kbucket"
 	"../../swarm"
 	u "../../util"
-	"../../identify"
 
 	ma "github.com/multiformats/go-multiaddr"
 
 	ds "github.com/ipfs/go-datastore"
+	b58 "github.com/jbenet/go-base58"
 
 	"github.com/golang/protobuf/proto"
-	"golang.org/x/crypto/ocsp"
 	"errors"
 )
 
+// ProtocolID is the msgproto protocol DHT messages are sent with.
+const ProtocolID = msgproto.ProtocolID("/ipfs/dht")
+
+// TablePolicy selects the peers the routing table holds, and which it
+// returns first.
+var TablePolicy = kb.DefaultPolicy
+
+// CheckTimeout is how long the least recently seen peer of a full bucket
+// has to answer a ping, before a newer peer replaces it.
+var CheckTimeout = time.Second * 5
 
 // TODO. SEE https://github.com/jbenet/node-ipfs/blob/master/submodules/ipfs-dht/index.js
 
@@ -31,22 +42,29 @@ type IpfsDHT struct {
 	// NOTE: (currently, only a single table is used)
 	routes []*kb.RoutingTable
 
-	network *swarm.Network
+	network swarm.Network
+
+	// service sends DHT messages, and matches responses to requests.
+	service *msgproto.Service
 
 	// Local peer (yourself)
 	self *peer.Peer
 
 	// Local data
-	datastore ds.Datastore
+	datastore Datastore
 
-	// Map keys to peers that can provide their value
-	// TODO: implement a TTL on each of these keys
-	providers    map[u.Key][]*providerInfo
-	providerLock sync.RWMutex
+	// Values stored in the DHT
+	records *recordStore
 
-	// map of channels waiting for reply messages
-	listeners  map[uint64]*listenInfo
-	listenLock sync.RWMutex
+	// Validators check the records stored in the DHT, by key namespace.
+	// Records of namespaces without a validator are refused.
+	Validators routing.Validators
+
+	// held while a stored record is compared with a new one.
+	putLock sync.Mutex
+
+	// Records of the peers that can provide the value of keys
+	providers *providerManager
 
 	// Signal to shutdown dht
 	shutdown chan struct{}
@@ -54,40 +72,47 @@ type IpfsDHT struct {
 	// When this peer started up
 	birth time.Time
 
-	//lock to make diagnostics work better
-	diaglock sync.Mutex
-}
-
-// The listen info struct holds information about a message that is being waited for
-type listenInfo struct {
-	// Responses matching the listen ID will be sent through resp
-	resp chan *swarm.Message
-
-	// count is the number of responses to listen for
-	count int
+	// peers of full buckets being pinged before they may be evicted.
+	checking  map[u.Key]struct{}
+	checkLock sync.Mutex
 
-	// eol is the time at which this listener will expire
-	eol time.Time
+	// diagnostics already answered, by ID, so they don't go in circles.
+	diagSeen map[string]time.Time
+	diaglock sync.Mutex
 }
 
-// NewDHT creates a new DHT object with the given peer as the 'local' host
-func NewDHT(p *peer.Peer, net swarm.Network) *IpfsDHT {
+// NewDHT creates a new DHT object with the given peer as the 'local' host.
+// Stored values and provider records are kept in dstore, under /dht, so
+// they survive restarts if dstore does.
+func NewDHT(p *peer.Peer, net swarm.Network, dstore Datastore) *IpfsDHT {
 	dht := new(IpfsDHT)
 	dht.network = net
-	dht.datastore = ds.NewMapDatastore()
+	dht.service = msgproto.NewService(ProtocolID, net.GetChannel(swarm.PBWrapper_DHT_MESSAGE), dht)
+	dht.datastore = &syncDatastore{Datastore: dstore}
+	dht.records = newRecordStore(dht.datastore)
+	dht.Validators = routing.Validators{"pk": routing.PublicKeyValidator}
 	dht.self = p
-	dht.listeners = make(map[uint64]*listenInfo)
-	dht.providers = make(map[u.Key][]*providerInfo)
+	dht.diagSeen = make(map[string]time.Time)
+	dht.checking = make(map[u.Key]struct{})
+	dht.providers = newProviderManager(dht.datastore)
 	dht.shutdown = make(chan struct{})
 	dht.routes = make([]*kb.RoutingTable, 1)
-	dht.routes[0] = kb.NewRoutingTable(20, kb.ConvertPeerID(p.ID))
+	dht.routes[0] = kb.NewRoutingTable(KValue, kb.ConvertPeerID(p.ID), TablePolicy)
 	dht.birth = time.Now()
 	return dht
 }
 
+// SetTablePolicy replaces the policy of the routing table, e.g. to limit
+// how many of its peers may share an IP address.
+func (dht *IpfsDHT) SetTablePolicy(pol *kb.Policy) {
+	dht.routes[0].SetPolicy(pol)
+}
+
 // Start up background goroutines needed by the DHT
 func (dht *IpfsDHT) Start() {
-	go dht.handleMessages()
+	dht.service.Start()
+	go dht.handleEvents()
+	go dht.periodicRefresh()
 }
 
 // Connect to a new peer at the given address, ping and add to the routing table
@@ -101,7 +126,9 @@ func (dht *IpfsDHT) Connect(addr *ma.Multiaddr) (*peer.Peer, error) {
 
 	// Ping new peer to register in their routing table
 	// NOTE: this should be done better...
-	err = dht.Ping(npeer, time.Second*2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	err = dht.Ping(ctx, npeer)
 	if err != nil {
 		return nil, errors.New("failed to ping newly connected peer")
 	}
@@ -111,420 +138,423 @@ func (dht *IpfsDHT) Connect(addr *ma.Multiaddr) (*peer.Peer, error) {
 	return npeer, nil
 }
 
-// Read in all messages from swarm and handle them appropriately
-// NOTE: this function is just a quick sketch
-func (dht *IpfsDHT) handleMessages() {
-	u.DOut("Begin message handling routine")
+// HandleMessage answers the DHT requests of other peers. It implements
+// msgproto.Handler.
+func (dht *IpfsDHT) HandleMessage(p *peer.Peer, data []byte) ([]byte, error) {
+	pmes := new(PBDHTMessage)
+	err := proto.Unmarshal(data, pmes)
+	if err != nil {
+		u.PErr("Failed to decode protobuf message: %s", err)
+		return nil, msgproto.ErrBadRequest
+	}
+
+	dht.Update(p)
+
+	u.DOut("[peer: %s]", dht.self.ID.Pretty())
+	u.DOut("Got message type: '%s' [from = %s]",
+		PBDHTMessage_MessageType_name[int32(pmes.GetType())], p.ID.Pretty())
+
+	var resp *PBDHTMessage
+	switch pmes.GetType() {
+	case PBDHTMessage_GET_VALUE:
+		resp, err = dht.handleGetValue(p, pmes)
+	case PBDHTMessage_PUT_VALUE:
+		err = dht.handlePutValue(p, pmes)
+	case PBDHTMessage_FIND_NODE:
+		resp, err = dht.handleFindPeer(p, pmes)
+	case PBDHTMessage_ADD_PROVIDER:
+		dht.handleAddProvider(p, pmes)
+	case PBDHTMessage_GET_PROVIDERS:
+		resp, err = dht.handleGetProviders(p, pmes)
+	case PBDHTMessage_PING:
+		resp, err = dht.handlePing(p, pmes)
+	case PBDHTMessage_DIAGNOSTIC:
+		resp, err = dht.handleDiagnostic(p, pmes)
+	default:
+		return nil, msgproto.ErrUnsupported
+	}
 
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return proto.Marshal(resp)
+}
+
+// handleEvents reports network errors, and collects expired state.
+func (dht *IpfsDHT) handleEvents() {
 	checkTimeouts := time.NewTicker(time.Minute * 5)
-	ch := dht.network.GetChan()
+	errs := dht.network.GetErrChan()
 	for {
 		select {
-		case mes, ok := <-dht.network.Chan.Incoming:
-			if !ok {
-				u.DOut("handleMessages closing, bad recv on incoming")
-				return
-			}
-			pmes := new(PBDHTMessage)
-			err := proto.Unmarshal(mes.Data, pmes)
-			if err != nil {
-				u.PErr("Failed to decode protobuf message: %s", err)
-				continue
-			}
-
-			dht.Update(mes.Peer)
-
-			// Note: not sure if this is the correct place for this
-			if pmes.GetResponse() {
-				dht.listenLock.RLock()
-				list, ok := dht.listeners[pmes.GetId()]
-				dht.listenLock.RUnlock()
-				if time.Now().After(list.eol) {
-					dht.Unlisten(pmes.GetId())
-					ok = false
-				}
-				if list.count > 1 {
-					list.count--
-				}
-				if ok {
-					list.resp <- mes
-					if list.count == 1 {
-						dht.Unlisten(pmes.GetId())
-					}
-				} else {
-					u.DOut("Received response with nobody listening...")
-				}
-
-				continue
-			}
-			//
-
-			u.DOut("[peer: %s]", dht.self.ID.Pretty())
-			u.DOut("Got message type: '%s' [id = %x, from = %s]",
-				PBDHTMessage_MessageType_name[int32(pmes.GetType())],
-				pmes.GetId(), mes.Peer.ID.Pretty())
-			switch pmes.GetType() {
-			case PBDHTMessage_GET_VALUE:
-				dht.handleGetValue(mes.Peer, pmes)
-			case PBDHTMessage_PUT_VALUE:
-				dht.handlePutValue(mes.Peer, pmes)
-			case PBDHTMessage_FIND_NODE:
-				dht.handleFindPeer(mes.Peer, pmes)
-			case PBDHTMessage_ADD_PROVIDER:
-				dht.handleAddProvider(mes.Peer, pmes)
-			case PBDHTMessage_GET_PROVIDERS:
-				dht.handleGetProviders(mes.Peer, pmes)
-			case PBDHTMessage_PING:
-				dht.handlePing(mes.Peer, pmes)
-			case PBDHTMessage_DIAGNOSTIC:
-				dht.handleDiagnostic(mes.Peer, pmes)
-			}
-
-		case err := <-ch.Errors:
+		case err := <-errs:
 			u.PErr("dht err: %s", err)
 		case <-dht.shutdown:
 			checkTimeouts.Stop()
 			return
 		case <-checkTimeouts.C:
 			// Time to collect some garbage!
-			dht.cleanExpiredProviders()
-			dht.cleanExpiredListeners()
+			if err := dht.providers.Cleanup(); err != nil {
+				u.PErr("dht: cleaning up providers: %s", err)
+			}
+			if err := dht.records.Cleanup(); err != nil {
+				u.PErr("dht: cleaning up records: %s", err)
+			}
+			dht.cleanExpiredDiagnostics()
 		}
 	}
 }
 
-func (dht *IpfsDHT) cleanExpiredProviders() {
-	dht.providerLock.Lock()
-	for k, parr := range dht.providers {
-		var cleaned []*providerInfo
-		for _, v := range parr {
-			if time.Since(v.Creation) < time.Hour {
-				cleaned = append(cleaned, v)
-			}
-		}
-		dht.providers[k] = cleaned
+// sendRequest sends pmes to p, and returns the response, until ctx is done.
+func (dht *IpfsDHT) sendRequest(ctx context.Context, p *peer.Peer, pmes *PBDHTMessage) (*PBDHTMessage, error) {
+	data, err := proto.Marshal(pmes)
+	if err != nil {
+		return nil, err
 	}
-	dht.providerLock.Unlock()
+
+	rdata, err := dht.service.SendRequest(ctx, p, data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(PBDHTMessage)
+	err = proto.Unmarshal(rdata, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
 
-func (dht *IpfsDHT) cleanExpiredListeners() {
-	dht.listenLock.Lock()
-	var remove []uint64
-	now := time.Now()
-	for k, v := range dht.listeners {
-		if now.After(v.eol) {
-			remove = append(remove, k)
-		}
+// sendMessage sends pmes to p, expecting no response.
+func (dht *IpfsDHT) sendMessage(ctx context.Context, p *peer.Peer, pmes *PBDHTMessage) error {
+	data, err := proto.Marshal(pmes)
+	if err != nil {
+		return err
 	}
-	for _, k := range remove {
-		delete(dht.listeners, k)
+	return dht.service.SendMessage(ctx, p, data)
+}
+
+// routeLevel returns the routing table a request asks about, which is
+// sent in the value.
+func (dht *IpfsDHT) routeLevel(pmes *PBDHTMessage) (int, error) {
+	if len(pmes.GetValue()) == 0 {
+		return 0, nil
 	}
-	dht.listenLock.Unlock()
+
+	level := int(pmes.GetValue()[0])
+	if level >= len(dht.routes) {
+		return 0, msgproto.ErrBadRequest
+	}
+	return level, nil
+}
+
+func (dht *IpfsDHT) cleanExpiredDiagnostics() {
+	dht.diaglock.Lock()
+	for id, seen := range dht.diagSeen {
+		if time.Since(seen) > time.Minute*2 {
+			delete(dht.diagSeen, id)
+		}
+	}
+	dht.diaglock.Unlock()
 }
 
-func (dht *IpfsDHT) putValueToNetwork(p *peer.Peer, key string, value []byte) error {
+func (dht *IpfsDHT) putValueToNetwork(ctx context.Context, p *peer.Peer, key string, value []byte) error {
 	pmes := DHTMessage{
 		Type:  PBDHTMessage_PUT_VALUE,
 		Key:   key,
 		Value: value,
-		Id:    GenerateMessageID(),
 	}
 
-	mes := swarm.NewMessage(p, pmes.ToProtobuf())
-	dht.network.Send(mes)
-	return nil
+	return dht.sendMessage(ctx, p, pmes.ToProtobuf())
 }
 
-func (dht *IpfsDHT) handleGetValue(p *peer.Peer, pmes *PBDHTMessage) {
-	dskey := ds.NewKey(pmes.GetKey())
+func (dht *IpfsDHT) handleGetValue(p *peer.Peer, pmes *PBDHTMessage) (*PBDHTMessage, error) {
 	resp := &DHTMessage{
-		Response: true,
-		Id:       pmes.GetId(),
-		Key:      pmes.GetKey(),
+		Type: PBDHTMessage_GET_VALUE,
+		Key:  pmes.GetKey(),
 	}
-	iVal, err := dht.datastore.Get(dskey)
+	val, err := dht.GetLocal(u.Key(pmes.GetKey()))
 	if err == nil {
 		resp.Success = true
-		resp.Value = iVal.([]byte)
+		resp.Value = val
 	} else if err == ds.ErrNotFound {
 		// Check if we know any providers for the requested value
-		provs, ok := dht.providers[u.Key(pmes.GetKey())]
-		if ok && len(provs) > 0 {
-			for _, prov := range provs {
-				resp.Peers = append(resp.Peers, prov.Value)
-			}
+		provs, err := dht.getProviders(u.Key(pmes.GetKey()))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(provs) > 0 {
+			resp.Peers = provs
 			resp.Success = true
 		} else {
 			// No providers?
-			// Find closest peer on given cluster to desired key and reply with that info
-			level := pmes.GetValue()[0] // Using value field to specify cluster level
-
-			closer := dht.routes[level].NearestPeer(kb.ConvertKey(u.Key(pmes.GetKey())))
-
-			// If this peer is closer than the one from the table, return nil
-			if kb.Closer(dht.self.ID, closer.ID, u.Key(pmes.GetKey())) {
-				resp.Peers = nil
-			} else {
-				resp.Peers = []*peer.Peer{closer}
+			// Reply with the closest peers on given cluster to desired key
+			resp.Peers, err = dht.closerPeers(p, pmes)
+			if err != nil {
+				return nil, err
 			}
 		}
 	} else {
 		//temp: what other errors can a datastore return?
-		panic(err)
+		return nil, err
 	}
 
-	mes := swarm.NewMessage(p, resp.ToProtobuf())
-	dht.network.Send(mes)
+	return resp.ToProtobuf(), nil
 }
 
-// Store a value in this peer local storage
-func (dht *IpfsDHT) handlePutValue(p *peer.Peer, pmes *PBDHTMessage) {
-	dskey := ds.NewKey(pmes.GetKey())
-	err := dht.datastore.Put(dskey, pmes.GetValue())
-	if err != nil {
-		// For now, just panic, handle this better later maybe
-		panic(err)
+// Store a value in this peer local storage, if it is valid, and better
+// than the one already stored.
+func (dht *IpfsDHT) handlePutValue(p *peer.Peer, pmes *PBDHTMessage) error {
+	key := u.Key(pmes.GetKey())
+	if err := dht.Validators.VerifyRecord(key, pmes.GetValue()); err != nil {
+		u.DOut("refused record for '%s' from %s: %s", key, p.ID.Pretty(), err)
+		return msgproto.ErrBadRequest
 	}
+	return dht.putRecord(key, pmes.GetValue())
 }
 
-func (dht *IpfsDHT) handlePing(p *peer.Peer, pmes *PBDHTMessage) {
-	resp := DHTMessage{
-		Type:     pmes.GetType(),
-		Response: true,
-		Id:       pmes.GetId(),
+// putRecord stores the valid value under key, unless the record already
+// stored is better. Storing the same value again renews it.
+func (dht *IpfsDHT) putRecord(key u.Key, value []byte) error {
+	dht.putLock.Lock()
+	defer dht.putLock.Unlock()
+
+	old, err := dht.GetLocal(key)
+	if err == nil && dht.Validators.VerifyRecord(key, old) == nil {
+		best, err := dht.Validators.BestRecord(key, [][]byte{old, value})
+		if err != nil {
+			return err
+		}
+		if best == 0 && !bytes.Equal(old, value) {
+			u.DOut("keeping the stored record for '%s'", key)
+			return nil
+		}
+	} else if err != nil && err != ds.ErrNotFound {
+		return err
 	}
 
-	dht.network.Send(swarm.NewMessage(p, resp.ToProtobuf()))
+	return dht.PutLocal(key, value)
 }
 
-func (dht *IpfsDHT) handleFindPeer(p *peer.Peer, pmes *PBDHTMessage) {
+func (dht *IpfsDHT) handlePing(p *peer.Peer, pmes *PBDHTMessage) (*PBDHTMessage, error) {
 	resp := DHTMessage{
-		Type:     pmes.GetType(),
-		Id:       pmes.GetId(),
-		Response: true,
+		Type: pmes.GetType(),
 	}
-	defer func() {
-		mes := swarm.NewMessage(p, resp.ToProtobuf())
-		dht.network.Send(mes)
-	}()
-	level := pmes.GetValu()[0]
-	u.DOut("handleFindPeer: searching for '%s'", peer.ID(pmes.GetKey()).Pretty())
-	closest := dht.routes[level].NearestPeer(kb.ConvertKey(u.Key(pmes.GetKey())))
-	if closest == nil {
-		u.PErr("handleFindPeer: could not find anything.")
-		return
+
+	return resp.ToProtobuf(), nil
+}
+
+func (dht *IpfsDHT) handleFindPeer(p *peer.Peer, pmes *PBDHTMessage) (*PBDHTMessage, error) {
+	resp := DHTMessage{
+		Type: pmes.GetType(),
+		Key:  pmes.GetKey(),
 	}
 
-	if len(closest.Addresses) == 0 {
-		u.PErr("handleFindPeer: no addresses for connected peer...")
-		return
+	// a lookup of a routing table ID only wants the closest peers.
+	var found *peer.Peer
+	if pmes.GetTarget() == nil {
+		u.DOut("handleFindPeer: searching for '%s'", peer.ID(pmes.GetKey()).Pretty())
+		found, _ = dht.Find(peer.ID(pmes.GetKey()))
 	}
 
-	// If the found peer further away than this peer...
-	if kb.Closer(dht.self.ID, closest.ID, u.Key(pmes.GetKey())) {
-		return
+	// a peer looking itself up wants its neighbours instead.
+	if found != nil && !found.ID.Equal(p.ID) && len(found.Addresses) > 0 {
+		u.DOut("handleFindPeer: sending back '%s'", found.ID.Pretty())
+		resp.Peers = []*peer.Peer{found}
+		resp.Success = true
+		return resp.ToProtobuf(), nil
 	}
 
-	u.DOut("handleFindPeer: sending back '%s'", closest.ID.Pretty())
-	resp.Peers = []*peer.Peer{closest}
-	resp.Success = true
+	closer, err := dht.closerPeers(p, pmes)
+	if err != nil {
+		return nil, err
+	}
+	resp.Peers = closer
+	return resp.ToProtobuf(), nil
 }
 
-func (dht *IpfsDHT) handleGetProviders(p *peer.Peer, pmes *PBDHTMessage) {
+func (dht *IpfsDHT) handleGetProviders(p *peer.Peer, pmes *PBDHTMessage) (*PBDHTMessage, error) {
 	resp := DHTMessage{
-		Type:     PBDHTMessage_GET_PROVIDERS,
-		Key:      pmes.GetKey(),
-		Id:       pmes.GetId(),
-		Response: true,
-	}
-
-	dht.providerLock.RLock()
-	providers := dht.providers[u.Key(pmes.GetKey())]
-	dht.providerLock.RUnlock()
-	if providers == nil || len(providers) == 0 {
-		// TODO: work on tiering this
-		closer := dht.routes[0].NearestPeer(kb.ConvertKey(u.Key(pmes.GetKey())))
-		resp.Peers = []*peer.Peer{closer}
-	} else {
-		for _, prov := range providers {
-			resp.Peers = append(resp.Peers, prov.Value)
+		Type: PBDHTMessage_GET_PROVIDERS,
+		Key:  pmes.GetKey(),
+	}
+
+	providers, err := dht.getProviders(u.Key(pmes.GetKey()))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(providers) == 0 {
+		closer, err := dht.closerPeers(p, pmes)
+		if err != nil {
+			return nil, err
 		}
+		resp.Peers = closer
+	} else {
+		resp.Peers = providers
 		resp.Success = true
 	}
 
-	mes := swarm.NewMessage(p, resp.ToProtobuf())
-	dht.network.Send(mes)
+	return resp.ToProtobuf(), nil
 }
 
-type providerInfo struct {
-	Creation time.Time
-	Value    *peer.Peer
-}
+// closerPeers returns the KValue peers closest to the key of a request,
+// in the routing table the request asks about. The requester is left out,
+// as are peers without an address to give.
+func (dht *IpfsDHT) closerPeers(requester *peer.Peer, pmes *PBDHTMessage) ([]*peer.Peer, error) {
+	level, err := dht.routeLevel(pmes)
+	if err != nil {
+		return nil, err
+	}
 
-func (dht *IpfsDHT) handleAddProvider(p *peer.Peer, pmes *PBDHTMessage) {
-	//TODO: need to implement TTLs on providers
-	key := u.Key(pmes.GetKey())
-	dht.addProviderEntry(key, p)
-}
+	target := kb.ConvertKey(u.Key(pmes.GetKey()))
+	if pmes.GetTarget() != nil {
+		if len(pmes.GetTarget()) != len(target) {
+			return nil, msgproto.ErrBadRequest
+		}
+		target = kb.ID(pmes.GetTarget())
+	}
 
-// Register a handler for a specific message ID, used for getting replies
-// to certain messages (i.e. response to a GET_VALUE message)
-func (dht *IpfsDHT) ListenFor(mesid uint64, count int, timeout time.Duration) <-chan *swarm.Message {
-	lchan := make(chan *swarm.Message)
-	dht.listenLock.Lock()
-	dht.listeners[mesid] = &listenInfo{lchan, count, time.Now().Add(timeout)}
-	dht.listenLock.Unlock()
-	return lchan
-}
+	nearest := dht.routes[level].NearestPeers(target, KValue)
 
-// Unregister the given message id from the listener map
-func (dht *IpfsDHT) Unlisten(mesid uint64) {
-	dht.listenLock.Lock()
-	list, ok := dht.listeners[mesid]
-	if ok {
-		delete(dht.listeners, mesid)
+	var out []*peer.Peer
+	for _, p := range nearest {
+		if p.ID.Equal(requester.ID) || len(p.Addresses) == 0 {
+			continue
+		}
+		out = append(out, p)
 	}
-	dht.listenLock.Unlock()
-	close(list.resp)
+	return out, nil
 }
 
-// Check whether or not the dht is currently listening for mesid
-func (dht *IpfsDHT) IsListening(mesid uint64) bool {
-	dht.listenLock.RLock()
-	li, ok := dht.listeners[mesid]
-	dht.listenLock.RUnlock()
-	if time.Now().After(li.eol) {
-		dht.listenLock.Lock()
-		delete(dht.listeners, mesid)
-		dht.listenLock.Unlock()
-		return false
-	}
-	return ok
+func (dht *IpfsDHT) handleAddProvider(p *peer.Peer, pmes *PBDHTMessage) {
+	key := u.Key(pmes.GetKey())
+	dht.addProviderEntry(key, p)
 }
 
 // Stop all communications from this peer and shut down
 func (dht *IpfsDHT) Halt() {
-	dht.shutdown <- struct{}{}
+	close(dht.shutdown)
+	dht.service.Halt()
 	dht.network.Close()
 }
 
 func (dht *IpfsDHT) addProviderEntry(key u.Key, p *peer.Peer) {
 	u.DOut("Adding %s as provider for '%s'", p.Key().Pretty(), key)
-	dht.providerLock.Lock()
-	provs := dht.providers[key]
-	dht.providers[key] = append(provs, &providerInfo{time.Now(), p})
-	dht.providerLock.Unlock()
+	err := dht.providers.AddProvider(key, p)
+	if err != nil {
+		u.PErr("could not add provider: %s", err)
+	}
+}
+
+// getProviders returns the recorded providers of key, as peers.
+func (dht *IpfsDHT) getProviders(key u.Key) ([]*peer.Peer, error) {
+	recs, err := dht.providers.GetProviders(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*peer.Peer
+	for _, rec := range recs {
+		id := peer.ID(b58.Decode(rec.ID))
+		if p, _ := dht.Find(id); p != nil {
+			out = append(out, p)
+			continue
+		}
+
+		maddr, err := ma.NewMultiaddr(rec.Addr)
+		if err != nil {
+			u.PErr("provider record with bad address: %s", err)
+			continue
+		}
+
+		p := &peer.Peer{ID: id}
+		p.AddAddress(maddr)
+		out = append(out, p)
+	}
+	return out, nil
 }
 
 // NOTE: not yet finished, low priority
-func (dht *IpfsDHT) handleDiagnostic(p *peer.Peer, pmes *PBDHTMessage) {
-	dht.diaglock.Lock()
-	if dht.IsListening(pmes.GetId()) {
-		//TODO: ehhh..........
-		dht.diaglock.Unlock()
-		return
+func (dht *IpfsDHT) handleDiagnostic(p *peer.Peer, pmes *PBDHTMessage) (*PBDHTMessage, error) {
+	resp := DHTMessage{
+		Type: PBDHTMessage_DIAGNOSTIC,
 	}
-	dht.diaglock.Unlock()
 
-	seq := dht.routes[0].NearestPeers(kb.ConvertPeerID(dht.self.ID), 10)
-	listenChan := dht.ListenFor(pmes.GetId(), len(seq), time.Second*30)
+	dht.diaglock.Lock()
+	_, seen := dht.diagSeen[pmes.GetKey()]
+	dht.diagSeen[pmes.GetKey()] = time.Now()
+	dht.diaglock.Unlock()
 
-	for _, ps := range seq {
-		mes := swarm.NewMessage(ps, pmes)
-		dht.network.Send(mes)
+	// this diagnostic reached us already, through another peer.
+	if seen {
+		return resp.ToProtobuf(), nil
 	}
 
 	buf := new(bytes.Buffer)
 	di := dht.getDiagInfo()
 	buf.Write(di.Marshal())
 
+	seq := dht.routes[0].NearestPeers(kb.ConvertPeerID(dht.self.ID), 10)
+
 	// NOTE: this shouldnt be a hardcoded value
-	after := time.After(time.Second * 20)
-	count := len(seq)
-	for count > 0 {
-		select {
-		case <-after:
-			//Timeout, return what we have
-			goto out
-		case req_resp := <-listenChan:
-			pmes_out := new(PBDHTMessage)
-			err := proto.Unmarshal(req_resp.Data, pmes_out)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	defer cancel()
+	for _, r := range dht.sendRequestAll(ctx, seq, pmes) {
+		buf.Write(r.GetValue())
+	}
+
+	resp.Value = buf.Bytes()
+	return resp.ToProtobuf(), nil
+}
+
+// sendRequestAll sends pmes to all peers at once, and returns the
+// responses received before ctx is done.
+func (dht *IpfsDHT) sendRequestAll(ctx context.Context, peers []*peer.Peer, pmes *PBDHTMessage) []*PBDHTMessage {
+	responses := make(chan *PBDHTMessage, len(peers))
+	for _, p := range peers {
+		go func(p *peer.Peer) {
+			resp, err := dht.sendRequest(ctx, p, pmes)
 			if err != nil {
-				// It broke? eh, whatever, keep going
-				continue
+				u.DOut("request to %s failed: %s", p.ID.Pretty(), err)
 			}
-			buf.Write(req_resp.Data)
-			count--
-		}
+			responses <- resp
+		}(p)
 	}
 
-out:
-	resp := DHTMessage{
-		Type:     PBDHTMessage_DIAGNOSTIC,
-		Id:       pmes.GetId(),
-		Value:    buf.Bytes(),
-		Response: true,
+	var out []*PBDHTMessage
+	for range peers {
+		if resp := <-responses; resp != nil {
+			out = append(out, resp)
+		}
 	}
-
-	mes := swarm.NewMessage(p, resp.ToProtobuf())
-	dht.network.Send(mes)
+	return out
 }
 
 // getValueSingle simply performs the get value RPC with the given parameters
-func (dht *IpfsDHT) getValueSingle(p *peer.Peer, key u.Key, timeout time.Duration, level int) (*PBDHTMessage, error) {
-
+func (dht *IpfsDHT) getValueSingle(ctx context.Context, p *peer.Peer, key u.Key, level int) (*PBDHTMessage, error) {
 	pmes := DHTMessage{
-		Type: PBDHTMessage_GET_VALUE,
-		Key:  string(key),
+		Type:  PBDHTMessage_GET_VALUE,
+		Key:   string(key),
 		Value: []byte{byte(level)},
-		Id:   GenerateMessageID(),
-	}
-	response_chan := dht.ListenFor(pmes.Id, 1, time.Minute)
-
-	mes := swarm.NewMessage(p, pmes.ToProtobuf())
-	dht.network.Send(mes)
-
-	// Wait for either the response or a timeout
-	timeup := time.After(timeout)
-	select {
-	case <-timeup:
-		dht.Unlisten(pmes.Id)
-		return nil, u.ErrTimeout
-	case resp, ok := <-response_chan:
-		if !ok {
-			u.PErr("response channel closed before timeout, please investigate.")
-			return nil, u.ErrTimeout
-		}
-		pmes_out := new(PBDHTMessage)
-		err := proto.Unmarshal(resp.Data, pmes_out)
-		if err != nil {
-			return nil, err
-		}
-		return pmes_out, nil
 	}
+
+	return dht.sendRequest(ctx, p, pmes.ToProtobuf())
 }
 
 // TODO: Im not certain on this implementation, we get a list of peers/providers
 // from someone what do we do with it? Connect to each of them? randomly pick
 // one to get the value from? Or just connect to one at a time until we get a
 // successful connection and request the value from it?
-func (dht *IpfsDHT) getFromPeerList(key u.Key, timeout time.Duration,
+func (dht *IpfsDHT) getFromPeerList(ctx context.Context, key u.Key,
 	peerlist []*PBDHTMessage_PBPeer, level int) ([]byte, error) {
-	for _, prov := range peerlist {
-		p, _ := dht.Find(peer.ID(pinfo.GetId()))
-		if p == nil {
-			maddr, err := ma.NewMultiaddr(pinfo.GetAddr())
-			if err != nil {
-				u.PErr("getValue error: %s", err)
-				continue
-			}
-			p, err = dht.Connect(maddr)
-			if err != nil {
-				u.PErr("getValue error: %s", err)
-				continue
-			}
+	for _, p := range dht.peersFromInfo(peerlist) {
+		p, err := dht.ensureConnected(p)
+		if err != nil {
+			u.PErr("getValue error: %s", err)
+			continue
 		}
-		pmes, err := dht.getValueSingle(p, key, timeout, level)
+		pmes, err := dht.getValueSingle(ctx, p, key, level)
 		if err != nil {
 			u.DErr("getFromPeers error: %s", err)
 			continue
@@ -539,23 +569,55 @@ func (dht *IpfsDHT) getFromPeerList(key u.Key, timeout time.Duration,
 	return nil, u.ErrNotFound
 }
 
+// GetLocal returns the value stored here under key. A missing or expired
+// value is ds.ErrNotFound.
 func (dht *IpfsDHT) GetLocal(key u.Key) ([]byte, error) {
-	v, err := dht.datastore.Get(ds.NewKey(string(key)))
-	if err != nil {
-		return nil, err
-	}
-	return v.([]byte), nil
+	return dht.records.Get(key)
 }
 
+// PutLocal stores value here under key, for RecordValidity.
 func (dht *IpfsDHT) PutLocal(key u.Key, value []byte) error {
-	return dht.datastore.Put(ds.NewKey(string(key)), value)
+	return dht.records.Put(key, value)
 }
 
+// Update records that p was seen. If its bucket is full, the least
+// recently seen peer of the bucket is pinged, and replaced if it does not
+// respond.
 func (dht *IpfsDHT) Update(p *peer.Peer) {
-	removed := dht.routes[0].Update(p)
-	if removed != nil {
-		dht.network.Drop(removed)
+	oldest := dht.routes[0].Update(p)
+	if oldest == nil {
+		return
+	}
+
+	dht.checkLock.Lock()
+	_, checking := dht.checking[oldest.Key()]
+	dht.checking[oldest.Key()] = struct{}{}
+	dht.checkLock.Unlock()
+
+	if !checking {
+		go dht.checkPeer(oldest)
+	}
+}
+
+// checkPeer pings p, keeping it in the routing table if it responds, and
+// removing it otherwise. The connection to p is left to the swarm.
+func (dht *IpfsDHT) checkPeer(p *peer.Peer) {
+	defer func() {
+		dht.checkLock.Lock()
+		delete(dht.checking, p.Key())
+		dht.checkLock.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), CheckTimeout)
+	defer cancel()
+
+	if err := dht.Ping(ctx, p); err == nil {
+		dht.routes[0].Update(p)
+		return
 	}
+
+	u.DOut("evicting unresponsive peer %s", p.ID.Pretty())
+	dht.routes[0].Remove(p.ID)
 }
 
 // Look for a peer with a given ID connected to this dht
@@ -569,29 +631,23 @@ func (dht *IpfsDHT) Find(id peer.ID) (*peer.Peer, *kb.RoutingTable) {
 	return nil, nil
 }
 
-func (dht IpfsDHT) findPeerSingle(p *peer.Peer, id peer.ID, timeout time.Duration, level int) (*PDPBDHTMessage, error) {
+func (dht *IpfsDHT) findPeerSingle(ctx context.Context, p *peer.Peer, id peer.ID, level int) (*PBDHTMessage, error) {
 	pmes := DHTMessage{
-		Type:   PBDHTMessage,
-		Key:    string(id),
-		Id:     GenerateMessageID(),
-		Value:  []byte{byte(level)},
+		Type:  PBDHTMessage_FIND_NODE,
+		Key:   string(id),
+		Value: []byte{byte(level)},
 	}
 
-	mes := swarm.NewMessage(p, pmes.ToProtobuf())
-	listenChan := dht.ListenFor(pmes.Id, 1, time.Minute)
-	dht.network.Send(mes)
-	after := time.After(timeout)
-	select {
-	case <-after:
-		dht.Unlisten(pmes.Id)
-		return nil, u.ErrTimeout
-	case resp := <-listenChan:
-		pmes_out := new(PBDHTMessage)
-		err := proto.Unmarshal(resp.Data, pmes_out)
-		if err != nil {
-			return nil, err
-		}
+	return dht.sendRequest(ctx, p, pmes.ToProtobuf())
+}
 
-		return pmes_out, nil
+// findIDSingle asks p for the peers closest to the routing table ID target.
+func (dht *IpfsDHT) findIDSingle(ctx context.Context, p *peer.Peer, target kb.ID, level int) (*PBDHTMessage, error) {
+	pmes := DHTMessage{
+		Type:   PBDHTMessage_FIND_NODE,
+		Value:  []byte{byte(level)},
+		Target: target,
 	}
+
+	return dht.sendRequest(ctx, p, pmes.ToProtobuf())
 }
@@ -1,19 +1,61 @@
 package dht
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"testing"
+	ci "../../crypto"
 	peer "../../peer"
+	routing "../../routing"
 	swarm "../../swarm"
 	ma "github.com/multiformats/go-multiaddr"
+	ds "github.com/ipfs/go-datastore"
 	u "../../util"
 
 	"time"
 	"fmt"
 )
 
+func setupPeer(t *testing.T, addr *ma.Multiaddr) *peer.Peer {
+	sk, pk, err := ci.GenerateKeyPair(ci.KeyType_Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := peer.IDFromPubKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &peer.Peer{ID: id, PrivKey: sk, PubKey: pk}
+	p.AddAddress(addr)
+	return p
+}
+
+// testValidator accepts values under /v/ unless they start with "bad", and
+// prefers the greatest.
+var testValidator = &routing.Validator{
+	Validate: func(key u.Key, value []byte) error {
+		if bytes.HasPrefix(value, []byte("bad")) {
+			return errors.New("bad value")
+		}
+		return nil
+	},
+	Select: func(key u.Key, values [][]byte) (int, error) {
+		best := 0
+		for i, v := range values {
+			if bytes.Compare(v, values[best]) > 0 {
+				best = i
+			}
+		}
+		return best, nil
+	},
+}
+
 func setupDHT(n int, t *testing.T) ([]*ma.Multiaddr, []*peer.Peer, []*IpfsDHT) {
 	var addrs []*ma.Multiaddr
-	for i := 0; i < 4; i++ {
+	for i := 0; i < n; i++ {
 		a, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", 5000+i))
 		if err != nil {
 			t.Fatal(err)
@@ -22,21 +64,19 @@ func setupDHT(n int, t *testing.T) ([]*ma.Multiaddr, []*peer.Peer, []*IpfsDHT) {
 	}
 
 	var peers []*peer.Peer
-	for i := 0; i < 4; i++ {
-		p := new(peer.Peer)
-		p.AddAddress(addrs[i])
-		p.ID = peer.ID([]byte(fmt.Sprintf("peer_%d", i)))
-		peers = append(peers, p)
+	for i := 0; i < n; i++ {
+		peers = append(peers, setupPeer(t, addrs[i]))
 	}
 
 	var dhts []*IpfsDHT
-	for i := 0; i < 4; i++ {
+	for i := 0; i < n; i++ {
 		net := swarm.NewSwarm(peers[i])
 		err := net.Listen()
 		if err != nil {
 			t.Fatal(err)
 		}
-		d := NewDHT(peers[i], net)
+		d := NewDHT(peers[i], net, ds.NewMapDatastore())
+		d.Validators["v"] = testValidator
 		dhts = append(dhts, d)
 		d.Start()
 	}
@@ -45,117 +85,152 @@ func setupDHT(n int, t *testing.T) ([]*ma.Multiaddr, []*peer.Peer, []*IpfsDHT) {
 
 func TestPing(t *testing.T) {
 	u.Debug = false
-	addr_a, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/2222")
+	addrs, peers, dhts := setupDHT(2, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	_, err := dhts[0].Connect(addrs[1])
 	if err != nil {
 		t.Fatal(err)
 	}
-	addr_b, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/5678")
+
+	//Test that we can ping the node
+	err = dhts[0].Ping(ctx, peers[1])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	peer_a := new(peer.Peer)
-	peer_a.AddAddress(addr_a)
-	peer_a.ID = peer.ID([]byte("peer_a"))
+	dhts[0].Halt()
+	dhts[1].Halt()
+}
+
+func TestValueGetSet(t *testing.T) {
+	u.Debug = false
+	addrs, _, dhts := setupDHT(2, t)
 
-	peer_b := new(peer.Peer)
-	peer_b.AddAddress(addr_b)
-	peer_b.ID = peer.ID([]byte("peer_b"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
 
-	neta := swarm.NewSwarm(peer_a)
-	err = neta.Listen()
+	_, err := dhts[0].Connect(addrs[1])
 	if err != nil {
 		t.Fatal(err)
 	}
-	dbt_a := NewDHT(peer_a, neta)
 
-	netb := swarm.NewSwarm(peer_b)
-	err = netb.Listen()
+	err = dhts[0].PutValue(ctx, "/v/hello", []byte("world"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	dbt_b := NewDHT(peer_b, netb)
 
-	dht_a.Start()
-	dht_b.Start()
+	time.Sleep(time.Millisecond * 60)
 
-	_, err = dht_a.Connect(addr_b)
+	val, err := dhts[0].GetValue(ctx, "/v/hello")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	//Test that we can ping the node
-	err = dht_a.Ping(peer_b, time.Second*2)
-	if err != nil {
-		t.Fatal(err)
+	if string(val) != "world" {
+		t.Fatalf("Expected 'world' got %s", string(val))
 	}
 
-	dht_a.Halt()
-	dht_b.Halt()
-}
+	err = dhts[0].PutValue(ctx, "hello", []byte("plain"))
+	if err != routing.ErrNoValidator {
+		t.Fatal("put a plain key without opting in, got", err)
+	}
 
-func TestValueGetSet(t *testing.T) {
-	u.Debug = false
-	addr_a, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/1235")
+	// plain keys are accepted once opted in to.
+	dhts[0].Validators[""] = routing.PlainValidator
+	dhts[1].Validators[""] = routing.PlainValidator
+
+	err = dhts[0].PutValue(ctx, "hello", []byte("plain"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	addr_b, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/5679")
+
+	time.Sleep(time.Millisecond * 60)
+
+	val, err = dhts[1].GetValue(ctx, "hello")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	peer_a := new(peer.Peer)
-	peer_a.AddAddress(addr_a)
-	peer_a.ID = peer.ID([]byte("peer_a"))
+	if string(val) != "plain" {
+		t.Fatalf("Expected 'plain' got %s", string(val))
+	}
 
-	peer_b := new(peer.Peer)
-	peer_b.AddAddress(addr_b)
-	peer_b.ID = peer.ID([]byte("peer_b"))
+	err = dhts[0].PutValue(ctx, "/x/hello", []byte("world"))
+	if err != routing.ErrNoValidator {
+		t.Fatal("put a value without a validator, got", err)
+	}
+
+	dhts[0].Halt()
+	dhts[1].Halt()
+}
+
+func TestValueConflicts(t *testing.T) {
+	u.Debug = false
+	addrs, peers, dhts := setupDHT(2, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
 
-	neta := swarm.NewSwarm(peer_a)
-	err = neta.Listen()
+	_, err := dhts[0].Connect(addrs[1])
 	if err != nil {
 		t.Fatal(err)
 	}
-	dbt_a := NewDHT(neta)
 
-	netb := swarm.NewSwarm(peer_b)
-	err = netb.Listen()
+	err = dhts[0].PutValue(ctx, "/v/hello", []byte("world2"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	dbt_b := NewDHT(netb)
 
-	dht_a.Start()
-	dht_b.Start()
+	// neither a worse nor an invalid record replaces it.
+	err = dhts[0].putValueToNetwork(ctx, peers[1], "/v/hello", []byte("world1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dhts[0].putValueToNetwork(ctx, peers[1], "/v/hello", []byte("bad"))
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	errsa := dht_a.network.GetChan().Errors
-	errsb := dht_b.network.GetChan().Errors
-	go func() {
-		select {
-		case err := <-errsa:
-			t.Fatal(err)
-		case err := <-errsb:
-			t.Fatal(err)
-		}
-	}()
+	time.Sleep(time.Millisecond * 60)
 
-	_, err = dht_a.Connect(addr_b)
+	val, err := dhts[1].GetLocal("/v/hello")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if string(val) != "world2" {
+		t.Fatalf("Expected 'world2' stored, got %s", string(val))
+	}
 
-	dht_a.PutValue("hello", []byte("world"))
-
-	val, err := dht_a.GetValue("hello", time.Second*2)
+	// the best of our value and the network's wins.
+	err = dhts[0].PutLocal("/v/hello", []byte("world3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err = dhts[0].GetValue(ctx, "/v/hello")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if string(val) != "world3" {
+		t.Fatalf("Expected 'world3' got %s", string(val))
+	}
 
-	if string(val) != "world" {
-		t.Fatalf("Expected 'world' got %s", string(val))
+	err = dhts[0].PutLocal("/v/hello", []byte("world0"))
+	if err != nil {
+		t.Fatal(err)
 	}
+	val, err = dhts[0].GetValue(ctx, "/v/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "world2" {
+		t.Fatalf("Expected 'world2' got %s", string(val))
+	}
+
+	dhts[0].Halt()
+	dhts[1].Halt()
 }
 
 func TestProvides(t *testing.T) {
@@ -163,17 +238,20 @@ func TestProvides(t *testing.T) {
 
 	addrs, _, dhts := setupDHT(4, t)
 
-	_, err := dhts[0].Connect(addrs[0])
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	_, err := dhts[0].Connect(addrs[1])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err := dhts[1].Connect(addrs[1])
+	_, err = dhts[1].Connect(addrs[2])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err := dhts[2].Connect(addrs[2])
+	_, err = dhts[1].Connect(addrs[3])
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -183,14 +261,14 @@ func TestProvides(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = dhts[3].Provide(u.Key("hello"))
+	err = dhts[3].Provide(ctx, u.Key("hello"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	time.Sleep(time.Millisecond * 60)
 
-	provs, err := dhts[0].FindProviders(u.Key("hello"), time.Second)
+	provs, err := dhts[0].FindProviders(ctx, u.Key("hello"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -208,6 +286,9 @@ func TestLayeredGet(t *testing.T) {
 	u.Debug = false
 	addrs, _, dhts := setupDHT(4, t)
 
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
 	_, err := dhts[0].Connect(addrs[1])
 	if err != nil {
 		t.Fatalf("Failed to connect: %s", err)
@@ -223,24 +304,24 @@ func TestLayeredGet(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = dhts[3].PutLocal(u.Key("hello"), []byte("world"))
+	err = dhts[3].PutLocal(u.Key("/v/hello"), []byte("world"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = dhts[3].Provide(u.Key("hello"))
+	err = dhts[3].Provide(ctx, u.Key("/v/hello"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	time.Sleep(time.Millisecond * 60)
 
-	val, err := dhts[0].GetValue(u.Key("hello"), time.Second)
+	val, err := dhts[0].GetValue(ctx, u.Key("/v/hello"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if string(val) != "hello" {
+	if string(val) != "world" {
 		t.Fatal("Got incorrect value.")
 	}
 
@@ -253,6 +334,9 @@ func TestFindPeer(t *testing.T) {
 	u.Debug = false
 	addrs, peers, dhts := setupDHT(4, t)
 
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
 	_, err := dhts[0].Connect(addrs[1])
 	if err != nil {
 		t.Fatal(err)
@@ -268,7 +352,7 @@ func TestFindPeer(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	p, err := dhts[0].FindPeer(peers[2].ID, time.Second)
+	p, err := dhts[0].FindPeer(ctx, peers[2].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -286,3 +370,77 @@ func TestFindPeer(t *testing.T) {
 	}
 }
 
+// The peers are connected in a chain, so finding the last one takes a
+// lookup through all the others.
+func TestFindPeerChain(t *testing.T) {
+	u.Debug = false
+	addrs, peers, dhts := setupDHT(4, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		_, err := dhts[i].Connect(addrs[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := dhts[0].FindPeer(ctx, peers[3].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.ID.Equal(peers[3].ID) {
+		t.Fatal("Did'nt find expected peer.")
+	}
+
+	for i := 0; i < 4; i++ {
+		dhts[i].Halt()
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	u.Debug = false
+	addrs, peers, dhts := setupDHT(4, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	_, err := dhts[1].Connect(addrs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dhts[1].Connect(addrs[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dhts[0].Bootstrap(ctx, []*peer.Peer{peers[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the self lookup finds the peers behind the bootstrap peer.
+	for _, p := range peers[1:] {
+		if found, _ := dhts[0].Find(p.ID); found == nil {
+			t.Errorf("peer %s not in routing table after bootstrap", p.ID.Pretty())
+		}
+	}
+
+	if stale := dhts[0].routes[0].StaleBuckets(RefreshInterval); len(stale) != 0 {
+		t.Error("buckets left stale after bootstrap:", stale)
+	}
+
+	// the wrong ID at an address is refused.
+	impostor := &peer.Peer{ID: peer.ID("impostor")}
+	impostor.AddAddress(addrs[3])
+	if err := dhts[2].Bootstrap(ctx, []*peer.Peer{impostor}); err != ErrNoBootstrapPeers {
+		t.Error("expected ErrNoBootstrapPeers, got", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		dhts[i].Halt()
+	}
+}
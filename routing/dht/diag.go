@@ -5,7 +5,6 @@ import (
 	"time"
 
 	peer "../../peer"
-	"github.com/jbenet/go-ipfs/namesys"
 )
 
 type connDiagInfo struct {
@@ -1,28 +1,34 @@
 package dht
 
 import (
+	"context"
 	"../../swarm"
 	"testing"
-	"github.com/btcsuite/btcd/peer"
+	peer "../../peer"
 
 	u "../../util"
+	kb "../kbucket"
 	"time"
-	"fmt"
+
+	ds "github.com/ipfs/go-datastore"
 )
 
 // fauxNet is a standin for a swarm.Network in order to more easily recreate
 // different testing scenarios
 type fauxNet struct {
-	Chan *swarm.Swarm
+	Chan *swarm.Chan
 
 	swarm.Network
 
 	handlers []mesHandlerFunc
+
+	// peers passed to Drop
+	dropped []*peer.Peer
 }
 
 type mesHandlerFunc func(*swarm.Message) *swarm.Message
 
-func newFautNext() *fauxNet {
+func newFauxNet() *fauxNet {
 	fn := new(fauxNet)
 	fn.Chan = swarm.NewChan(8)
 
@@ -31,15 +37,12 @@ func newFautNext() *fauxNet {
 
 func (f *fauxNet) Listen() error {
 	go func() {
-		for {
-			select {
-			case in := <-f.Chan.Outging:
-				for _, h := range f.handlers {
-					reply := h(in)
-					if reply := nil {
-						f.Chan.Incoming <- reply
-						break
-					}
+		for in := range f.Chan.Outgoing {
+			for _, h := range f.handlers {
+				reply := h(in)
+				if reply != nil {
+					f.Chan.Incoming <- reply
+					break
 				}
 			}
 		}
@@ -51,25 +54,100 @@ func (f *fauxNet) AddHandler(fn func(*swarm.Message) *swarm.Message) {
 	f.handlers = append(f.handlers, fn)
 }
 
-func (f *fauxNet) Send(mes *swarm.Message) {
-	
+func (f *fauxNet) GetChannel(swarm.PBWrapper_MessageType) *swarm.Chan {
+	return f.Chan
+}
+
+func (f *fauxNet) GetErrChan() chan error {
+	return f.Chan.Errors
+}
+
+// Find pretends to be connected to every peer.
+func (f *fauxNet) Find(key u.Key) *peer.Peer {
+	return &peer.Peer{ID: peer.ID(key)}
+}
+
+func (f *fauxNet) Drop(p *peer.Peer) error {
+	f.dropped = append(f.dropped, p)
+	return nil
+}
+
+func TestCheckPeerEvicts(t *testing.T) {
+	fn := newFauxNet()
+	fn.Listen()
+
+	local := new(peer.Peer)
+	local.ID = peer.ID([]byte("test_peer"))
+
+	d := NewDHT(local, fn, ds.NewMapDatastore())
+	d.Start()
+
+	// the peer never answers the ping.
+	other := new(peer.Peer)
+	other.ID = peer.ID([]byte("other_peer"))
+	d.Update(other)
+
+	timeout := CheckTimeout
+	CheckTimeout = time.Millisecond * 50
+	defer func() { CheckTimeout = timeout }()
+
+	d.checkPeer(other)
+
+	if p, _ := d.Find(other.ID); p != nil {
+		t.Error("unresponsive peer kept in the routing table")
+	}
+	if len(fn.dropped) != 0 {
+		t.Error("evicting a peer dropped its connection")
+	}
 }
 
 func TestGetFailure(t *testing.T) {
-	fn := newFautNext()
+	fn := newFauxNet()
 	fn.Listen()
 
 	local := new(peer.Peer)
 	local.ID = peer.ID([]byte("test_peer"))
 
-	d := NewDHT(local, fn)
+	d := NewDHT(local, fn, ds.NewMapDatastore())
 
 	d.Start()
 
-	b, err := d.GetValue(u.Key("test"), time.Second)
-	if err != nil {
-		t.Fatal(err)
+	// nobody is there to ask.
+	_, err := d.GetValue(context.Background(), u.Key("test"))
+	if err != kb.ErrLookupFailure {
+		t.Fatalf("expected lookup failure, got: %v", err)
 	}
 
-	fmt.Println(b)
+	// the only peer known never answers.
+	other := new(peer.Peer)
+	other.ID = peer.ID([]byte("other_peer"))
+	d.Update(other)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	_, err = d.GetValue(ctx, u.Key("test"))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected timeout, got: %v", err)
+	}
+
+	// nor when the caller gives up.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+	}()
+
+	_, err = d.GetValue(ctx, u.Key("test"))
+	if err != context.Canceled {
+		t.Fatalf("expected cancel, got: %v", err)
+	}
+
+	// the canceled queries let go of their requests.
+	for i := 0; d.service.Pending() != 0; i++ {
+		if i > 10 {
+			t.Fatalf("%d requests still waiting for a response", d.service.Pending())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
 }
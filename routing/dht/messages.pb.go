@@ -1,38 +1,31 @@
-// Code generated by protoc-gen-go. DO NOT EDIT.
+// Code generated by protoc-gen-go.
 // source: messages.proto
+// DO NOT EDIT!
 
 package dht
 
 import proto "github.com/golang/protobuf/proto"
-import fmt "fmt"
+import json "encoding/json"
 import math "math"
 
-// Reference imports to suppress errors if they are not otherwise used.
+// Reference proto, json, and math imports to suppress error if they are not otherwise used.
 var _ = proto.Marshal
-var _ = fmt.Errorf
+var _ = &json.SyntaxError{}
 var _ = math.Inf
 
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the proto package it is being compiled against.
-// A compilation error at this line likely means your copy of the
-// proto package needs to be updated.
-const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
-
-type DHTMessage_MessageType int32
+type PBDHTMessage_MessageType int32
 
 const (
-	DHTMessage_PUT_VALUE     DHTMessage_MessageType = 0
-	DHTMessage_GET_VALUE     DHTMessage_MessageType = 1
-	DHTMessage_ADD_PROVIDER  DHTMessage_MessageType = 2
-	DHTMessage_GET_PROVIDERS DHTMessage_MessageType = 3
-	// Unique ID of this message, used to match queries with responses
-	DHTMessage_FIND_NODE DHTMessage_MessageType = 4
-	// Signals whether or not this message is a response to another message
-	DHTMessage_PING       DHTMessage_MessageType = 5
-	DHTMessage_DIAGNOSTIC DHTMessage_MessageType = 6
+	PBDHTMessage_PUT_VALUE     PBDHTMessage_MessageType = 0
+	PBDHTMessage_GET_VALUE     PBDHTMessage_MessageType = 1
+	PBDHTMessage_ADD_PROVIDER  PBDHTMessage_MessageType = 2
+	PBDHTMessage_GET_PROVIDERS PBDHTMessage_MessageType = 3
+	PBDHTMessage_FIND_NODE     PBDHTMessage_MessageType = 4
+	PBDHTMessage_PING          PBDHTMessage_MessageType = 5
+	PBDHTMessage_DIAGNOSTIC    PBDHTMessage_MessageType = 6
 )
 
-var DHTMessage_MessageType_name = map[int32]string{
+var PBDHTMessage_MessageType_name = map[int32]string{
 	0: "PUT_VALUE",
 	1: "GET_VALUE",
 	2: "ADD_PROVIDER",
@@ -41,7 +34,7 @@ var DHTMessage_MessageType_name = map[int32]string{
 	5: "PING",
 	6: "DIAGNOSTIC",
 }
-var DHTMessage_MessageType_value = map[string]int32{
+var PBDHTMessage_MessageType_value = map[string]int32{
 	"PUT_VALUE":     0,
 	"GET_VALUE":     1,
 	"ADD_PROVIDER":  2,
@@ -51,121 +44,103 @@ var DHTMessage_MessageType_value = map[string]int32{
 	"DIAGNOSTIC":    6,
 }
 
-func (x DHTMessage_MessageType) Enum() *DHTMessage_MessageType {
-	p := new(DHTMessage_MessageType)
+func (x PBDHTMessage_MessageType) Enum() *PBDHTMessage_MessageType {
+	p := new(PBDHTMessage_MessageType)
 	*p = x
 	return p
 }
-func (x DHTMessage_MessageType) String() string {
-	return proto.EnumName(DHTMessage_MessageType_name, int32(x))
+func (x PBDHTMessage_MessageType) String() string {
+	return proto.EnumName(PBDHTMessage_MessageType_name, int32(x))
 }
-func (x *DHTMessage_MessageType) UnmarshalJSON(data []byte) error {
-	value, err := proto.UnmarshalJSONEnum(DHTMessage_MessageType_value, data, "DHTMessage_MessageType")
+func (x *PBDHTMessage_MessageType) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(PBDHTMessage_MessageType_value, data, "PBDHTMessage_MessageType")
 	if err != nil {
 		return err
 	}
-	*x = DHTMessage_MessageType(value)
+	*x = PBDHTMessage_MessageType(value)
 	return nil
 }
-func (DHTMessage_MessageType) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_messages_3256a5547462db33, []int{0, 0}
-}
 
-type DHTMessage struct {
-	Type  *DHTMessage_MessageType `protobuf:"varint,1,req,name=type,enum=dht.DHTMessage_MessageType" json:"type,omitempty"`
-	Key   *string                 `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
-	Value []byte                  `protobuf:"bytes,3,opt,name=value" json:"value,omitempty"`
-	// Unique ID of this message, used to match queries with responses
-	Id *uint64 `protobuf:"varint,4,req,name=id" json:"id,omitempty"`
-	// Signals whether or not this message is a response to another message
-	Response             *bool    `protobuf:"varint,5,opt,name=response" json:"response,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+type PBDHTMessage struct {
+	Type             *PBDHTMessage_MessageType `protobuf:"varint,1,req,name=type,enum=dht.PBDHTMessage_MessageType" json:"type,omitempty"`
+	Key              *string                   `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Value            []byte                    `protobuf:"bytes,3,opt,name=value" json:"value,omitempty"`
+	Success          *bool                     `protobuf:"varint,6,opt,name=success" json:"success,omitempty"`
+	Peers            []*PBDHTMessage_PBPeer    `protobuf:"bytes,7,rep,name=peers" json:"peers,omitempty"`
+	Target           []byte                    `protobuf:"bytes,8,opt,name=target" json:"target,omitempty"`
+	XXX_unrecognized []byte                    `json:"-"`
 }
 
-func (m *DHTMessage) Reset()         { *m = DHTMessage{} }
-func (m *DHTMessage) String() string { return proto.CompactTextString(m) }
-func (*DHTMessage) ProtoMessage()    {}
-func (*DHTMessage) Descriptor() ([]byte, []int) {
-	return fileDescriptor_messages_3256a5547462db33, []int{0}
-}
-func (m *DHTMessage) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_DHTMessage.Unmarshal(m, b)
-}
-func (m *DHTMessage) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_DHTMessage.Marshal(b, m, deterministic)
-}
-func (dst *DHTMessage) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_DHTMessage.Merge(dst, src)
-}
-func (m *DHTMessage) XXX_Size() int {
-	return xxx_messageInfo_DHTMessage.Size(m)
-}
-func (m *DHTMessage) XXX_DiscardUnknown() {
-	xxx_messageInfo_DHTMessage.DiscardUnknown(m)
-}
+func (m *PBDHTMessage) Reset()         { *m = PBDHTMessage{} }
+func (m *PBDHTMessage) String() string { return proto.CompactTextString(m) }
+func (*PBDHTMessage) ProtoMessage()    {}
 
-var xxx_messageInfo_DHTMessage proto.InternalMessageInfo
-
-func (m *DHTMessage) GetType() DHTMessage_MessageType {
+func (m *PBDHTMessage) GetType() PBDHTMessage_MessageType {
 	if m != nil && m.Type != nil {
 		return *m.Type
 	}
-	return DHTMessage_PUT_VALUE
+	return PBDHTMessage_PUT_VALUE
 }
 
-func (m *DHTMessage) GetKey() string {
+func (m *PBDHTMessage) GetKey() string {
 	if m != nil && m.Key != nil {
 		return *m.Key
 	}
 	return ""
 }
 
-func (m *DHTMessage) GetValue() []byte {
+func (m *PBDHTMessage) GetValue() []byte {
 	if m != nil {
 		return m.Value
 	}
 	return nil
 }
 
-func (m *DHTMessage) GetId() uint64 {
+func (m *PBDHTMessage) GetSuccess() bool {
+	if m != nil && m.Success != nil {
+		return *m.Success
+	}
+	return false
+}
+
+func (m *PBDHTMessage) GetPeers() []*PBDHTMessage_PBPeer {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+func (m *PBDHTMessage) GetTarget() []byte {
+	if m != nil {
+		return m.Target
+	}
+	return nil
+}
+
+type PBDHTMessage_PBPeer struct {
+	Id               *string `protobuf:"bytes,1,req,name=id" json:"id,omitempty"`
+	Addr             *string `protobuf:"bytes,2,req,name=addr" json:"addr,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *PBDHTMessage_PBPeer) Reset()         { *m = PBDHTMessage_PBPeer{} }
+func (m *PBDHTMessage_PBPeer) String() string { return proto.CompactTextString(m) }
+func (*PBDHTMessage_PBPeer) ProtoMessage()    {}
+
+func (m *PBDHTMessage_PBPeer) GetId() string {
 	if m != nil && m.Id != nil {
 		return *m.Id
 	}
-	return 0
+	return ""
 }
 
-func (m *DHTMessage) GetResponse() bool {
-	if m != nil && m.Response != nil {
-		return *m.Response
+func (m *PBDHTMessage_PBPeer) GetAddr() string {
+	if m != nil && m.Addr != nil {
+		return *m.Addr
 	}
-	return false
+	return ""
 }
 
 func init() {
-	proto.RegisterType((*DHTMessage)(nil), "dht.DHTMessage")
-	proto.RegisterEnum("dht.DHTMessage_MessageType", DHTMessage_MessageType_name, DHTMessage_MessageType_value)
-}
-
-func init() { proto.RegisterFile("messages.proto", fileDescriptor_messages_3256a5547462db33) }
-
-var fileDescriptor_messages_3256a5547462db33 = []byte{
-	// 247 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x8d, 0xdf, 0x4a, 0xc3, 0x30,
-	0x14, 0xc6, 0x4d, 0x9a, 0x49, 0x77, 0xdc, 0x4a, 0x3c, 0x78, 0x11, 0xf4, 0x26, 0xec, 0x2a, 0x57,
-	0x15, 0x7c, 0x83, 0x62, 0x6a, 0x0d, 0x68, 0x5b, 0xb2, 0x6e, 0xb7, 0x65, 0xd0, 0xe0, 0x86, 0x7f,
-	0x5a, 0x96, 0x2a, 0xf4, 0x1d, 0x7c, 0x68, 0xa9, 0x73, 0xea, 0xd5, 0x39, 0xbf, 0xf3, 0xfd, 0x0e,
-	0x1f, 0x44, 0xaf, 0xce, 0xfb, 0xcd, 0x93, 0xf3, 0x71, 0xb7, 0x6f, 0xfb, 0x16, 0x83, 0x66, 0xdb,
-	0x2f, 0x3e, 0x29, 0x80, 0xbe, 0xaf, 0x1e, 0x0f, 0x11, 0x5e, 0x03, 0xeb, 0x87, 0xce, 0x09, 0x22,
-	0xa9, 0x8a, 0x6e, 0xae, 0xe2, 0x66, 0xdb, 0xc7, 0x7f, 0x71, 0xfc, 0x33, 0xab, 0xa1, 0x73, 0xf6,
-	0x5b, 0x44, 0x0e, 0xc1, 0xb3, 0x1b, 0x04, 0x95, 0x44, 0x4d, 0xed, 0xb8, 0xe2, 0x05, 0x4c, 0x3e,
-	0x36, 0x2f, 0xef, 0x4e, 0x04, 0x92, 0xa8, 0x99, 0x3d, 0x00, 0x46, 0x40, 0x77, 0x8d, 0x60, 0x92,
-	0x2a, 0x66, 0xe9, 0xae, 0xc1, 0x4b, 0x08, 0xf7, 0xce, 0x77, 0xed, 0x9b, 0x77, 0x62, 0x22, 0x89,
-	0x0a, 0xed, 0x2f, 0x2f, 0x06, 0x38, 0xfb, 0x57, 0x84, 0x73, 0x98, 0x96, 0xab, 0xaa, 0x5e, 0x27,
-	0x0f, 0xab, 0x94, 0x9f, 0x8c, 0x98, 0xa5, 0x47, 0x24, 0xc8, 0x61, 0x96, 0x68, 0x5d, 0x97, 0xb6,
-	0x58, 0x1b, 0x9d, 0x5a, 0x4e, 0xf1, 0x1c, 0xe6, 0xa3, 0x70, 0xbc, 0x2c, 0x79, 0x30, 0xfe, 0xdc,
-	0x99, 0x5c, 0xd7, 0x79, 0xa1, 0x53, 0xce, 0x30, 0x04, 0x56, 0x9a, 0x3c, 0xe3, 0x13, 0x8c, 0x00,
-	0xb4, 0x49, 0xb2, 0xbc, 0x58, 0x56, 0xe6, 0x96, 0x9f, 0x7e, 0x05, 0x00, 0x00, 0xff, 0xff, 0xa9,
-	0x96, 0xf8, 0xfa, 0x24, 0x01, 0x00, 0x00,
+	proto.RegisterEnum("dht.PBDHTMessage_MessageType", PBDHTMessage_MessageType_name, PBDHTMessage_MessageType_value)
 }
@@ -0,0 +1,209 @@
+package dht
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+
+	peer "../../peer"
+	u "../../util"
+)
+
+// ProvideValidity is how long a provider record is kept, unless the
+// provider announces itself again.
+var ProvideValidity = time.Hour * 24
+
+// providersPrefix is the datastore prefix provider records are kept under.
+const providersPrefix = "/dht/providers/"
+
+// providerRecord says a peer can provide the value of a key, until it
+// expires.
+type providerRecord struct {
+	ID      string // b58 encoded peer.ID
+	Addr    string
+	Expires time.Time
+}
+
+// providerManager keeps provider records in a datastore, so they survive
+// restarts. Records of a key are stored together, under /dht/providers/<key>.
+type providerManager struct {
+	dstore Datastore
+
+	// when the first record of each key expires, so Cleanup only reads
+	// the records due, rather than listing the datastore.
+	expires map[u.Key]time.Time
+	lock    sync.Mutex
+}
+
+// newProviderManager uses the provider records kept in d. Their keys are
+// listed once, and each is checked by the first Cleanup.
+func newProviderManager(d Datastore) *providerManager {
+	pm := &providerManager{
+		dstore:  d,
+		expires: make(map[u.Key]time.Time),
+	}
+
+	keys, err := keysUnder(d, providersPrefix)
+	if err != nil {
+		u.PErr("could not list provider records: %s", err)
+	}
+	for _, k := range keys {
+		pm.expires[k] = time.Time{}
+	}
+	return pm
+}
+
+// AddProvider records p as a provider of k, for ProvideValidity. A peer
+// already recorded has its record renewed.
+func (pm *providerManager) AddProvider(k u.Key, p *peer.Peer) error {
+	addr := p.NetAddress("tcp")
+	if addr == nil {
+		return fmt.Errorf("provider %s has no address", p.ID.Pretty())
+	}
+
+	addrstr, err := addr.String()
+	if err != nil {
+		return err
+	}
+
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	recs, err := pm.records(k)
+	if err != nil {
+		return err
+	}
+
+	rec := &providerRecord{
+		ID:      p.ID.Pretty(),
+		Addr:    addrstr,
+		Expires: time.Now().Add(ProvideValidity),
+	}
+
+	var out []*providerRecord
+	for _, r := range recs {
+		if r.ID != rec.ID {
+			out = append(out, r)
+		}
+	}
+	out = append(out, rec)
+	if err := storeJSON(pm.dstore, providerKey(k), out); err != nil {
+		return err
+	}
+	pm.expires[k] = firstExpiry(out)
+	return nil
+}
+
+// GetProviders returns the unexpired provider records of k.
+func (pm *providerManager) GetProviders(k u.Key) ([]*providerRecord, error) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	recs, err := pm.records(k)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*providerRecord
+	now := time.Now()
+	for _, r := range recs {
+		if now.Before(r.Expires) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// Cleanup removes the expired provider records.
+func (pm *providerManager) Cleanup() error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	now := time.Now()
+	for k, expires := range pm.expires {
+		if now.Before(expires) {
+			continue
+		}
+
+		recs, err := pm.records(k)
+		if err != nil {
+			return err
+		}
+
+		var out []*providerRecord
+		for _, r := range recs {
+			if now.Before(r.Expires) {
+				out = append(out, r)
+			}
+		}
+
+		switch {
+		case len(out) == 0:
+			delete(pm.expires, k)
+			err = pm.dstore.Delete(providerKey(k))
+			if err == ds.ErrNotFound {
+				err = nil
+			}
+		case len(out) < len(recs):
+			pm.expires[k] = firstExpiry(out)
+			err = storeJSON(pm.dstore, providerKey(k), out)
+		default:
+			pm.expires[k] = firstExpiry(out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstExpiry returns when the first of recs expires.
+func firstExpiry(recs []*providerRecord) time.Time {
+	var first time.Time
+	for i, r := range recs {
+		if i == 0 || r.Expires.Before(first) {
+			first = r.Expires
+		}
+	}
+	return first
+}
+
+func (pm *providerManager) records(k u.Key) ([]*providerRecord, error) {
+	var recs []*providerRecord
+	err := loadJSON(pm.dstore, providerKey(k), &recs)
+	return recs, err
+}
+
+// providerKey returns the datastore key the provider records of k are
+// kept under.
+func providerKey(k u.Key) ds.Key {
+	return ds.NewKey(providersPrefix + k.Pretty())
+}
+
+// loadJSON decodes the value at k into v. A missing value leaves v as is.
+func loadJSON(d ds.Datastore, k ds.Key, v interface{}) error {
+	val, err := d.Get(k)
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data, ok := val.([]byte)
+	if !ok {
+		return fmt.Errorf("value at %s is not a []byte", k)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func storeJSON(d ds.Datastore, k ds.Key, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.Put(k, data)
+}
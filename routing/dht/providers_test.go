@@ -0,0 +1,125 @@
+package dht
+
+import (
+	"testing"
+	"time"
+
+	peer "../../peer"
+	u "../../util"
+
+	ds "github.com/ipfs/go-datastore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func providerPeer(t *testing.T, id string, addr string) *peer.Peer {
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &peer.Peer{ID: peer.ID(id)}
+	p.AddAddress(maddr)
+	return p
+}
+
+func TestProviderManager(t *testing.T) {
+	d := ds.NewMapDatastore()
+	pm := newProviderManager(d)
+
+	a := providerPeer(t, "peer_a", "/ip4/127.0.0.1/tcp/4001")
+	b := providerPeer(t, "peer_b", "/ip4/127.0.0.1/tcp/4002")
+	k := u.Key("hello")
+
+	for _, p := range []*peer.Peer{a, b, a} {
+		if err := pm.AddProvider(k, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recs, err := pm.GetProviders(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(recs))
+	}
+
+	recs, err = pm.GetProviders(u.Key("other"))
+	if err != nil || len(recs) != 0 {
+		t.Fatal("expected no providers of other key", recs, err)
+	}
+
+	// records outlive the manager.
+	recs, err = newProviderManager(d).GetProviders(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 stored providers, got %d", len(recs))
+	}
+}
+
+func TestProviderExpiry(t *testing.T) {
+	defer func(v time.Duration) { ProvideValidity = v }(ProvideValidity)
+
+	d := ds.NewMapDatastore()
+	pm := newProviderManager(d)
+	k := u.Key("hello")
+
+	ProvideValidity = -time.Second
+	err := pm.AddProvider(k, providerPeer(t, "peer_a", "/ip4/127.0.0.1/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ProvideValidity = time.Hour
+	err = pm.AddProvider(k, providerPeer(t, "peer_b", "/ip4/127.0.0.1/tcp/4002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := pm.GetProviders(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].ID != peer.ID("peer_b").Pretty() {
+		t.Fatal("expected only the unexpired provider", recs)
+	}
+
+	// once all of a key's records expire, the key is forgotten.
+	ProvideValidity = -time.Second
+	err = pm.AddProvider(k, providerPeer(t, "peer_b", "/ip4/127.0.0.1/tcp/4002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pm.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := d.Has(providerKey(k)); has {
+		t.Fatal("expired records still stored")
+	}
+
+	keys, err := keysUnder(d, providersPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatal("expired key still listed", keys)
+	}
+
+	// records expired while the node was down are removed too.
+	err = pm.AddProvider(k, providerPeer(t, "peer_b", "/ip4/127.0.0.1/tcp/4002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newProviderManager(d).Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := d.Has(providerKey(k)); has {
+		t.Fatal("expired records stored before a restart still stored")
+	}
+}
@@ -0,0 +1,210 @@
+package dht
+
+import (
+	"context"
+	"errors"
+
+	ma "github.com/jbenet/go-multiaddr"
+
+	peer "../../peer"
+	u "../../util"
+	kb "../kbucket"
+)
+
+// AlphaValue is the number of peers a lookup queries at once.
+var AlphaValue = 3
+
+// KValue is the number of closest peers a lookup must hear back from
+// before it ends, and the size of the routing table buckets.
+var KValue = 20
+
+// queryResult is a peer's answer to one query of a lookup.
+type queryResult struct {
+	// closer peers to the target, to query next
+	closer []*PBDHTMessage_PBPeer
+
+	// done ends the lookup, the target was found
+	done bool
+}
+
+// queryFunc asks a single peer about the target of a lookup. Its context
+// is canceled when the lookup ends.
+type queryFunc func(ctx context.Context, p *peer.Peer) (*queryResult, error)
+
+// queryResponse is a queryResult, and who it came from.
+type queryResponse struct {
+	peer *peer.Peer
+	res  *queryResult
+	err  error
+}
+
+// lookup state of a peer
+const (
+	peerUnqueried = iota
+	peerQuerying
+	peerResponded
+	peerFailed
+)
+
+// lookup is an iterative Kademlia lookup of target. It starts with the
+// nearest peers in the routing table, and asks AlphaValue of them at a
+// time, always the closest not yet asked, adding the closer peers they
+// return. The lookup ends when a query says it is done, or when the KValue
+// closest peers known have all responded. It returns the closest peers
+// that responded. If none did, it returns the error of the last query.
+// Once ctx is done, the lookup ends with its error.
+func (dht *IpfsDHT) lookup(ctx context.Context, target kb.ID, query queryFunc) ([]*peer.Peer, error) {
+	// TODO: use more than the first routing table
+	dht.routes[0].MarkRefreshed(target)
+	seeds := dht.routes[0].NearestPeers(target, KValue)
+	if len(seeds) == 0 {
+		return nil, kb.ErrLookupFailure
+	}
+
+	// peers are sorted by distance to target, state is kept by key.
+	var peers []*peer.Peer
+	state := map[u.Key]int{dht.self.Key(): peerFailed}
+	add := func(ps []*peer.Peer) {
+		for _, p := range ps {
+			if _, seen := state[p.Key()]; !seen {
+				state[p.Key()] = peerUnqueried
+				peers = append(peers, p)
+			}
+		}
+		peers = kb.SortClosestPeers(peers, target)
+	}
+	add(seeds)
+
+	// stops the queries still running when the lookup ends.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// at most AlphaValue queries are running, so they never block on this.
+	responses := make(chan *queryResponse, AlphaValue)
+	running := 0
+	var lastErr error
+	for {
+		// query the closest unqueried peers among the KValue closest.
+		count := 0
+		for _, p := range peers {
+			if count >= KValue || running >= AlphaValue {
+				break
+			}
+			switch state[p.Key()] {
+			case peerFailed:
+				continue
+			case peerUnqueried:
+				state[p.Key()] = peerQuerying
+				running++
+				go dht.runQuery(ctx, p, query, responses)
+			}
+			count++
+		}
+
+		// nothing running means the KValue closest have all responded.
+		if running == 0 {
+			closest := dht.respondedPeers(peers, state)
+			if len(closest) == 0 {
+				return nil, lastErr
+			}
+			return closest, nil
+		}
+
+		select {
+		case r := <-responses:
+			running--
+			if r.err != nil {
+				u.DOut("lookup: query to %s failed: %s", r.peer.ID.Pretty(), r.err)
+				state[r.peer.Key()] = peerFailed
+				lastErr = r.err
+				continue
+			}
+
+			state[r.peer.Key()] = peerResponded
+			dht.Update(r.peer)
+			if r.res.done {
+				return dht.respondedPeers(peers, state), nil
+			}
+			add(dht.peersFromInfo(r.res.closer))
+
+		case <-ctx.Done():
+			u.DOut("lookup ended: %s", ctx.Err())
+			return dht.respondedPeers(peers, state), ctx.Err()
+		}
+	}
+}
+
+// runQuery connects to p if needed, and sends the response of query to
+// responses.
+func (dht *IpfsDHT) runQuery(ctx context.Context, p *peer.Peer, query queryFunc, responses chan<- *queryResponse) {
+	np, err := dht.ensureConnected(p)
+	if err != nil {
+		responses <- &queryResponse{peer: p, err: err}
+		return
+	}
+
+	res, err := query(ctx, np)
+	responses <- &queryResponse{peer: np, res: res, err: err}
+}
+
+// respondedPeers returns the KValue closest peers that responded.
+func (dht *IpfsDHT) respondedPeers(peers []*peer.Peer, state map[u.Key]int) []*peer.Peer {
+	var out []*peer.Peer
+	for _, p := range peers {
+		if len(out) >= KValue {
+			break
+		}
+		if state[p.Key()] == peerResponded {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// peersFromInfo returns the peers described in a message. They are not
+// connected to until they are queried.
+func (dht *IpfsDHT) peersFromInfo(infos []*PBDHTMessage_PBPeer) []*peer.Peer {
+	var out []*peer.Peer
+	for _, pbp := range infos {
+		id := peer.ID(pbp.GetId())
+		if p, _ := dht.Find(id); p != nil {
+			out = append(out, p)
+			continue
+		}
+
+		maddr, err := ma.NewMultiaddr(pbp.GetAddr())
+		if err != nil {
+			u.PErr("peer info with bad address: %s", err)
+			continue
+		}
+
+		p := &peer.Peer{ID: id}
+		p.AddAddress(maddr)
+		out = append(out, p)
+	}
+	return out
+}
+
+// ensureConnected returns the peer the network is connected to with the
+// ID of p, dialing its address if there is none.
+func (dht *IpfsDHT) ensureConnected(p *peer.Peer) (*peer.Peer, error) {
+	if np := dht.network.Find(p.Key()); np != nil {
+		return np, nil
+	}
+
+	if len(p.Addresses) == 0 {
+		return nil, errors.New("no address to connect to peer")
+	}
+
+	np, err := dht.network.Connect(p.Addresses[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// the address may have been handed out for another peer.
+	if !np.ID.Equal(p.ID) {
+		dht.network.Drop(np)
+		return nil, errors.New("peer at address has another ID")
+	}
+	return np, nil
+}
@@ -0,0 +1,126 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+
+	u "../../util"
+)
+
+// RecordValidity is how long a stored value is kept, unless it is put
+// again.
+var RecordValidity = time.Hour * 36
+
+// recordsPrefix is the datastore prefix stored values are kept under.
+const recordsPrefix = "/dht/records/"
+
+// storedRecord is a value stored in the DHT, and when it expires.
+type storedRecord struct {
+	Value   []byte
+	Expires time.Time
+}
+
+// recordStore keeps the values stored in the DHT in a datastore, so they
+// survive restarts. The value of a key is stored under /dht/records/<key>.
+type recordStore struct {
+	dstore Datastore
+
+	// when the value of each key expires, so Cleanup only reads the values
+	// due, rather than listing the datastore.
+	expires map[u.Key]time.Time
+	lock    sync.Mutex
+}
+
+// newRecordStore uses the values kept in d. Their keys are listed once,
+// and each is checked by the first Cleanup.
+func newRecordStore(d Datastore) *recordStore {
+	rs := &recordStore{
+		dstore:  d,
+		expires: make(map[u.Key]time.Time),
+	}
+
+	keys, err := keysUnder(d, recordsPrefix)
+	if err != nil {
+		u.PErr("could not list dht records: %s", err)
+	}
+	for _, k := range keys {
+		rs.expires[k] = time.Time{}
+	}
+	return rs
+}
+
+// Get returns the value stored under k. A missing or expired value is
+// ds.ErrNotFound.
+func (rs *recordStore) Get(k u.Key) ([]byte, error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rec := new(storedRecord)
+	if err := loadJSON(rs.dstore, recordKey(k), rec); err != nil {
+		return nil, err
+	}
+
+	if rec.Value == nil || time.Now().After(rec.Expires) {
+		return nil, ds.ErrNotFound
+	}
+	return rec.Value, nil
+}
+
+// Put stores value under k, for RecordValidity.
+func (rs *recordStore) Put(k u.Key, value []byte) error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rec := &storedRecord{
+		Value:   value,
+		Expires: time.Now().Add(RecordValidity),
+	}
+	if err := storeJSON(rs.dstore, recordKey(k), rec); err != nil {
+		return err
+	}
+	rs.expires[k] = rec.Expires
+	return nil
+}
+
+// Cleanup removes the expired values.
+func (rs *recordStore) Cleanup() error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for k, expires := range rs.expires {
+		if now.Before(expires) {
+			continue
+		}
+
+		rec := new(storedRecord)
+		if err := loadJSON(rs.dstore, recordKey(k), rec); err != nil {
+			return err
+		}
+
+		if rec.Value != nil && now.Before(rec.Expires) {
+			rs.expires[k] = rec.Expires
+			continue
+		}
+
+		delete(rs.expires, k)
+		removed++
+		err := rs.dstore.Delete(recordKey(k))
+		if err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+
+	if removed > 0 {
+		u.DOut("dht: removed %d expired records", removed)
+	}
+	return nil
+}
+
+// recordKey returns the datastore key the value of k is kept under.
+func recordKey(k u.Key) ds.Key {
+	return ds.NewKey(recordsPrefix + k.Pretty())
+}
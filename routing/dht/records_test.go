@@ -0,0 +1,73 @@
+package dht
+
+import (
+	"testing"
+	"time"
+
+	u "../../util"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+func TestRecordStore(t *testing.T) {
+	defer func(v time.Duration) { RecordValidity = v }(RecordValidity)
+
+	d := ds.NewMapDatastore()
+	rs := newRecordStore(d)
+
+	if err := rs.Put(u.Key("/v/hello"), []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	// values outlive the store.
+	val, err := newRecordStore(d).Get(u.Key("/v/hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "world" {
+		t.Fatalf("expected 'world', got %s", val)
+	}
+
+	if _, err := rs.Get(u.Key("/v/other")); err != ds.ErrNotFound {
+		t.Fatal("expected ds.ErrNotFound, got", err)
+	}
+
+	RecordValidity = -time.Second
+	if err := rs.Put(u.Key("/v/old"), []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rs.Get(u.Key("/v/old")); err != ds.ErrNotFound {
+		t.Fatal("expected an expired value to be ds.ErrNotFound, got", err)
+	}
+
+	if err := rs.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := d.Has(recordKey(u.Key("/v/old"))); has {
+		t.Fatal("expired value still stored")
+	}
+
+	// values expired while the node was down are removed too.
+	RecordValidity = -time.Second
+	if err := rs.Put(u.Key("/v/older"), []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+	if err := newRecordStore(d).Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keysUnder(d, recordsPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if k == u.Key("/v/old") || k == u.Key("/v/older") {
+			t.Fatal("expired key still listed")
+		}
+	}
+	if _, err := rs.Get(u.Key("/v/hello")); err != nil {
+		t.Fatal("unexpired value removed:", err)
+	}
+}
@@ -1,109 +1,132 @@
 package dht
 
 import (
-	"math/rand"
-	"time"
 	"bytes"
+	"context"
 	"encoding/json"
-
-	proto "github.com/golang/protobuf/proto"
-
-	ma "github.com/jbenet/go-multiaddr"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
 
 	peer "../../peer"
-	swarm "../../swarm"
 	u "../../util"
 	kb "../kbucket"
-	"golang.org/x/text/collate"
-	"fmt"
-	"errors"
 )
 
-// Pool size is the number of nodes used for group find/set RPC calls
-var PoolSize = 6
-
-// TODO: determine a way of creating and managing message IDs
-func GenerateMessageID() uint64 {
-	//return (uint64(rand.Uint32()) << 32) & uint64(rand.Uint32())
-	return uint64(rand.Uint32())
-}
-
 // This file implements the Routing interface for the IpfsDHT struct.
 
+// ValueResponses is the number of valid values GetValue collects before
+// picking the best of them.
+var ValueResponses = 3
+
 // Basic Put/Get
 
 // PutValue adds value corresponding to given Key.
-// This is the top level "Store" operation of the DHT
-func (s *IpfsDHT) PutValue(key u.Key, value []byte) {
-	complete := make(chan struct{})
-	for _, route := range s.routes {
-		p := route.NearestPeer(kb.ConvertKey(key))
-		if p == nil {
-			s.network.Error(kb.ErrLookupFailure)
-			go func() {
-				complete <- struct{}{}
-			}()
+// This is the top level "Store" operation of the DHT: the value is stored
+// at the KValue peers closest to the key. The value must be valid for the
+// validator of the key's namespace.
+func (s *IpfsDHT) PutValue(ctx context.Context, key u.Key, value []byte) error {
+	if err := s.Validators.VerifyRecord(key, value); err != nil {
+		return err
+	}
+
+	closest, err := s.closestPeers(ctx, kb.ConvertKey(key))
+	if err != nil {
+		return err
+	}
+
+	stored := 0
+	for _, p := range closest {
+		err = s.putValueToNetwork(ctx, p, string(key), value)
+		if err != nil {
+			u.PErr("PutValue to %s failed: %s", p.ID.Pretty(), err)
 			continue
 		}
-		go func() {
-			err := s.putValueToNetwork(p, string(key), value)
+		stored++
+	}
+
+	if stored == 0 {
+		return err
+	}
+	return nil
+}
+
+// closestPeers looks up the KValue peers closest to target.
+func (s *IpfsDHT) closestPeers(ctx context.Context, target kb.ID) ([]*peer.Peer, error) {
+	closest, err := s.lookup(ctx, target,
+		func(ctx context.Context, p *peer.Peer) (*queryResult, error) {
+			pmes, err := s.findIDSingle(ctx, p, target, 0)
 			if err != nil {
-				s.network.Send(err)
+				return nil, err
 			}
-			complete <- struct{}{}
-		}()
-	}
-	for _, _ = range s.routes {
-		<-complete
+			return &queryResult{closer: pmes.GetPeers()}, nil
+		})
+	if len(closest) == 0 {
+		if err == nil {
+			err = kb.ErrLookupFailure
+		}
+		return nil, err
 	}
+	return closest, nil
 }
 
 // GetValue searches for the value corresponding to given Key.
-// If the search does not succeed, a multiaddr string of a closer peer is
-// returned along with util.ErrSearchIncomplete
-func (s *IpfsDHT) GetValue(key u.Key, timeout time.Duration) ([]byte, error) {
-	route_level := 0
-
-
-	p := s.routes[route_level].NearestPeer(kb.ConvertKey(key))
-	if p == nil {
-		return nil, kb.ErrLookupFailure
+// Peers without the value return closer peers, or providers of the value
+// to ask instead. Invalid values are dropped, and the best of the first
+// ValueResponses valid ones, counting our own, is returned.
+func (s *IpfsDHT) GetValue(ctx context.Context, key u.Key) ([]byte, error) {
+	var vals [][]byte
+	var valLock sync.Mutex
+	if v, err := s.GetLocal(key); err == nil && s.Validators.VerifyRecord(key, v) == nil {
+		vals = append(vals, v)
 	}
 
-	for route_level < len(s.routes) && p != nil {
-		pmes, err := s.getValueSingle(p, key, timeout, route_level)
-		if err != nil {
-			return nil, u.WrapError(err, "getValue Error")
-		}
+	_, err := s.lookup(ctx, kb.ConvertKey(key),
+		func(ctx context.Context, p *peer.Peer) (*queryResult, error) {
+			pmes, err := s.getValueSingle(ctx, p, key, 0)
+			if err != nil {
+				return nil, err
+			}
 
-		if pmes.GetSuccess() {
-			if pmes.Value == nil {
-				return s.getFromPeerList(key, timeout, pmes.GetPeers(), route_level)
+			if !pmes.GetSuccess() {
+				// We were given closer nodes
+				return &queryResult{closer: pmes.GetPeers()}, nil
 			}
 
-			// Success! We were given the value
-			return pmes.GetValue(), nil
-		} else {
-			// We were given a closer node
-			closers := pmes.GetPeers()
-			if len(closers) > 0 {
-				maddr, err := ma.NewMultiaddr(closers[0].GetAddr())
+			v := pmes.GetValue()
+			if v == nil {
+				// We were given providers of the value
+				v, err = s.getFromPeerList(ctx, key, pmes.GetPeers(), 0)
 				if err != nil {
-					// ??? Move up route level???
-					panic("not yet implemented")
+					return &queryResult{}, nil
 				}
+			}
 
-				// TODO: dht.Connect has overhead due to an internal
-				//			ping to the target. Use something else
-				p, err = s.Connect(maddr)
-				if err != nil {
-					// Move up route level
-					panic("not yet implemented")
-				}
-			} else {
-				route_level++
+			if err := s.Validators.VerifyRecord(key, v); err != nil {
+				u.DOut("GetValue: invalid value from %s: %s", p.ID.Pretty(), err)
+				return &queryResult{}, nil
 			}
+
+			// Success! We were given a value
+			valLock.Lock()
+			defer valLock.Unlock()
+			vals = append(vals, v)
+			return &queryResult{done: len(vals) >= ValueResponses}, nil
+		})
+
+	valLock.Lock()
+	defer valLock.Unlock()
+	if len(vals) > 0 {
+		best, err := s.Validators.BestRecord(key, vals)
+		if err != nil {
+			return nil, err
 		}
+		return vals[best], nil
+	}
+	if err != nil {
+		return nil, err
 	}
 	return nil, u.ErrNotFound
 }
@@ -111,11 +134,13 @@ func (s *IpfsDHT) GetValue(key u.Key, timeout time.Duration) ([]byte, error) {
 // Value provider layer of indirection.
 // This is what DSHTs (Coral and MainlineDHT) do to store large values in a DHT.
 
-// Announce that this node can provide value for given key
-func (s *IpfsDHT) Provide(key u.Key) error {
-	peers := s.routes[0].NearestPeers(kb.ConvertKey(key), PoolSize)
-	if len(peers) == 0 {
-		return kb.ErrLookupFailure
+// Announce that this node can provide value for given key, to the peers
+// closest to it. The announcement expires after ProvideValidity, so it must
+// be repeated for as long as the value is held.
+func (s *IpfsDHT) Provide(ctx context.Context, key u.Key) error {
+	peers, err := s.closestPeers(ctx, kb.ConvertKey(key))
+	if err != nil {
+		return err
 	}
 
 	pmes := DHTMessage{
@@ -125,179 +150,164 @@ func (s *IpfsDHT) Provide(key u.Key) error {
 	pbmes := pmes.ToProtobuf()
 
 	for _, p := range peers {
-		mes := swarm.NewMessage(p, pbmes)
-		s.network.Send(mes)
+		err := s.sendMessage(ctx, p, pbmes)
+		if err != nil {
+			u.PErr("Provide to %s failed: %s", p.ID.Pretty(), err)
+		}
 	}
 	return nil
 }
 
 // FindProviders searches for peers who can provide the value for given key.
-func (s *IpfsDHT) FindProviders(key u.Key, timeout time.Duration) ([]*peer.Peer, error) {
-	p := s.routes[0].NearestPeer(kb.ConvertKey(key))
-	if p != nil {
-		return nil, kb.ErrLookupFailure
-	}
+func (s *IpfsDHT) FindProviders(ctx context.Context, key u.Key) ([]*peer.Peer, error) {
+	u.DOut("Find providers for: '%s'", key)
 
-	pmes := DHTMessage{
-		Type: PBDHTMessage_GET_PROVIDERS,
-		Key:  string(key),
-		Id:   GenerateMessageID(),
-	}
+	var provs []*peer.Peer
+	var provLock sync.Mutex
+	_, err := s.lookup(ctx, kb.ConvertKey(key),
+		func(ctx context.Context, p *peer.Peer) (*queryResult, error) {
+			pmes := DHTMessage{
+				Type: PBDHTMessage_GET_PROVIDERS,
+				Key:  string(key),
+			}
 
-	mes := swarm.NewMessage(p, pmes.ToProtobuf())
+			pmes_out, err := s.sendRequest(ctx, p, pmes.ToProtobuf())
+			if err != nil {
+				return nil, err
+			}
 
-	listenChan := s.ListenFor(pmes.Id, 1, time.Minute)
-	u.DOut("Find providers for: '%s'", key)
-	s.network.Send(mes)
-	after := time.After(timeout)
-	select {
-	case <-after:
-		s.Unlisten(pmes.Id)
-		return nil, u.ErrTimeout
-	case resp := <-listenChan:
-		u.DOut("FindProviders: got response.")
-		pmes_out := new(PBDHTMessage)
-		err := proto.Unmarshal(resp.Data, pmes_out)
-		if err != nil {
-			return nil, err
-		}
+			if !pmes_out.GetSuccess() {
+				return &queryResult{closer: pmes_out.GetPeers()}, nil
+			}
 
-		var prov_arr []*peer.Peer
-		for _, prov := range pmes_out.GetPeers() {
-			p := s.network.Find(u.Key(prov.GetId()))
-			if p == nil {
-				maddr, err := ma.NewMultiaddr(prov.GetAddr())
-				if err != nil {
-					u.PErr("error connecting to new peer: %s", err)
-					continue
-				}
-				p, err = s.Connect(maddr)
+			u.DOut("FindProviders: got providers.")
+			var found []*peer.Peer
+			for _, prov := range s.peersFromInfo(pmes_out.GetPeers()) {
+				prov, err := s.ensureConnected(prov)
 				if err != nil {
 					u.PErr("error connecting to new peer: %s", err)
 					continue
 				}
+				s.addProviderEntry(key, prov)
+				found = append(found, prov)
 			}
-			s.addProviderEntry(key, p)
-			prov_arr = append(prov_arr, p)
-		}
 
-		return prov_arr, nil
+			if len(found) == 0 {
+				return &queryResult{}, nil
+			}
+
+			provLock.Lock()
+			provs = found
+			provLock.Unlock()
+			return &queryResult{done: true}, nil
+		})
+
+	provLock.Lock()
+	defer provLock.Unlock()
+	if len(provs) > 0 {
+		return provs, nil
+	}
+	if err != nil {
+		return nil, err
 	}
+	return nil, u.ErrNotFound
 }
 
 // Find specific Peer
 
 // FindPeer searches for a peer with given ID.
-func (s *IpfsDHT) FindPeer(id peer.ID, timeout time.Duration) (*peer.Peer, error) {
-	route_level := 0
-	p := s.routes[route_level].NearestPeer(kb.ConvertPeerID(id))
-	if p == nil {
-		return nil, kb.ErrLookupFailure
+func (s *IpfsDHT) FindPeer(ctx context.Context, id peer.ID) (*peer.Peer, error) {
+	if p, _ := s.Find(id); p != nil {
+		return p, nil
 	}
 
-	for route_level < len(s.routes) {
-		pmes, err := s.findPeerSingle(p, id, timeout, route_level)
-		plist := pmes.GetPeers()
-		if len(plist) == 0 {
-			route_level++
-		}
+	var found *peer.Peer
+	var foundLock sync.Mutex
+	_, err := s.lookup(ctx, kb.ConvertPeerID(id),
+		func(ctx context.Context, p *peer.Peer) (*queryResult, error) {
+			pmes, err := s.findPeerSingle(ctx, p, id, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			if !pmes.GetSuccess() {
+				return &queryResult{closer: pmes.GetPeers()}, nil
+			}
 
-		found := plist[0]
+			for _, np := range s.peersFromInfo(pmes.GetPeers()) {
+				if !np.ID.Equal(id) {
+					continue
+				}
 
-		addr, err := ma.NewMultiaddr(found.GetAddr())
-		if err != nil {
-			return nil, u.WrapError(err, "FindPeer received bad info")
-		}
+				np, err := s.ensureConnected(np)
+				if err != nil {
+					return nil, u.WrapError(err, "FindPeer failed to connect to new peer.")
+				}
 
-		nxtPeer, err := s.Connect(addr)
-		if err != nil {
-			return nil, u.WrapError(err, "FindPeer failed to connect to new peer.")
-		}
+				foundLock.Lock()
+				found = np
+				foundLock.Unlock()
+				return &queryResult{done: true}, nil
+			}
+			return nil, errors.New("FindPeer received bad info")
+		})
 
-		if pmes.GetSuccess() {
-			return nxtPeer, nil
-		} else {
-			p = nxtPeer
-		}
+	foundLock.Lock()
+	defer foundLock.Unlock()
+	if found != nil {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
 	}
-
 	return nil, u.ErrNotFound
 }
 
 // Ping a peer, log the time it took
-func (dht *IpfsDHT) Ping(p *peer.Peer, timeout time.Duration) error {
+func (dht *IpfsDHT) Ping(ctx context.Context, p *peer.Peer) error {
 	// Thoughts: maybe this should accept an ID and do a peer lookup?
 	u.DOut("Enter Ping.")
 
-	pmes := DHTMessage{Id: GenerateMessageID(), Type: PBDHTMessage_PING}
-	mes := swarm.NewMessage(p, pmes.ToProtobuf())
+	pmes := DHTMessage{Type: PBDHTMessage_PING}
 
 	before := time.Now()
-	response_chan := dht.ListenFor(pmes.Id, 1, time.Minute)
-	dht.network.Send(mes)
-
-	tout := time.After(timeout)
-	select {
-	case <-response_chan:
-		roundtrip := time.Since(before)
-		p.SetLatency(roundtrip)
-		u.DOut("Ping took %s.", roundtrip.String())
-		return nil
-	case <-tout:
+	_, err := dht.sendRequest(ctx, p, pmes.ToProtobuf())
+	if err != nil {
 		// Timed out, think about removing peer from network
-		u.DOut("Ping peer timed out.")
-		dht.Unlisten(pmes.Id)
-		return u.ErrTimeout
+		u.DOut("Ping peer failed: %s", err)
+		return err
 	}
+
+	roundtrip := time.Since(before)
+	p.SetLatency(roundtrip)
+	u.DOut("Ping took %s.", roundtrip.String())
+	return nil
 }
 
-func (dht *IpfsDHT) GetDiagnostic(timeout time.Duration) ([]*diagInfo, error) {
+func (dht *IpfsDHT) GetDiagnostic(ctx context.Context) ([]*diagInfo, error) {
 	u.DOut("Begin Diagnostic")
 	//Send to N closest peers
 	targets := dht.routes[0].NearestPeers(kb.ConvertPeerID(dht.self.ID), 10)
 
-	// TODO: Add timeout to this struct so nodes know when to return
+	// The key identifies this diagnostic, so peers reached twice answer once.
 	pmes := DHTMessage{
 		Type: PBDHTMessage_DIAGNOSTIC,
-		Id:   GenerateMessageID(),
-	}
-
-	listenChan := dht.ListenFor(pmes.Id, len(targets), time.Minute*2)
-
-	pbmes := pmes.ToProtobuf()
-	for _, p := range targets {
-		mes := swarm.NewMessage(p, pbmes)
-		dht.network.Send(mes)
+		Key:  fmt.Sprintf("%s-%d", dht.self.ID.Pretty(), rand.Int63()),
 	}
 
 	var out []*diagInfo
-	after := time.After(timeout)
-	for count := len(targets); count > 0; {
-		select {
-		case <-after:
-			u.DOut("Diagnostic request timed out.")
-			return out, u.ErrTimeout
-		case resp := <-listenChan:
-			pmes_out := new(PBDHTMessage)
-			err := proto.Unmarshal(resp.Data, pmes_out)
+	for _, resp := range dht.sendRequestAll(ctx, targets, pmes.ToProtobuf()) {
+		dec := json.NewDecoder(bytes.NewBuffer(resp.GetValue()))
+		for {
+			di := new(diagInfo)
+			err := dec.Decode(di)
 			if err != nil {
-				// NOTE: here and elsewhere, need to audit error handling,
-				//		some errors should be continued on from
-				return out, err
+				break
 			}
 
-			dec := json.NewDecoder(bytes.NewBuffer(pmes_out.GetValue()))
-			for {
-				di := new(diagInfo)
-				err := dec.Decode(di)
-				if err != nil {
-					break
-				}
-
-				out = append(out, di)
-			}
+			out = append(out, di)
 		}
 	}
 
-	return nil, nil
+	return out, nil
 }
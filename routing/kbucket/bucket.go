@@ -29,6 +29,28 @@ func (b *Bucket) PushFront(p *peer.Peer) {
 	bucket_list.PushFront(p)
 }
 
+// Remove takes the peer with the given ID out of the bucket, and returns
+// whether it was there.
+func (b *Bucket) Remove(id peer.ID) bool {
+	e := b.Find(id)
+	if e == nil {
+		return false
+	}
+	bucket_list := (*list.List)(b)
+	bucket_list.Remove(e)
+	return true
+}
+
+// Back returns the least recently seen peer, or nil if the bucket is empty.
+func (b *Bucket) Back() *peer.Peer {
+	bucket_list := (*list.List)(b)
+	last := bucket_list.Back()
+	if last == nil {
+		return nil
+	}
+	return last.Value.(*peer.Peer)
+}
+
 func (b *Bucket) PopBack() *peer.Peer {
 	bucket_list := (*list.List)(b)
 	last := bucket_list.Back()
@@ -0,0 +1,117 @@
+package kbucket
+
+import (
+	"net"
+
+	peer "../../peer"
+)
+
+// Policy decides which peers a RoutingTable holds, and which it returns
+// first. A nil Policy orders peers by distance only, and admits any peer.
+type Policy struct {
+	// PreferLowLatency orders peers sharing the same prefix with the
+	// target by latency, lowest first. Peers never pinged come after.
+	PreferLowLatency bool
+
+	// MaxPerIP is the number of peers of a bucket that may share an IP
+	// address. Zero means no limit.
+	MaxPerIP int
+
+	// MaxPerSubnet is the number of peers of a bucket that may share an
+	// IPv4 /24 or IPv6 /48 subnet. Zero means no limit.
+	//
+	// Neither limit applies to loopback, link-local or private addresses,
+	// where many peers of a local network legitimately share a subnet.
+	MaxPerSubnet int
+}
+
+// DefaultPolicy prefers low latency peers, and admits any peer. Setting
+// MaxPerIP and MaxPerSubnet keeps a single host or subnet from filling
+// buckets, which makes Sybil and eclipse attacks harder.
+var DefaultPolicy = &Policy{
+	PreferLowLatency: true,
+}
+
+// admits returns whether p may join bucket b under the diversity limits.
+func (pol *Policy) admits(b *Bucket, p *peer.Peer) bool {
+	if pol == nil || (pol.MaxPerIP == 0 && pol.MaxPerSubnet == 0) {
+		return true
+	}
+
+	ip := peerIP(p)
+	if ip == nil || isLocal(ip) {
+		return true
+	}
+
+	subnet := subnetOf(ip)
+	sameIP, sameSubnet := 0, 0
+	for e := b.getIter(); e != nil; e = e.Next() {
+		other := peerIP(e.Value.(*peer.Peer))
+		if other == nil {
+			continue
+		}
+		if other.Equal(ip) {
+			sameIP++
+		}
+		if subnetOf(other).Equal(subnet) {
+			sameSubnet++
+		}
+	}
+
+	if pol.MaxPerIP > 0 && sameIP >= pol.MaxPerIP {
+		return false
+	}
+	return pol.MaxPerSubnet == 0 || sameSubnet < pol.MaxPerSubnet
+}
+
+// peerIP returns the IP of the first address of p that has one.
+func peerIP(p *peer.Peer) net.IP {
+	for _, a := range p.Addresses {
+		_, host, err := a.DialArgs()
+		if err != nil {
+			continue
+		}
+
+		h, _, err := net.SplitHostPort(host)
+		if err != nil {
+			h = host
+		}
+
+		if ip := net.ParseIP(h); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// isLocal returns whether ip is a loopback, link-local or private address.
+func isLocal(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsPrivate()
+}
+
+// subnetOf returns the /24 of an IPv4 address, or the /48 of an IPv6 one.
+func subnetOf(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32))
+	}
+	return ip.Mask(net.CIDRMask(48, 128))
+}
+
+// latencySorter sorts peers by the prefix they share with the target,
+// longest first, then by latency, lowest known first, then by distance.
+type latencySorter peerSorterArr
+
+func (p latencySorter) Len() int      { return len(p) }
+func (p latencySorter) Swap(a, b int) { p[a], p[b] = p[b], p[a] }
+func (p latencySorter) Less(a, b int) bool {
+	ca, cb := p[a].distance.commonPrefixLen(), p[b].distance.commonPrefixLen()
+	if ca != cb {
+		return ca > cb
+	}
+
+	la, lb := p[a].latency, p[b].latency
+	if la != lb {
+		return lb == 0 || (la != 0 && la < lb)
+	}
+	return p[a].distance.Less(p[b].distance)
+}
@@ -2,15 +2,12 @@ package kbucket
 
 import (
 	"container/list"
+	crand "crypto/rand"
 	"sort"
 	"sync"
+	"time"
 
 	peer "../../peer"
-	"golang.org/x/net/html/atom"
-	"github.com/libp2p/go-libp2p-peerstore"
-	"bytes"
-	"encoding/hex"
-
 	u "../../util"
 )
 
@@ -26,67 +23,147 @@ type RoutingTable struct {
 	// kBuckets define all the fingers to other nodes.
 	Buckets []*Bucket
 	bucketsize int
+
+	// when a lookup last went through the range of each bucket
+	refreshed []time.Time
+
+	// per bucket, the peers seen while it was full, most recent first.
+	// They replace the bucket's peers that stop responding.
+	replacements []*Bucket
+
+	// which peers are held, and returned first
+	policy *Policy
 }
 
-func NewRoutingTable(bucketsize int, local_id ID) *RoutingTable {
+// NewRoutingTable returns an empty table around local_id, with buckets of
+// bucketsize peers, selected by policy. policy may be nil.
+func NewRoutingTable(bucketsize int, local_id ID, policy *Policy) *RoutingTable {
 	rt := new(RoutingTable)
+	rt.policy = policy
 	rt.Buckets = []*Bucket{new(Bucket)}
+	rt.refreshed = []time.Time{time.Now()}
+	rt.replacements = []*Bucket{new(Bucket)}
 	rt.bucketsize = bucketsize
 	rt.local = local_id
 	return rt
 }
 
-// Update adds or moves the given peer to the front of its respective bucket
-// If a peer gets removed from a bucket, it is returned
+// SetPolicy replaces the policy of the table. It applies to the peers
+// added from then on.
+func (rt *RoutingTable) SetPolicy(policy *Policy) {
+	rt.tablock.Lock()
+	rt.policy = policy
+	rt.tablock.Unlock()
+}
+
+// Update adds or moves the given peer to the front of its respective bucket.
+// If the bucket is full, the peer waits in the bucket's replacement cache,
+// and the least recently seen peer of the bucket is returned: it should be
+// checked for liveness, then either updated again if it responds, or
+// removed, which lets the most recent replacement take its place.
 func (rt *RoutingTable) Update(p *peer.Peer) *peer.Peer {
 	rt.tablock.Lock()
 	defer rt.tablock.Unlock()
 	peer_id := ConvertPeerID(p.ID)
-	cpl := xor(peer_id, rt.local).commonPrefixLen()
-
-	b_id := cpl
-	if b_id >= len(rt.Buckets) {
-		b_id = len(rt.Buckets) - 1
-	}
+	b_id := rt.bucketIndex(peer_id)
 
 	bucket := rt.Buckets[b_id]
 	e := bucket.Find(p.ID)
-	if e == nil {
-		// New peer, add to bucket
-		bucket.PushFront(e)
-
-		if bucket.Len() > rt.bucketsize {
-			if b_id == len(rt.Buckets) - 1 {
-				new_bucket := bucket.Split(b_id, rt.local)
-				if new_bucket.Len() > rt.bucketsize {
-					// TODO: This is a very rare and annoying case
-					panic("Case not handled.")
-				}
-				rt.Buckets = append(rt.Buckets, new_bucket)
-
-				// If all elements were on left side of split...
-				if bucket.Len() > rt.bucketsize {
-					return bucket.PopBack()
-				}
-			} else {
-				// If the bucket cant split kick out least active node
-				return bucket.PopBack()
-			}
-		}
-		return nil
-	} else {
+	if e != nil {
 		// If the peer is already in the table, move it to the front.
 		// This signifies that it it "more active" and the less active nodes
 		// Will as a result tend towards the back of the list
 		bucket.MoveToFront(e)
 		return nil
 	}
+
+	// New peer, add to bucket, unless too many peers share its address
+	if !rt.policy.admits(bucket, p) {
+		return nil
+	}
+	rt.replacements[b_id].Remove(p.ID)
+	bucket.PushFront(p)
+	if bucket.Len() <= rt.bucketsize {
+		return nil
+	}
+
+	if b_id == len(rt.Buckets)-1 {
+		rt.splitLast()
+
+		b_id = rt.bucketIndex(peer_id)
+		bucket = rt.Buckets[b_id]
+		if bucket.Len() <= rt.bucketsize {
+			return nil
+		}
+	}
+
+	// The bucket is full, and cannot split.
+	bucket.Remove(p.ID)
+	rt.addReplacement(b_id, p)
+	return bucket.Back()
+}
+
+// splitLast splits the last bucket while it holds too many peers. All its
+// peers may fall on the same side of a split, so it can take several.
+// Callers hold tablock.
+func (rt *RoutingTable) splitLast() {
+	for {
+		b_id := len(rt.Buckets) - 1
+		bucket := rt.Buckets[b_id]
+		if bucket.Len() <= rt.bucketsize || b_id >= len(rt.local)*8-1 {
+			return
+		}
+
+		rt.Buckets = append(rt.Buckets, bucket.Split(b_id, rt.local))
+		rt.replacements = append(rt.replacements, rt.replacements[b_id].Split(b_id, rt.local))
+		rt.refreshed = append(rt.refreshed, rt.refreshed[b_id])
+	}
+}
+
+// addReplacement puts p at the front of the replacement cache of bucket
+// b_id, which keeps the bucketsize most recent peers. Callers hold tablock.
+func (rt *RoutingTable) addReplacement(b_id int, p *peer.Peer) {
+	cache := rt.replacements[b_id]
+	if e := cache.Find(p.ID); e != nil {
+		cache.MoveToFront(e)
+		return
+	}
+
+	cache.PushFront(p)
+	if cache.Len() > rt.bucketsize {
+		cache.PopBack()
+	}
+}
+
+// Remove takes the peer with the given ID out of the table, e.g. once it
+// stopped responding. The most recent peer of the bucket's replacement
+// cache the policy admits takes its place.
+func (rt *RoutingTable) Remove(id peer.ID) {
+	rt.tablock.Lock()
+	defer rt.tablock.Unlock()
+	b_id := rt.bucketIndex(ConvertPeerID(id))
+
+	if !rt.Buckets[b_id].Remove(id) {
+		rt.replacements[b_id].Remove(id)
+		return
+	}
+
+	cache := (*list.List)(rt.replacements[b_id])
+	for e := cache.Front(); e != nil; e = e.Next() {
+		p := e.Value.(*peer.Peer)
+		if rt.policy.admits(rt.Buckets[b_id], p) {
+			cache.Remove(e)
+			rt.Buckets[b_id].PushFront(p)
+			return
+		}
+	}
 }
 
 // A helper struct to sort peers by their distance to the local node
 type peerDistance struct {
 	p        *peer.Peer
 	distance ID
+	latency  time.Duration
 }
 
 // peerSorterArr implements sort.Interface to sort peers by xor distance
@@ -97,13 +174,34 @@ func (p peerSorterArr) Less(a, b int) bool {
 	return p[a].distance.Less(p[b].distance)
 }
 
+// SortClosestPeers returns the given peers, sorted by their distance to
+// target, closest first.
+func SortClosestPeers(peers []*peer.Peer, target ID) []*peer.Peer {
+	var psarr peerSorterArr
+	for _, p := range peers {
+		pd := &peerDistance{
+			p:        p,
+			distance: xor(target, ConvertPeerID(p.ID)),
+		}
+		psarr = append(psarr, pd)
+	}
+	sort.Sort(psarr)
+
+	var out []*peer.Peer
+	for _, pd := range psarr {
+		out = append(out, pd.p)
+	}
+	return out
+}
+
 func copyPeersFromList(target ID, peerArr peerSorterArr, peerList *list.List) peerSorterArr {
-	for e := peerList.Front(); e != nil; e. = e.Next() {
+	for e := peerList.Front(); e != nil; e = e.Next() {
 		p := e.Value.(*peer.Peer)
 		p_id := ConvertPeerID(p.ID)
 		pd := peerDistance{
 			p: p,
 			distance: xor(target, p_id),
+			latency: p.GetLatency(),
 		}
 		peerArr = append(peerArr, &pd)
 		if e == nil {
@@ -120,7 +218,7 @@ func (rt *RoutingTable) Find(id peer.ID) *peer.Peer {
 	if len(srch) == 0 || !srch[0].ID.Equal(id) {
 		return nil
 	}
-	return srch[0]d
+	return srch[0]
 }
 
 // Returns a single peer that is nearest to the given ID
@@ -165,7 +263,11 @@ func (rt *RoutingTable) NearestPeers(id ID, count int) []*peer.Peer {
 	}
 
 	// Sort by distance to local peer
-	sort.Sort(peerArr)
+	if rt.policy != nil && rt.policy.PreferLowLatency {
+		sort.Sort(latencySorter(peerArr))
+	} else {
+		sort.Sort(peerArr)
+	}
 
 	var out []*peer.Peer
 	for i := 0; i < count && i < peerArr.Len(); i++ {
@@ -181,6 +283,8 @@ func (rt *RoutingTable) NearestNode(key u.Key) *peer.Peer {
 }
 
 func (rt *RoutingTable) Size() int {
+	rt.tablock.RLock()
+	defer rt.tablock.RUnlock()
 	var tot int
 	for _, buck := range rt.Buckets {
 		tot += buck.Len()
@@ -188,8 +292,10 @@ func (rt *RoutingTable) Size() int {
 	return tot
 }
 
-// NOTE: This is potentially unsafe... use at your own risk
-func (rt *RoutingTable) listpeers() []*peer.Peer {
+// Listpeers returns all the peers in the table.
+func (rt *RoutingTable) Listpeers() []*peer.Peer {
+	rt.tablock.RLock()
+	defer rt.tablock.RUnlock()
 	var peers []*peer.Peer
 	for _, buck := range rt.Buckets {
 		for e := buck.getIter(); e != nil; e = e.Next() {
@@ -198,3 +304,52 @@ func (rt *RoutingTable) listpeers() []*peer.Peer {
 	}
 	return peers
 }
+
+// bucketIndex returns the bucket id falls in. Callers hold tablock.
+func (rt *RoutingTable) bucketIndex(id ID) int {
+	cpl := xor(id, rt.local).commonPrefixLen()
+	if cpl >= len(rt.Buckets) {
+		cpl = len(rt.Buckets) - 1
+	}
+	return cpl
+}
+
+// MarkRefreshed records a lookup of id, which keeps its bucket fresh.
+func (rt *RoutingTable) MarkRefreshed(id ID) {
+	rt.tablock.Lock()
+	defer rt.tablock.Unlock()
+	rt.refreshed[rt.bucketIndex(id)] = time.Now()
+}
+
+// StaleBuckets returns the buckets no lookup went through for maxAge.
+func (rt *RoutingTable) StaleBuckets(maxAge time.Duration) []int {
+	rt.tablock.RLock()
+	defer rt.tablock.RUnlock()
+	var out []int
+	for i, t := range rt.refreshed {
+		if time.Since(t) > maxAge {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// RandomIDInBucket returns a random ID that falls in bucket i, to refresh
+// it with a lookup. The ID shares its first i bits with ours, and differs
+// in the next one.
+func (rt *RoutingTable) RandomIDInBucket(i int) ID {
+	if i >= len(rt.local)*8 {
+		i = len(rt.local)*8 - 1
+	}
+
+	id := make(ID, len(rt.local))
+	crand.Read(id)
+	copy(id, rt.local[:i/8])
+
+	// the byte of bit i: our bits before it, the flipped bit, random after.
+	b := i / 8
+	prefix := byte(0xff) << uint(8-i%8)
+	bit := byte(0x80) >> uint(i%8)
+	id[b] = rt.local[b]&prefix | ^rt.local[b]&bit | id[b]&^(prefix|bit)
+	return id
+}
@@ -4,10 +4,13 @@ import (
 	"container/list"
 	crand "crypto/rand"
 	"crypto/sha256"
+	"fmt"
 	"math/rand"
 	"testing"
+	"time"
 
 	peer "../../peer"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 func _randPeer() *peer.Peer {
@@ -67,7 +70,7 @@ func TestBucket(t *testing.T) {
 // Right now, this just makes sure that it doesnt hang or crash
 func TestTableUpdate(t *testing.T) {
 	local := _randPeer()
-	rt := NewRoutingTable(10, ConvertPeerID(local.ID))
+	rt := NewRoutingTable(10, ConvertPeerID(local.ID), nil)
 
 	peers := make([]*peer.Peer, 100)
 	for i := 0; i < 100; i++ {
@@ -78,7 +81,7 @@ func TestTableUpdate(t *testing.T) {
 	for i := 0; i < 10000; i++ {
 		p := rt.Update(peers[rand.Intn(len(peers))])
 		if p != nil {
-			t.Log("evicted peer.")
+			t.Log("bucket full, oldest peer to check.")
 		}
 	}
 
@@ -91,9 +94,82 @@ func TestTableUpdate(t *testing.T) {
 	}
 }
 
+// _randPeerAt returns a random peer whose ID shares between min and max
+// prefix bits with local.
+func _randPeerAt(local ID, min, max int) *peer.Peer {
+	for {
+		p := _randPeer()
+		cpl := prefLen(ConvertPeerID(p.ID), local)
+		if cpl >= min && cpl <= max {
+			return p
+		}
+	}
+}
+
+func TestTableEviction(t *testing.T) {
+	local := ConvertPeerID(_randPeer().ID)
+	rt := NewRoutingTable(3, local, nil)
+
+	var far []*peer.Peer
+	for i := 0; i < 5; i++ {
+		far = append(far, _randPeerAt(local, 0, 0))
+	}
+
+	for _, p := range far[:3] {
+		if rt.Update(p) != nil {
+			t.Fatal("got a peer to check before the bucket was full")
+		}
+	}
+
+	// the oldest peer must be checked before the new one gets in.
+	if oldest := rt.Update(far[3]); oldest != far[0] {
+		t.Fatal("expected the oldest peer to check, got", oldest)
+	}
+	if rt.Find(far[3].ID) != nil {
+		t.Fatal("new peer added to a full bucket")
+	}
+
+	// it responded, so the next oldest is checked.
+	rt.Update(far[0])
+	if oldest := rt.Update(far[4]); oldest != far[1] {
+		t.Fatal("expected the next oldest peer to check, got", oldest)
+	}
+
+	// it did not, so the most recent replacement takes its place.
+	rt.Remove(far[1].ID)
+	if rt.Find(far[1].ID) != nil {
+		t.Fatal("removed peer still in the table")
+	}
+	if rt.Find(far[4].ID) == nil {
+		t.Fatal("replacement did not take the removed peer's place")
+	}
+	if rt.Find(far[3].ID) != nil {
+		t.Fatal("older replacement added too")
+	}
+}
+
+func TestTableSplitNear(t *testing.T) {
+	local := ConvertPeerID(_randPeer().ID)
+	rt := NewRoutingTable(3, local, nil)
+
+	// all peers fall on the near side of the first splits.
+	for i := 0; i < 20; i++ {
+		rt.Update(_randPeerAt(local, 3, 255))
+	}
+
+	for i, b := range rt.Buckets {
+		if b.Len() > 3 {
+			t.Fatalf("bucket %d holds %d peers", i, b.Len())
+		}
+	}
+	if rt.Size() < 3 {
+		t.Fatal("expected a full bucket, table holds", rt.Size())
+	}
+}
+
 func TestTableFind(t *testing.T) {
 	local := _randPeer()
-	rt := NewRoutingTable(10, ConvertPeerID(local.ID))
+	rt := NewRoutingTable(10, ConvertPeerID(local.ID), nil)
 
 	peers := make([]*peer.Peer, 100)
 	for i := 0; i < 5; i++ {
@@ -110,7 +186,7 @@ func TestTableFind(t *testing.T) {
 
 func TestTableFindMultiple(t *testing.T) {
 	local := _randPeer()
-	rt := NewRoutingTable(20, ConvertPeerID(local.ID))
+	rt := NewRoutingTable(20, ConvertPeerID(local.ID), nil)
 
 	peers := make([]*peer.Peer, 100)
 	for i := 0; i < 18; i++ {
@@ -124,3 +200,148 @@ func TestTableFindMultiple(t *testing.T) {
 		t.Fatalf("Got back different number of peers than we expected.")
 	}
 }
+
+func TestRandomIDInBucket(t *testing.T) {
+	rt := NewRoutingTable(20, ConvertPeerID(_randPeer().ID), nil)
+	for i := 0; i < len(rt.local)*8; i++ {
+		if cpl := prefLen(rt.RandomIDInBucket(i), rt.local); cpl != i {
+			t.Fatalf("ID for bucket %d shares %d bits", i, cpl)
+		}
+	}
+}
+
+func TestTableRefresh(t *testing.T) {
+	local := _randPeer()
+	rt := NewRoutingTable(20, ConvertPeerID(local.ID), nil)
+
+	for i := 0; i < 60; i++ {
+		rt.Update(_randPeer())
+	}
+
+	if stale := rt.StaleBuckets(time.Hour); len(stale) != 0 {
+		t.Fatal("expected new buckets fresh, got stale:", stale)
+	}
+	if len(rt.StaleBuckets(0)) != len(rt.Buckets) {
+		t.Fatal("expected all buckets older than 0")
+	}
+
+	for i := range rt.refreshed {
+		rt.refreshed[i] = time.Time{}
+	}
+
+	for i := range rt.Buckets {
+		id := rt.RandomIDInBucket(i)
+		if rt.bucketIndex(id) != i {
+			t.Fatalf("ID for bucket %d falls in bucket %d", i, rt.bucketIndex(id))
+		}
+		rt.MarkRefreshed(id)
+	}
+
+	if stale := rt.StaleBuckets(time.Hour); len(stale) != 0 {
+		t.Fatal("buckets still stale after refresh:", stale)
+	}
+}
+
+// _addrPeer returns a random peer at addr, sharing between min and max
+// prefix bits with local.
+func _addrPeer(t *testing.T, local ID, min, max int, addr string) *peer.Peer {
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := _randPeerAt(local, min, max)
+	p.AddAddress(maddr)
+	return p
+}
+
+func TestPolicyDiversity(t *testing.T) {
+	local := ConvertPeerID(_randPeer().ID)
+	rt := NewRoutingTable(10, local, &Policy{MaxPerIP: 1, MaxPerSubnet: 2})
+
+	a := _addrPeer(t, local, 0, 0, "/ip4/1.2.3.4/tcp/4001")
+	sameIP := _addrPeer(t, local, 0, 0, "/ip4/1.2.3.4/tcp/4002")
+	b := _addrPeer(t, local, 0, 0, "/ip4/1.2.3.5/tcp/4001")
+	sameSubnet := _addrPeer(t, local, 0, 0, "/ip4/1.2.3.6/tcp/4001")
+	other := _addrPeer(t, local, 0, 0, "/ip4/5.6.7.8/tcp/4001")
+
+	for _, p := range []*peer.Peer{a, sameIP, b, sameSubnet, other} {
+		rt.Update(p)
+	}
+
+	for _, p := range []*peer.Peer{a, b, other} {
+		if rt.Find(p.ID) == nil {
+			t.Errorf("peer at %s not admitted", p.Addresses[0])
+		}
+	}
+	for _, p := range []*peer.Peer{sameIP, sameSubnet} {
+		if rt.Find(p.ID) != nil {
+			t.Errorf("peer at %s admitted past the limit", p.Addresses[0])
+		}
+	}
+
+	// loopback peers are not limited.
+	for i := 0; i < 3; i++ {
+		p := _addrPeer(t, local, 0, 0, fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", 5000+i))
+		rt.Update(p)
+		if rt.Find(p.ID) == nil {
+			t.Error("loopback peer not admitted")
+		}
+	}
+
+	// nor are peers of a private network.
+	for i := 0; i < 3; i++ {
+		p := _addrPeer(t, local, 0, 0, fmt.Sprintf("/ip4/192.168.1.%d/tcp/4001", 10+i))
+		rt.Update(p)
+		if rt.Find(p.ID) == nil {
+			t.Error("private peer not admitted")
+		}
+	}
+}
+
+func TestDefaultPolicyAdmitsAll(t *testing.T) {
+	local := ConvertPeerID(_randPeer().ID)
+	rt := NewRoutingTable(10, local, DefaultPolicy)
+
+	for i := 0; i < 3; i++ {
+		p := _addrPeer(t, local, 0, 0, fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", 4001+i))
+		rt.Update(p)
+		if rt.Find(p.ID) == nil {
+			t.Errorf("peer at %s not admitted", p.Addresses[0])
+		}
+	}
+}
+
+func TestPolicyLatency(t *testing.T) {
+	local := ConvertPeerID(_randPeer().ID)
+	rt := NewRoutingTable(20, local, &Policy{PreferLowLatency: true})
+
+	// peers sharing the same prefix with local, which is the target.
+	var peers []*peer.Peer
+	for i := 0; i < 5; i++ {
+		p := _randPeerAt(local, 2, 2)
+		peers = append(peers, p)
+		rt.Update(p)
+	}
+
+	peers[0].SetLatency(time.Millisecond * 50)
+	peers[1].SetLatency(time.Millisecond * 10)
+	peers[2].SetLatency(time.Millisecond * 30)
+
+	found := rt.NearestPeers(local, 5)
+	if len(found) != 5 {
+		t.Fatalf("expected 5 peers, got %d", len(found))
+	}
+	for i, p := range []*peer.Peer{peers[1], peers[2], peers[0]} {
+		if found[i] != p {
+			t.Fatalf("peer %d is not the expected one by latency", i)
+		}
+	}
+
+	// a closer peer still comes first.
+	closer := _randPeerAt(local, 5, 255)
+	rt.Update(closer)
+	if found := rt.NearestPeers(local, 1); found[0] != closer {
+		t.Fatal("expected the closer peer first")
+	}
+}
@@ -0,0 +1,116 @@
+package routing
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	ci "../crypto"
+	u "../util"
+)
+
+// ErrNoValidator signals a key in a namespace no validator is registered
+// for. Such keys cannot be stored.
+var ErrNoValidator = errors.New("no validator for the key's namespace")
+
+// ErrInvalidRecord signals a value that may not be stored under its key.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// ValidatorFunc checks that value may be stored under key.
+type ValidatorFunc func(key u.Key, value []byte) error
+
+// SelectorFunc returns the index of the best of several valid values of
+// key, e.g. the most recent one.
+type SelectorFunc func(key u.Key, values [][]byte) (int, error)
+
+// Validator checks the records of a key namespace, and chooses between
+// conflicting ones.
+type Validator struct {
+	Validate ValidatorFunc
+	Select   SelectorFunc
+}
+
+// Validators maps key namespaces to their Validator. The namespace of
+// /pk/<hash> is "pk".
+type Validators map[string]*Validator
+
+// VerifyRecord checks value with the validator of the namespace of key.
+func (v Validators) VerifyRecord(key u.Key, value []byte) error {
+	val, ok := v[KeyNamespace(key)]
+	if !ok {
+		return ErrNoValidator
+	}
+	return val.Validate(key, value)
+}
+
+// BestRecord returns the index of the best of values, which must all be
+// valid for key.
+func (v Validators) BestRecord(key u.Key, values [][]byte) (int, error) {
+	if len(values) == 0 {
+		return 0, errors.New("no values to select from")
+	}
+
+	val, ok := v[KeyNamespace(key)]
+	if !ok {
+		return 0, ErrNoValidator
+	}
+	if val.Select == nil {
+		return 0, nil
+	}
+	return val.Select(key, values)
+}
+
+// KeyNamespace returns the namespace of key, or "" if it has none.
+func KeyNamespace(key u.Key) string {
+	s := string(key)
+	if !strings.HasPrefix(s, "/") {
+		return ""
+	}
+
+	i := strings.Index(s[1:], "/")
+	if i < 0 {
+		return ""
+	}
+	return s[1 : i+1]
+}
+
+// PlainValidator accepts any value, from anyone, and the value put last
+// wins. Keys without a namespace are refused unless it is registered for
+// them, under "", by callers that trust every peer to write them.
+var PlainValidator = &Validator{
+	Validate: func(key u.Key, value []byte) error {
+		return nil
+	},
+	Select: func(key u.Key, values [][]byte) (int, error) {
+		return len(values) - 1, nil
+	},
+}
+
+// PublicKeyValidator accepts public keys stored under /pk/<hash>, where
+// hash is the hash of the key. Valid keys for a hash are all the same, so
+// any will do.
+var PublicKeyValidator = &Validator{
+	Validate: validatePublicKey,
+	Select: func(key u.Key, values [][]byte) (int, error) {
+		return 0, nil
+	},
+}
+
+func validatePublicKey(key u.Key, value []byte) error {
+	hash := []byte(key)[len("/pk/"):]
+
+	pk, err := ci.UnmarshalPublicKey(value)
+	if err != nil {
+		return ErrInvalidRecord
+	}
+
+	pkhash, err := pk.Hash()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(pkhash, hash) {
+		return ErrInvalidRecord
+	}
+	return nil
+}
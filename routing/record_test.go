@@ -0,0 +1,78 @@
+package routing
+
+import (
+	"testing"
+
+	ci "../crypto"
+	u "../util"
+)
+
+func TestKeyNamespace(t *testing.T) {
+	cases := map[u.Key]string{
+		"/pk/abc":   "pk",
+		"/ipns/a/b": "ipns",
+		"/nothing":  "",
+		"plain":     "",
+		"":          "",
+	}
+	for k, ns := range cases {
+		if got := KeyNamespace(k); got != ns {
+			t.Errorf("namespace of %q: expected %q, got %q", k, ns, got)
+		}
+	}
+}
+
+func TestPublicKeyRecords(t *testing.T) {
+	_, pub, err := ci.GenerateKeyPair(ci.KeyType_RSA, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ci.GenerateKeyPair(ci.KeyType_RSA, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := pub.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := u.Key("/pk/" + string(hash))
+
+	pkbytes, err := pub.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherbytes, err := other.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := Validators{"pk": PublicKeyValidator}
+	if err := v.VerifyRecord(key, pkbytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.VerifyRecord(key, otherbytes); err != ErrInvalidRecord {
+		t.Error("expected ErrInvalidRecord for another key, got", err)
+	}
+	if err := v.VerifyRecord(key, []byte("garbage")); err != ErrInvalidRecord {
+		t.Error("expected ErrInvalidRecord for garbage, got", err)
+	}
+	if err := v.VerifyRecord(u.Key("/ipns/"+string(hash)), pkbytes); err != ErrNoValidator {
+		t.Error("expected ErrNoValidator, got", err)
+	}
+
+	v[""] = PlainValidator
+	if err := v.VerifyRecord(u.Key("hello"), []byte("anything")); err != nil {
+		t.Error("plain key refused:", err)
+	}
+	if i, err := v.BestRecord(u.Key("hello"), [][]byte{[]byte("a"), []byte("b")}); err != nil || i != 1 {
+		t.Errorf("expected the last plain record, got %d, %v", i, err)
+	}
+
+	if _, err := v.BestRecord(key, nil); err == nil {
+		t.Error("selected from no values")
+	}
+	if i, err := v.BestRecord(key, [][]byte{pkbytes, pkbytes}); err != nil || i != 0 {
+		t.Errorf("expected the first record, got %d, %v", i, err)
+	}
+}
@@ -1,34 +1,38 @@
 package routing
 
 import (
+	"context"
+
 	peer "../peer"
 	u "../util"
-	"time"
 )
 
 // IpfsRouting is the routing module interface
 // It is implemented by things like DHTs, etc.
+//
+// All methods stop when their context is done; lookups then return the
+// context's error.
 type IpfsRouting interface {
 
 	// Basic Put/Get
 
 	// PutValue adds value corresponding to given Key.
-	PutValue(key u.Key, value []byte) error
+	PutValue(ctx context.Context, key u.Key, value []byte) error
 
 	// GetValue searches for the value corresponding to given Key.
-	GetValue(key u.Key, timeout time.Duration) ([]byte, error)
+	GetValue(ctx context.Context, key u.Key) ([]byte, error)
 
 	// Value provider layer of indirection.
 	// This is what DSHTs (Coral and MainlineDHT) do to store large values in a DHT.
 
 	// Announce that this node can provide value for given key
-	Provide(key u.Key) error
+	Provide(ctx context.Context, key u.Key) error
 
 	// FindProviders searches for peers who can provide the value for given key.
-	FindProviders(key u.Key, timeout time.Duration) ([]*peer.Peer, error)
+	FindProviders(ctx context.Context, key u.Key) ([]*peer.Peer, error)
 
 	// Find specific Peer
 
 	// FindPeer searches for a peer with given ID.
-	FindPeer(id peer.ID, timeout time.Duration) (*peer.Peer, error)
+	FindPeer(ctx context.Context, id peer.ID) (*peer.Peer, error)
 }
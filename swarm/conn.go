@@ -2,6 +2,7 @@ package swarm
 
 import (
 	"fmt"
+	ident "../identify"
 	"../peer"
 	u "../util"
 	"github.com/jbenet/go-msgio"
@@ -21,6 +22,10 @@ type Conn struct {
 	Closed   chan bool
 	Outgoing *msgio.Chan
 	Incoming *msgio.Chan
+
+	// secure wraps Incoming and Outgoing once the identify handshake is
+	// done. Messages must go through it.
+	secure *ident.SecurePipe
 }
 
 // ConnMap maps Keys (Peer.IDs) to Connections.
@@ -76,6 +81,10 @@ func (s *Conn) Close() error {
 		return fmt.Errorf("Already closed") // already closed
 	}
 
+	if s.secure != nil {
+		s.secure.Close()
+	}
+
 	// closing net connection
 	err := s.Conn.Close()
 	s.Conn = nil
@@ -8,12 +8,13 @@ import (
 )
 
 type Network interface {
-	Send(*Message)
 	Error(error)
 	Find(u.Key) *peer.Peer
+	GetPeerList() []*peer.Peer
 	Listen() error
 	Connect(*ma.Multiaddr) (*peer.Peer, error)
-	GetChan() *Chan
+	GetErrChan() chan error
+	GetChannel(PBWrapper_MessageType) *Chan
 	Close()
 	Drop(*peer.Peer) error
 }
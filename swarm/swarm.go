@@ -23,6 +23,20 @@ type Message struct {
 	Data []byte
 }
 
+// Wrap tags data with the message type of the protocol it belongs to.
+func Wrap(data []byte, typ PBWrapper_MessageType) ([]byte, error) {
+	return proto.Marshal(&PBWrapper{Type: &typ, Message: data})
+}
+
+// Unwrap reads the message type and data of a wrapped message.
+func Unwrap(data []byte) (*PBWrapper, error) {
+	wrapper := new(PBWrapper)
+	if err := proto.Unmarshal(data, wrapper); err != nil {
+		return nil, err
+	}
+	return wrapper, nil
+}
+
 // Cleaner looking helper function to make a new message struct
 func NewMessage(p *peer.Peer, data proto.Message) *Message {
 	bytes, err := proto.Marshal(data)
@@ -77,11 +91,18 @@ func (se *SwarmListenErr) Error() string {
 // be opened and closed, while still using the same Chan for all
 // communication. The Chan sends/receives Messages, which note the
 // destination or source Peer.
+//
+// Every service sharing the swarm gets a Chan of its own from GetChannel.
+// Messages are wrapped with the service's PBWrapper_MessageType on the
+// wire, and incoming messages are dispatched to the Chan of their type.
 type Swarm struct {
 	Chan      *Chan
 	conns     ConnMap
 	connsLock sync.RWMutex
 
+	filterChans map[PBWrapper_MessageType]*Chan
+	filterLock  sync.Mutex
+
 	local     *peer.Peer
 	listeners []net.Listener
 }
@@ -89,9 +110,10 @@ type Swarm struct {
 // NewSwarm constructs a Swarm, with a Chan.
 func NewSwarm(local *peer.Peer) *Swarm {
 	s := &Swarm{
-		Chan:  NewChan(10),
-		conns: ConnMap{},
-		local: local,
+		Chan:        NewChan(10),
+		conns:       ConnMap{},
+		filterChans: make(map[PBWrapper_MessageType]*Chan),
+		local:       local,
 	}
 	go s.fanOut()
 	return s
@@ -160,7 +182,7 @@ func (s *Swarm) handleNewConn(nconn net.Conn) {
 	}
 	newConnChans(conn)
 
-	err := ident.Handshake(s.local, p, conn.Incoming.MsgChan, conn.Outgoing.MsgChan)
+	err := s.handshake(conn)
 	if err != nil {
 		u.PErr(err.Error())
 		conn.Close()
@@ -168,12 +190,19 @@ func (s *Swarm) handleNewConn(nconn net.Conn) {
 	}
 
 	// Get address to contact remote peer from
-	addr := <-conn.Incoming.MsgChan
+	addr, ok := <-conn.secure.In
+	if !ok {
+		u.PErr("Connection closed before peer sent its address.")
+		conn.Close()
+		return
+	}
+
 	maddr, err := ma.NewMultiaddr(string(addr))
 	if err != nil {
 		u.PErr("Got invalid address from peer.")
+	} else {
+		p.AddAddress(maddr)
 	}
-	p.AddAddress(maddr)
 
 	s.StartConn(conn)
 }
@@ -190,6 +219,12 @@ func (s *Swarm) Close() {
 	s.Chan.Close <- true // fan out
 	s.Chan.Close <- true // listener
 
+	s.filterLock.Lock()
+	for _, ch := range s.filterChans {
+		ch.Close <- true // muxChan
+	}
+	s.filterLock.Unlock()
+
 	for _, list := range s.listeners {
 		list.Close()
 	}
@@ -220,10 +255,49 @@ func (s *Swarm) Dial(peer *peer.Peer) (*Conn, error) {
 		return nil, err
 	}
 
+	if err := s.dialHandshake(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
 	s.StartConn(conn)
 	return conn, nil
 }
 
+// handshake authenticates the remote peer, and sets up the encrypted
+// channels all further messages go through.
+func (s *Swarm) handshake(conn *Conn) error {
+	sp, err := ident.Handshake(s.local, conn.Peer, conn.Incoming.MsgChan, conn.Outgoing.MsgChan)
+	if err != nil {
+		return err
+	}
+
+	conn.secure = sp
+	return nil
+}
+
+// dialHandshake is the handshake for connections we open: the remote
+// also needs an address to reach us on.
+func (s *Swarm) dialHandshake(conn *Conn) error {
+	if err := s.handshake(conn); err != nil {
+		return err
+	}
+
+	// Send node an address that you can be reached on
+	myaddr := s.local.NetAddress("tcp")
+	if myaddr == nil {
+		return errors.New("No local address to send to peer.")
+	}
+
+	mastr, err := myaddr.String()
+	if err != nil {
+		return errors.New("No local address to send to peer.")
+	}
+
+	conn.secure.Out <- []byte(mastr)
+	return nil
+}
+
 func (s *Swarm) StartConn(conn *Conn) error {
 	if conn == nil {
 		return errors.New("Tried to start nil connection.")
@@ -264,7 +338,7 @@ func (s *Swarm) fanOut() {
 			}
 
 			// queue it in the connection's buffer
-			conn.Outgoing.MsgChan <- msg.Data
+			conn.secure.Out <- msg.Data
 		}
 	}
 }
@@ -282,16 +356,35 @@ func (s *Swarm) fanIn(conn *Conn) {
 		case <-conn.Closed:
 			goto out
 
-		case data, ok := <-conn.Incoming.MsgChan:
+		case data, ok := <-conn.secure.In:
 			if !ok {
 				e := fmt.Errorf("Error retrieving from conn: %v", conn.Peer.Key().Pretty())
 				s.Chan.Errors <- e
 				goto out
 			}
 
+			wrapper, err := Unwrap(data)
+			if err != nil {
+				u.PErr("Failed to decode message from %s: %v", conn.Peer.Key().Pretty(), err)
+				continue
+			}
+
+			s.filterLock.Lock()
+			ch, found := s.filterChans[wrapper.GetType()]
+			s.filterLock.Unlock()
+
+			if !found {
+				u.DOut("Dropping message of unhandled type: %s", wrapper.GetType())
+				continue
+			}
+
 			// wrap it for consumers.
-			msg := &Message{Peer: conn.Peer, Data: data}
-			s.Chan.Incoming <- msg
+			msg := &Message{Peer: conn.Peer, Data: wrapper.GetMessage()}
+			select {
+			case ch.Incoming <- msg:
+			case <-conn.Closed:
+				goto out
+			}
 		}
 	}
 out:
@@ -301,14 +394,64 @@ out:
 	s.connsLock.Unlock()
 }
 
+// GetChannel returns the Chan of the service speaking typ. Messages sent
+// on it are tagged with typ, and only incoming messages tagged with typ
+// arrive on it.
+func (s *Swarm) GetChannel(typ PBWrapper_MessageType) *Chan {
+	s.filterLock.Lock()
+	defer s.filterLock.Unlock()
+
+	ch, found := s.filterChans[typ]
+	if !found {
+		ch = NewChan(10)
+		s.filterChans[typ] = ch
+		go s.muxChan(ch, typ)
+	}
+	return ch
+}
+
+// muxChan wraps the messages sent on ch with typ, and sends them out.
+func (s *Swarm) muxChan(ch *Chan, typ PBWrapper_MessageType) {
+	for {
+		select {
+		case <-ch.Close:
+			return
+		case msg, ok := <-ch.Outgoing:
+			if !ok {
+				return
+			}
+
+			data, err := Wrap(msg.Data, typ)
+			if err != nil {
+				u.PErr("Failed to wrap %s message: %v", typ, err)
+				continue
+			}
+			s.Chan.Outgoing <- &Message{Peer: msg.Peer, Data: data}
+		}
+	}
+}
+
 func (s *Swarm) Find(key u.Key) *peer.Peer {
+	s.connsLock.RLock()
 	conn, found := s.conns[key]
+	s.connsLock.RUnlock()
 	if !found {
 		return nil
 	}
 	return conn.Peer
 }
 
+// GetPeerList returns the peers this swarm has open connections to.
+func (s *Swarm) GetPeerList() []*peer.Peer {
+	var out []*peer.Peer
+	s.connsLock.RLock()
+	for _, conn := range s.conns {
+		out = append(out, conn.Peer)
+	}
+	s.connsLock.RUnlock()
+	return out
+}
+
 func (s *Swarm) Connect(addr *ma.Multiaddr) (*peer.Peer, error) {
 	if addr == nil {
 		return nil, errors.New("nil Multiaddr passed to swarm.Connect()")
@@ -321,20 +464,12 @@ func (s *Swarm) Connect(addr *ma.Multiaddr) (*peer.Peer, error) {
 		return nil, err
 	}
 
-	err = ident.Handshake(s.local, npeer, conn.Incoming.MsgChan, conn.Outgoing.MsgChan)
+	err = s.dialHandshake(conn)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	// Send node an address that you can be reached on
-	myaddr := s.local.NetAddress("tcp")
-	mastr, err := myaddr.String()
-	if err != nil {
-		return nil, errors.New("No local address to send to peer.")
-	}
-
-	conn.Outgoing.MsgChan <- []byte(mastr)
-
 	s.StartConn(conn)
 
 	return npeer, nil
@@ -356,16 +491,13 @@ func (s *Swarm) Drop(p *peer.Peer) error {
 	return conn.Close()
 }
 
-func (s *Swarm) Send(mes *Message) {
-	s.Chan.Outgoing <- mes
-}
-
 func (s *Swarm) Error(e error) {
 	s.Chan.Errors <- e
 }
 
-func (s *Swarm) GetChan() *Chan {
-	return s.Chan
+// GetErrChan returns the channel errors of the swarm are reported on.
+func (s *Swarm) GetErrChan() chan error {
+	return s.Chan.Errors
 }
 
 var _ Network = &Swarm{}
@@ -1,96 +1,96 @@
 package swarm
 
 import (
-	"fmt"
+	ci "../crypto"
 	"../peer"
 	u "../util"
-	"github.com/jbenet/go-msgio"
-	"net"
+	"fmt"
+	ma "github.com/multiformats/go-multiaddr"
 	"testing"
+	"time"
 )
 
-func pingListen(listener *net.TCPListener, peer *peer.Peer) {
-	for {
-		c, err := listener.Accept()
-		if err == nil {
-			fmt.Println("accepeted")
-			go pong(c, peer)
-		}
+// setupKeyedPeer returns a peer with a fresh identity, listening on addr.
+func setupKeyedPeer(t *testing.T, addr string) *peer.Peer {
+	sk, pk, err := ci.GenerateKeyPair(ci.KeyType_Ed25519, 0)
+	if err != nil {
+		t.Fatal("error generating keys", err)
+	}
+
+	id, err := peer.IDFromPubKey(pk)
+	if err != nil {
+		t.Fatal("error deriving peer id", err)
 	}
+
+	tcp, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		t.Fatal("error parsing address", err)
+	}
+
+	p := &peer.Peer{ID: id, PrivKey: sk, PubKey: pk}
+	p.AddAddress(tcp)
+	return p
 }
 
-func pong(c net.Conn, peer *peer.Peer) {
-	mrw := msgio.NewReadWriter(c)
-	for {
-		data := make([]byte, 1024)
-		n, err := mrw.ReadMsg(data)
-		if err != nil {
-			fmt.Printf("error %v\n", err)
-			return
-		}
-		if string(data[:n]) != "ping" {
-			fmt.Printf("error: didn't receive ping: '%v'\n", data[:n])
-			return
-		}
-		err = mrw.WriteMsg([]byte("pong"))
-		if err != nil {
-			fmt.Printf("error %v\n", err)
-			return
+// pong answers every ping ch receives.
+func pong(ch *Chan) {
+	for msg := range ch.Incoming {
+		if string(msg.Data) != "ping" {
+			fmt.Printf("error: didn't receive ping: '%v'\n", msg.Data)
+			continue
 		}
+		ch.Outgoing <- &Message{Peer: msg.Peer, Data: []byte("pong")}
 	}
 }
 
 func TestSwarm(t *testing.T) {
+	local := setupKeyedPeer(t, "/ip4/127.0.0.1/tcp/1233")
+	swarm := NewSwarm(local)
+	ch := swarm.GetChannel(PBWrapper_TEST)
 
-	swarm := NewSwarm(nil)
 	var peers []*peer.Peer
-	var listeners []net.Listener
-	peerNames := map[string]string{
-		"11140beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a30": "/ip4/127.0.0.1/tcp/1234",
-		"11140beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a31": "/ip4/127.0.0.1/tcp/2345",
-		"11140beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a32": "/ip4/127.0.0.1/tcp/3456",
-		"11140beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33": "/ip4/127.0.0.1/tcp/4567",
+	var remotes []*Swarm
+	addrs := []string{
+		"/ip4/127.0.0.1/tcp/1234",
+		"/ip4/127.0.0.1/tcp/2345",
+		"/ip4/127.0.0.1/tcp/3456",
+		"/ip4/127.0.0.1/tcp/4567",
 	}
 
-	for k, n := range peerNames {
-		peer, err := setupPeer(k, n)
-		if err != nil {
-			t.Fatal("error setting up peer", err)
-		}
-		a := peer.NetAddress("tcp")
-		if a == nil {
-			t.Fatal("error setting up peer (addr is nil)", peer)
-		}
-		n, h, err := a.DialArgs()
-		if err != nil {
-			t.Fatal("error getting dial args from addr")
-		}
-		listener, err := net.Listen(n, h)
-		if err != nil {
+	for _, addr := range addrs {
+		p := setupKeyedPeer(t, addr)
+		remote := NewSwarm(p)
+		if err := remote.Listen(); err != nil {
 			t.Fatal("error setting up listener", err)
 		}
-		go pingListen(listener.(*net.TCPListener), peer)
+		go pong(remote.GetChannel(PBWrapper_TEST))
 
-		_, err = swarm.Dial(peer)
+		// dial with only what we know of the peer: its ID and address.
+		dialed := &peer.Peer{ID: p.ID, Addresses: p.Addresses}
+		_, err := swarm.Dial(dialed)
 		if err != nil {
 			t.Fatal("error swarm dialing to peer", err)
 		}
 
+		if dialed.PubKey == nil || !dialed.PubKey.Equals(p.PubKey) {
+			t.Error("handshake did not learn the peer's key")
+		}
+
 		// ok done, add it.
-		peers = append(peers, peer)
-		listeners = append(listeners, listener)
+		peers = append(peers, dialed)
+		remotes = append(remotes, remote)
 	}
 
 	MsgNum := 1000
 	for k := 0; k < MsgNum; k++ {
 		for _, p := range peers {
-			swarm.Chan.Outgoing <- &Message{Peer: p, Data: []byte("ping")}
+			ch.Outgoing <- &Message{Peer: p, Data: []byte("ping")}
 		}
 	}
 
 	got := map[u.Key]int{}
 	for k := 0; k < (MsgNum * len(peers)); k++ {
-		msg := <-swarm.Chan.Incoming
+		msg := <-ch.Incoming
 		if string(msg.Data) != "pong" {
 			t.Error("unexpected conn output", msg.Data)
 		}
@@ -111,7 +111,77 @@ func TestSwarm(t *testing.T) {
 
 	fmt.Println("closing")
 	swarm.Close()
-	for _, listener := range listeners {
-		listener.(*net.TCPListener).Close()
+	for _, remote := range remotes {
+		remote.Close()
+	}
+}
+
+func TestSwarmDialWrongID(t *testing.T) {
+	local := setupKeyedPeer(t, "/ip4/127.0.0.1/tcp/1235")
+	swarm := NewSwarm(local)
+	defer swarm.Close()
+
+	p := setupKeyedPeer(t, "/ip4/127.0.0.1/tcp/5678")
+	remote := NewSwarm(p)
+	if err := remote.Listen(); err != nil {
+		t.Fatal("error setting up listener", err)
+	}
+	defer remote.Close()
+
+	other := setupKeyedPeer(t, "/ip4/127.0.0.1/tcp/5679")
+	impostor := &peer.Peer{ID: other.ID, Addresses: p.Addresses}
+	if _, err := swarm.Dial(impostor); err == nil {
+		t.Fatal("dialing a peer under the wrong ID should fail")
+	}
+}
+
+func TestSwarmProtocols(t *testing.T) {
+	local := setupKeyedPeer(t, "/ip4/127.0.0.1/tcp/1236")
+	swarm := NewSwarm(local)
+	defer swarm.Close()
+
+	p := setupKeyedPeer(t, "/ip4/127.0.0.1/tcp/6789")
+	remote := NewSwarm(p)
+	if err := remote.Listen(); err != nil {
+		t.Fatal("error setting up listener", err)
+	}
+	defer remote.Close()
+
+	dht := remote.GetChannel(PBWrapper_DHT_MESSAGE)
+	bitswap := remote.GetChannel(PBWrapper_BITSWAP)
+
+	dialed := &peer.Peer{ID: p.ID, Addresses: p.Addresses}
+	if _, err := swarm.Dial(dialed); err != nil {
+		t.Fatal("error swarm dialing to peer", err)
+	}
+
+	// nobody handles TEST messages on the remote: they are dropped.
+	swarm.GetChannel(PBWrapper_TEST).Outgoing <- &Message{Peer: dialed, Data: []byte("test")}
+	swarm.GetChannel(PBWrapper_BITSWAP).Outgoing <- &Message{Peer: dialed, Data: []byte("bitswap")}
+	swarm.GetChannel(PBWrapper_DHT_MESSAGE).Outgoing <- &Message{Peer: dialed, Data: []byte("dht")}
+
+	for _, c := range []struct {
+		ch   *Chan
+		data string
+	}{{bitswap, "bitswap"}, {dht, "dht"}} {
+		select {
+		case msg := <-c.ch.Incoming:
+			if string(msg.Data) != c.data {
+				t.Errorf("expected %q, got %q", c.data, msg.Data)
+			}
+			if !msg.Peer.ID.Equal(local.ID) {
+				t.Error("message not from the dialing peer")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s message did not arrive", c.data)
+		}
+	}
+
+	select {
+	case msg := <-dht.Incoming:
+		t.Error("unexpected message", string(msg.Data))
+	case msg := <-bitswap.Incoming:
+		t.Error("unexpected message", string(msg.Data))
+	case <-time.After(time.Millisecond * 50):
 	}
 }
@@ -0,0 +1,152 @@
+// Package unixfs implements the data format stored in merkledag nodes to
+// represent unix files and directories.
+package unixfs
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	proto "github.com/golang/protobuf/proto"
+)
+
+// ErrMalformedFileFormat signals node data that does not hold a unixfs
+// envelope.
+var ErrMalformedFileFormat = errors.New("malformed data in file format")
+
+// ErrIsDir signals that a file operation was attempted on a directory.
+var ErrIsDir = errors.New("this dag node is a directory")
+
+// Aliases for the envelope types.
+const (
+	TRaw       = PBData_Raw
+	TDirectory = PBData_Directory
+	TFile      = PBData_File
+)
+
+// FSNode is the decoded form of a unixfs envelope.
+type FSNode struct {
+	Type PBData_DataType
+
+	// Data is the file data held directly in this node.
+	Data []byte
+
+	// Blocksizes are the sizes of the file data under each link.
+	Blocksizes []uint64
+
+	// Mode holds the unix permission bits. Zero means unset.
+	Mode os.FileMode
+
+	// Mtime is the modification time. The zero time means unset.
+	Mtime time.Time
+
+	subtotal uint64
+}
+
+// AddBlockSize records the file data size under a newly added link.
+func (n *FSNode) AddBlockSize(s uint64) {
+	n.subtotal += s
+	n.Blocksizes = append(n.Blocksizes, s)
+}
+
+// FileSize returns the total size of the file data under this node.
+func (n *FSNode) FileSize() uint64 {
+	return uint64(len(n.Data)) + n.subtotal
+}
+
+// GetBytes encodes the FSNode, for use as merkledag.Node.Data.
+func (n *FSNode) GetBytes() ([]byte, error) {
+	pbn := new(PBData)
+	pbn.Type = n.Type.Enum()
+	pbn.Data = n.Data
+	pbn.Blocksizes = n.Blocksizes
+	if n.Type != TDirectory {
+		pbn.Filesize = proto.Uint64(n.FileSize())
+	}
+	if n.Mode != 0 {
+		pbn.Mode = proto.Uint32(uint32(n.Mode.Perm()))
+	}
+	if !n.Mtime.IsZero() {
+		pbn.Mtime = proto.Int64(n.Mtime.Unix())
+	}
+	return proto.Marshal(pbn)
+}
+
+// FSNodeFromBytes decodes merkledag.Node.Data into an FSNode.
+func FSNodeFromBytes(b []byte) (*FSNode, error) {
+	pbn := new(PBData)
+	if err := proto.Unmarshal(b, pbn); err != nil {
+		return nil, ErrMalformedFileFormat
+	}
+
+	n := &FSNode{
+		Type:       pbn.GetType(),
+		Data:       pbn.GetData(),
+		Blocksizes: pbn.GetBlocksizes(),
+		Mode:       os.FileMode(pbn.GetMode()),
+	}
+	if pbn.Mtime != nil {
+		n.Mtime = time.Unix(pbn.GetMtime(), 0)
+	}
+	for _, s := range n.Blocksizes {
+		n.subtotal += s
+	}
+	return n, nil
+}
+
+// FilePBData returns the envelope for a file node holding data directly.
+func FilePBData(data []byte) []byte {
+	n := &FSNode{Type: TFile, Data: data}
+	b, err := n.GetBytes()
+	if err != nil {
+		// marshaling a well formed envelope does not fail.
+		panic(err)
+	}
+	return b
+}
+
+// FolderPBData returns the envelope for an empty directory node.
+func FolderPBData() []byte {
+	n := &FSNode{Type: TDirectory}
+	b, err := n.GetBytes()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// WrapData returns the envelope for raw data.
+func WrapData(data []byte) []byte {
+	n := &FSNode{Type: TRaw, Data: data}
+	b, err := n.GetBytes()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// UnwrapData returns the data held directly in an envelope.
+func UnwrapData(b []byte) ([]byte, error) {
+	n, err := FSNodeFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return n.Data, nil
+}
+
+// DataSize returns the size of the file data under the node with the
+// given envelope. Directories have no data size.
+func DataSize(b []byte) (uint64, error) {
+	pbn := new(PBData)
+	if err := proto.Unmarshal(b, pbn); err != nil {
+		return 0, ErrMalformedFileFormat
+	}
+
+	switch pbn.GetType() {
+	case TDirectory:
+		return 0, ErrIsDir
+	case TFile:
+		return pbn.GetFilesize(), nil
+	}
+	return uint64(len(pbn.GetData())), nil
+}
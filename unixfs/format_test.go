@@ -0,0 +1,66 @@
+package unixfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFSNode(t *testing.T) {
+	fsn := &FSNode{
+		Type:  TFile,
+		Data:  []byte("beep"),
+		Mode:  0644,
+		Mtime: time.Unix(1400000000, 0),
+	}
+	fsn.AddBlockSize(100)
+	fsn.AddBlockSize(200)
+
+	b, err := fsn.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := FSNodeFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Type != TFile || !bytes.Equal(out.Data, fsn.Data) {
+		t.Error("type or data did not round trip")
+	}
+
+	if out.FileSize() != 304 || len(out.Blocksizes) != 2 {
+		t.Error("sizes did not round trip", out.FileSize(), out.Blocksizes)
+	}
+
+	if out.Mode != os.FileMode(0644) || !out.Mtime.Equal(fsn.Mtime) {
+		t.Error("mode or mtime did not round trip", out.Mode, out.Mtime)
+	}
+
+	size, err := DataSize(b)
+	if err != nil || size != 304 {
+		t.Error("DataSize does not read the file size", size, err)
+	}
+}
+
+func TestEnvelopeTypes(t *testing.T) {
+	if _, err := DataSize(FolderPBData()); err != ErrIsDir {
+		t.Error("directories should have no data size")
+	}
+
+	data, err := UnwrapData(WrapData([]byte("beep boop")))
+	if err != nil || string(data) != "beep boop" {
+		t.Error("raw data did not round trip", err)
+	}
+
+	data, err = UnwrapData(FilePBData([]byte("beep boop")))
+	if err != nil || string(data) != "beep boop" {
+		t.Error("file data did not round trip", err)
+	}
+
+	if _, err := FSNodeFromBytes([]byte{0xff, 0xff}); err != ErrMalformedFileFormat {
+		t.Error("expected malformed data error, got", err)
+	}
+}